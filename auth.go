@@ -12,18 +12,40 @@ type Service interface {
 	Signup(ctx context.Context, signup SignupInput) error
 	Signin(ctx context.Context, signin SigninInput) (*UserSignin, error)
 	SigninSocial(ctx context.Context, signin SigninSocialInput) (*UserSigninSocial, error)
+	SigninSocialCode(ctx context.Context, signin SigninSocialInput, codeChallenge string) (string, error)
+	ExchangeSigninCode(ctx context.Context, exchange SigninCodeInput) (*SigninCodeExchange, error)
 	LinkUserAccount(ctx context.Context, link LinkUserAccountInput) error
+	ConfirmAccountLink(ctx context.Context, confirm ConfirmAccountLinkInput) (*UserSigninSocial, error)
+	VerifyAccountLink(ctx context.Context, token TokenInput) error
 	SendVerificationEmail(ctx context.Context, address string) error
 	VerifyEmail(ctx context.Context, token TokenInput) error
 	SendPasswordResetEmail(ctx context.Context, address string) error
 	ResetPassword(ctx context.Context, reset ResetPasswordInput) error
+	SendPasswordSetupEmail(ctx context.Context, uid int) error
+	PasswordSetupCode(ctx context.Context, account AccountInput, codeChallenge string) (string, error)
+	SetPassword(ctx context.Context, set SetPasswordInput) error
 	UserConfirmation(ctx context.Context, uid int, password string) (string, error)
+	ConfirmUser(ctx context.Context, confirm ConfirmationInput) (string, error)
+	SendConfirmationEmail(ctx context.Context, uid int) error
+	ProviderConfirmationCode(ctx context.Context, account AccountInput, codeChallenge string) (string, error)
+	SetupTOTP(ctx context.Context, setup TOTPInput) (*TOTPSetup, error)
+	EnableTOTP(ctx context.Context, uid int, code string) error
+	DisableTOTP(ctx context.Context, disable TOTPInput) error
 	AddEmail(ctx context.Context, uid int, address string) error
 	UpdatePrimaryEmail(ctx context.Context, uid int, address string) error
 	GetUserSettings(ctx context.Context, uid int) (*UserSettings, error)
+	UnlinkUserAccount(ctx context.Context, uid int, provider string) error
+	GetProviderToken(ctx context.Context, uid int, provider string) (*ProviderTokens, error)
+	DeleteUser(ctx context.Context, del DeleteUserInput) error
 	UpdateUsername(ctx context.Context, uid int, username string) error
+	UpdateLocale(ctx context.Context, uid int, locale string) error
 	UpdatePassword(ctx context.Context, password UpdatePasswordInput) error
+	PasswordStrength(ctx context.Context, password PasswordStrengthInput) (*PasswordStrength, error)
 	GetUser(ctx context.Context, token TokenInput) (*User, error)
+	WebhookService
+	OIDCService
+	MachineService
+	EmailDomainService
 }
 
 //
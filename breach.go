@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BreachedPasswords tells if a password appeared in a known data breach, without calling an external API.
+type BreachedPasswords interface {
+	Breached(password string) (bool, error)
+}
+
+//
+// Breach file
+//
+
+// BreachFile is a local breach corpus of SHA-1 hashes sorted by hash, one "HASH:COUNT" per line,
+// e.g. the "ordered by hash" download of Pwned Passwords. It's searched on the disk, without loading it.
+type BreachFile struct {
+	f    *os.File
+	size int64
+}
+
+// OpenBreachFile opens a SHA-1 prefix-sorted breach file.
+func OpenBreachFile(name string) (*BreachFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &BreachFile{f: f, size: fi.Size()}, nil
+}
+
+func (b *BreachFile) Close() error {
+	return b.f.Close()
+}
+
+// Breached binary searches the lines of the file for the SHA-1 hash of the password.
+func (b *BreachFile) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// lo is always the start of a line, and the lines starting in [lo, hi) are searched.
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, next, line, err := b.lineFrom(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		h, _, _ := strings.Cut(line, ":")
+		switch c := strings.Compare(strings.ToUpper(h), hash); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+	return false, nil
+}
+
+// lineFrom returns the first line starting at or after the offset, with its start and the start of the next line.
+func (b *BreachFile) lineFrom(off int64) (start, next int64, line string, err error) {
+	start = off
+	if off > 0 {
+		r := bufio.NewReader(io.NewSectionReader(b.f, off-1, b.size-off+1))
+		skipped, err := r.ReadString('\n')
+		if err == io.EOF {
+			return b.size, b.size, "", nil
+		} else if err != nil {
+			return 0, 0, "", err
+		}
+		start = off - 1 + int64(len(skipped))
+	}
+	r := bufio.NewReader(io.NewSectionReader(b.f, start, b.size-start))
+	line, err = r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, 0, "", err
+	}
+	return start, start + int64(len(line)), strings.TrimSpace(line), nil
+}
+
+// ParseBreachLine parses a "HASH:COUNT" line of a breach file, the count is 1 if it's missing.
+func ParseBreachLine(line string) (sum [sha1.Size]byte, count int, err error) {
+	h, c, found := strings.Cut(strings.TrimSpace(line), ":")
+	if hex.DecodedLen(len(h)) != sha1.Size {
+		return sum, 0, fmt.Errorf("invalid sha-1 hash: %q", h)
+	}
+	if _, err := hex.Decode(sum[:], []byte(h)); err != nil {
+		return sum, 0, fmt.Errorf("invalid sha-1 hash: %q", h)
+	}
+	count = 1
+	if found {
+		count, err = strconv.Atoi(c)
+		if err != nil {
+			return sum, 0, fmt.Errorf("invalid count: %q", c)
+		}
+	}
+	return sum, count, nil
+}
+
+//
+// Bloom filter
+//
+
+var bloomMagic = []byte("ABF1")
+
+// BloomFilter is a compact breach corpus of SHA-1 hashes, built from a breach file by cmd/breachfilter.
+// It has no false negatives, and its false positives are rare passwords rejected as breached.
+type BloomFilter struct {
+	k    uint32
+	m    uint64
+	bits []uint64
+}
+
+// NewBloomFilter returns an empty filter for n hashes with the false positive rate, e.g. 0.001.
+func NewBloomFilter(n int, fpRate float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &BloomFilter{k: k, m: m, bits: make([]uint64, (m+63)/64)}
+}
+
+// OpenBloomFilter reads a filter file written by BloomFilter.WriteTo.
+func OpenBloomFilter(name string) (*BloomFilter, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBloomFilter(bufio.NewReader(f))
+}
+
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	magic := make([]byte, len(bloomMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, bloomMagic) {
+		return nil, errors.New("not a bloom filter file")
+	}
+	b := &BloomFilter{}
+	if err := binary.Read(r, binary.LittleEndian, &b.k); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &b.m); err != nil {
+		return nil, err
+	}
+	if b.k == 0 || b.m == 0 {
+		return nil, errors.New("invalid bloom filter header")
+	}
+	b.bits = make([]uint64, (b.m+63)/64)
+	if err := binary.Read(r, binary.LittleEndian, b.bits); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WriteTo writes the filter in the format read by ReadBloomFilter.
+func (b *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	if _, err := cw.Write(bloomMagic); err != nil {
+		return cw.n, err
+	}
+	for _, v := range []any{b.k, b.m, b.bits} {
+		if err := binary.Write(cw, binary.LittleEndian, v); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// Add adds the SHA-1 hash of a password.
+func (b *BloomFilter) Add(sum [sha1.Size]byte) {
+	h1, h2 := bloomHashes(sum)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		j := (h1 + i*h2) % b.m
+		b.bits[j/64] |= 1 << (j % 64)
+	}
+}
+
+// Contains returns true if the SHA-1 hash is probably added.
+func (b *BloomFilter) Contains(sum [sha1.Size]byte) bool {
+	h1, h2 := bloomHashes(sum)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		j := (h1 + i*h2) % b.m
+		if b.bits[j/64]&(1<<(j%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BloomFilter) Breached(password string) (bool, error) {
+	return b.Contains(sha1.Sum([]byte(password))), nil
+}
+
+//
+// Helpers
+//
+
+// bloomHashes splits the hash into the two hashes of the double hashing, sha-1 is already uniform.
+func bloomHashes(sum [sha1.Size]byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeBreachFile writes the SHA-1 hashes of the passwords sorted by hash, the lines are separated by eol.
+func writeBreachFile(t *testing.T, passwords []string, eol string, trailing, lower bool) string {
+	t.Helper()
+	lines := make([]string, len(passwords))
+	for i, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		h := strings.ToUpper(hex.EncodeToString(sum[:]))
+		if lower {
+			h = strings.ToLower(h)
+		}
+		lines[i] = fmt.Sprintf("%s:%d", h, i+1)
+	}
+	sort.Slice(lines, func(i, j int) bool { return strings.ToUpper(lines[i]) < strings.ToUpper(lines[j]) })
+
+	name := filepath.Join(t.TempDir(), "breach.txt")
+	data := strings.Join(lines, eol)
+	if trailing {
+		data += eol
+	}
+	if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func testPasswords(prefix string, n int) []string {
+	pp := make([]string, n)
+	for i := range pp {
+		pp[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return pp
+}
+
+func TestBreachFile(t *testing.T) {
+	breached := testPasswords("breached", 1000)
+	safe := testPasswords("safe", 1000)
+
+	tests := []struct {
+		name      string
+		passwords []string
+		eol       string
+		trailing  bool
+		lower     bool
+	}{
+		{"lf", breached, "\n", true, false},
+		{"crlf", breached, "\r\n", true, false},
+		{"no trailing line ending", breached, "\r\n", false, false},
+		{"lower case", breached, "\n", true, true},
+		{"single line", breached[:1], "\n", false, false},
+		{"two lines", breached[:2], "\r\n", true, false},
+		{"empty", nil, "\n", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := OpenBreachFile(writeBreachFile(t, tt.passwords, tt.eol, tt.trailing, tt.lower))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+
+			for _, p := range tt.passwords {
+				if ok, err := b.Breached(p); err != nil || !ok {
+					t.Fatalf("Breached(%q) = %v, %v, want true", p, ok, err)
+				}
+			}
+			for _, p := range safe {
+				if ok, err := b.Breached(p); err != nil || ok {
+					t.Fatalf("Breached(%q) = %v, %v, want false", p, ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseBreachLine(t *testing.T) {
+	sum := sha1.Sum([]byte("password"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	tests := []struct {
+		line    string
+		count   int
+		wantErr bool
+	}{
+		{hash + ":3861493", 3861493, false},
+		{strings.ToLower(hash) + ":1\r\n", 1, false},
+		{hash, 1, false},
+		{hash + ":x", 0, true},
+		{hash[:39] + ":1", 0, true},
+		{"Z" + hash[1:] + ":1", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, count, err := ParseBreachLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBreachLine(%q) error = %v", tt.line, err)
+			continue
+		}
+		if !tt.wantErr && (got != sum || count != tt.count) {
+			t.Errorf("ParseBreachLine(%q) = %x, %d", tt.line, got, count)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	breached := testPasswords("breached", 10000)
+	safe := testPasswords("safe", 10000)
+	const fpRate = 0.01
+
+	b := NewBloomFilter(len(breached), fpRate)
+	for _, p := range breached {
+		b.Add(sha1.Sum([]byte(p)))
+	}
+
+	var buf bytes.Buffer
+	n, err := b.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo() = %d, %v, wrote %d", n, err, buf.Len())
+	}
+	read, err := ReadBloomFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []*BloomFilter{b, read} {
+		// there are no false negatives.
+		for _, p := range breached {
+			if ok, err := f.Breached(p); err != nil || !ok {
+				t.Fatalf("Breached(%q) = %v, %v, want true", p, ok, err)
+			}
+		}
+		fp := 0
+		for _, p := range safe {
+			if ok, _ := f.Breached(p); ok {
+				fp++
+			}
+		}
+		if rate := float64(fp) / float64(len(safe)); rate > 2*fpRate {
+			t.Errorf("false positive rate = %.4f, want about %.2f", rate, fpRate)
+		}
+	}
+}
+
+func TestReadBloomFilterInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	NewBloomFilter(10, 0.01).WriteTo(&buf)
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"wrong magic", append([]byte("XXXX"), valid[4:]...)},
+		{"truncated header", valid[:6]},
+		{"truncated bits", valid[:len(valid)-1]},
+		{"zero header", append([]byte("ABF1"), make([]byte, 12)...)},
+	}
+	for _, tt := range tests {
+		if _, err := ReadBloomFilter(bytes.NewReader(tt.data)); err == nil {
+			t.Errorf("ReadBloomFilter(%s) succeeded", tt.name)
+		}
+	}
+}
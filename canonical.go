@@ -0,0 +1,69 @@
+package auth
+
+import "strings"
+
+// EmailCanonicalizer returns the canonical form of an email address. The aliases of a mailbox,
+// e.g. "n.a.m.e+1@gmail.com" and "name@gmail.com", have the same canonical form.
+type EmailCanonicalizer interface {
+	Canonicalize(address string) string
+}
+
+// DomainRule is how a mail provider delivers the aliases of an address.
+type DomainRule struct {
+	// Separator starts the tag of the plus addressing, e.g. "+" for "name+tag@gmail.com", it's disabled if empty.
+	Separator string
+	// IgnoreDots removes the dots of the local part, e.g. gmail delivers "n.a.m.e" to "name".
+	IgnoreDots bool
+	// Domain replaces the domain, e.g. "gmail.com" for "googlemail.com".
+	Domain string
+}
+
+// RuleCanonicalizer lowercases the addresses, and applies the rule of their domain.
+type RuleCanonicalizer struct {
+	Rules map[string]DomainRule
+	// Default is the rule of the domains without one.
+	Default DomainRule
+}
+
+// DefaultCanonicalizer has the rules of the common free mail providers.
+var DefaultCanonicalizer = RuleCanonicalizer{
+	Rules: map[string]DomainRule{
+		"gmail.com":      {Separator: "+", IgnoreDots: true},
+		"googlemail.com": {Separator: "+", IgnoreDots: true, Domain: "gmail.com"},
+		"outlook.com":    {Separator: "+"},
+		"hotmail.com":    {Separator: "+"},
+		"live.com":       {Separator: "+"},
+		"icloud.com":     {Separator: "+"},
+		"me.com":         {Separator: "+"},
+		"fastmail.com":   {Separator: "+"},
+		"protonmail.com": {Separator: "+"},
+		"proton.me":      {Separator: "+"},
+		"yahoo.com":      {Separator: "-"},
+	},
+}
+
+func (c RuleCanonicalizer) Canonicalize(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return address
+	}
+	local, domain := address[:i], address[i+1:]
+
+	rule, ok := c.Rules[domain]
+	if !ok {
+		rule = c.Default
+	}
+	if rule.Separator != "" {
+		if j := strings.Index(local, rule.Separator); j > 0 {
+			local = local[:j]
+		}
+	}
+	if rule.IgnoreDots {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	if rule.Domain != "" {
+		domain = rule.Domain
+	}
+	return local + "@" + domain
+}
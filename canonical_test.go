@@ -0,0 +1,42 @@
+package auth
+
+import "testing"
+
+func TestRuleCanonicalizer(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"Name@Gmail.com", "name@gmail.com"},
+		{"name+tag@gmail.com", "name@gmail.com"},
+		{"n.a.m.e@gmail.com", "name@gmail.com"},
+		{"n.a.m.e+a+b@gmail.com", "name@gmail.com"},
+		{"n.a.m.e+tag@googlemail.com", "name@gmail.com"},
+		{"name+tag@outlook.com", "name@outlook.com"},
+		// the dots are only ignored by the providers which deliver them to the same mailbox.
+		{"n.ame+tag@outlook.com", "n.ame@outlook.com"},
+		{"name-tag@yahoo.com", "name@yahoo.com"},
+		{"name+tag@yahoo.com", "name+tag@yahoo.com"},
+		// a leading separator isn't a tag, the local part would be empty.
+		{"+tag@gmail.com", "+tag@gmail.com"},
+		{"-name@yahoo.com", "-name@yahoo.com"},
+		// the unknown domains are only lowercased.
+		{" N.ame+Tag@Example.com ", "n.ame+tag@example.com"},
+		{"name", "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := DefaultCanonicalizer.Canonicalize(tt.address); got != tt.want {
+				t.Errorf("Canonicalize(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleCanonicalizerDefault(t *testing.T) {
+	c := RuleCanonicalizer{Default: DomainRule{Separator: "+"}}
+
+	if got, want := c.Canonicalize("n.ame+tag@example.com"), "n.ame@example.com"; got != want {
+		t.Errorf("Canonicalize() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+// Command breachfilter builds a bloom filter from a SHA-1 breach file, to screen the passwords offline.
+//
+// Usage:
+//
+//	breachfilter -in pwned-passwords-sha1-ordered-by-hash.txt -out breached.bloom -fp 0.001 -min-count 10
+//
+// The filter is loaded by auth.OpenBloomFilter and set as service.Config.BreachedPasswords.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aemdemir/auth"
+)
+
+func main() {
+	in := flag.String("in", "", "breach file with a HASH:COUNT per line")
+	out := flag.String("out", "breached.bloom", "bloom filter file to write")
+	fp := flag.Float64("fp", 0.001, "false positive rate of the filter")
+	minCount := flag.Int("min-count", 1, "skip the hashes seen fewer times, to make the filter smaller")
+	flag.Parse()
+
+	if *in == "" || *fp <= 0 || *fp >= 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*in, *out, *fp, *minCount); err != nil {
+		fmt.Fprintln(os.Stderr, "breachfilter:", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out string, fp float64, minCount int) error {
+	// the filter is sized by the number of hashes, so the file is read twice.
+	n := 0
+	err := scan(in, minCount, func([20]byte) { n++ })
+	if err != nil {
+		return err
+	}
+
+	filter := auth.NewBloomFilter(n, fp)
+	err = scan(in, minCount, filter.Add)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	size, err := filter.WriteTo(w)
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d hashes, %d bytes written to %s\n", n, size, out)
+	return f.Close()
+}
+
+func scan(name string, minCount int, fn func([20]byte)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if sc.Text() == "" {
+			continue
+		}
+		sum, count, err := auth.ParseBreachLine(sc.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if count >= minCount {
+			fn(sum)
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	_ "embed"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var domainRX = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+// Email domain rule kinds.
+const (
+	DomainAllow = "allow"
+	DomainDeny  = "deny"
+)
+
+// EmailDomainService manages the email domains which are allowed or denied for the new emails.
+type EmailDomainService interface {
+	CreateEmailDomainRule(ctx context.Context, rule EmailDomainRuleInput) (*EmailDomainRule, error)
+	ListEmailDomainRules(ctx context.Context) ([]EmailDomainRule, error)
+	DeleteEmailDomainRule(ctx context.Context, domain string) error
+}
+
+// EmailDomainRule allows or denies the emails of a domain and its subdomains.
+type EmailDomainRule struct {
+	Domain  string    `json:"domain"`
+	Kind    string    `json:"kind"`
+	Created time.Time `json:"created"`
+}
+
+// DomainList is a set of email domains, a domain in the list matches its subdomains too.
+type DomainList map[string]struct{}
+
+// ParseDomainList reads a domain per line, the empty lines and the lines starting with # are skipped.
+func ParseDomainList(r io.Reader) (DomainList, error) {
+	l, err := parseList(r)
+	return DomainList(l), err
+}
+
+// Contains returns true if the domain or one of its parent domains is in the list.
+func (l DomainList) Contains(domain string) bool {
+	for _, d := range DomainHierarchy(domain) {
+		if _, ok := l[d]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// EmailDomain returns the lowercased domain of the address.
+func EmailDomain(address string) string {
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(address[i+1:]))
+}
+
+// DomainHierarchy returns the domain and its parent domains, e.g. "a.b.com" and "b.com" for "a.b.com".
+func DomainHierarchy(domain string) []string {
+	var dd []string
+	for {
+		dd = append(dd, domain)
+		i := strings.Index(domain, ".")
+		if i < 0 || !strings.Contains(domain[i+1:], ".") {
+			return dd
+		}
+		domain = domain[i+1:]
+	}
+}
+
+//
+// Inputs
+//
+
+type EmailDomainRuleInput struct {
+	Domain string
+	Kind   string
+}
+
+// Normalize returns the input with the domain trimmed and lowercased.
+func (e EmailDomainRuleInput) Normalize() EmailDomainRuleInput {
+	e.Domain = strings.ToLower(strings.TrimSpace(e.Domain))
+	return e
+}
+
+func (e EmailDomainRuleInput) Validate(v *validator) {
+	v.Check(notEmpty(e.Domain), "domain", NewMessage(MsgRequired))
+	v.Check(matches(e.Domain, domainRX), "domain", NewMessage(MsgInvalidFormat))
+	v.Check(in(e.Kind, DomainAllow, DomainDeny), "kind", NewMessage(MsgUnknownKind, DomainAllow+", "+DomainDeny))
+}
+
+//
+// Disposable domains
+//
+
+//go:embed disposable_domains.conf
+var disposableDomainsConf string
+
+var disposableDomains = &bundledList{text: disposableDomainsConf}
+
+// DisposableDomains returns the bundled list of the disposable email providers.
+func DisposableDomains() DomainList {
+	return DomainList(disposableDomains.get())
+}
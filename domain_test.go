@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDomainHierarchy(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   []string
+	}{
+		{"example.com", []string{"example.com"}},
+		{"a.example.com", []string{"a.example.com", "example.com"}},
+		{"a.b.example.co.uk", []string{"a.b.example.co.uk", "b.example.co.uk", "example.co.uk", "co.uk"}},
+		// a top level domain isn't a parent.
+		{"localhost", []string{"localhost"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := DomainHierarchy(tt.domain); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DomainHierarchy(%q) = %v, want %v", tt.domain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDomainList(t *testing.T) {
+	conf := `
+# disposable providers
+mailinator.com
+
+  Temp-Mail.org  
+# sub.example.com
+`
+	l, err := ParseDomainList(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DomainList{"mailinator.com": {}, "temp-mail.org": {}}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("ParseDomainList() = %v, want %v", l, want)
+	}
+}
+
+func TestDomainListContains(t *testing.T) {
+	l := DomainList{"mailinator.com": {}, "mail.example.com": {}}
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"mailinator.com", true},
+		{"a.mailinator.com", true},
+		{"a.b.mailinator.com", true},
+		{"mail.example.com", true},
+		{"x.mail.example.com", true},
+		// the parent of a listed domain isn't listed.
+		{"example.com", false},
+		{"other.example.com", false},
+		{"notmailinator.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := l.Contains(tt.domain); got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.domain, got, tt.want)
+			}
+		})
+	}
+}
@@ -21,6 +21,8 @@ type Error struct {
 	Code    string
 	Message string
 	Detail  map[string]string
+	// Codes holds the message codes of Detail, if any, to localize it.
+	Codes map[string]Message
 }
 
 // Error implements the error interface.
@@ -48,6 +50,16 @@ func ErrorMessage(err error) string {
 	return "an unexpected error occurred"
 }
 
+func ErrorCodes(err error) map[string]Message {
+	var e *Error
+	if err == nil {
+		return nil
+	} else if errors.As(err, &e) {
+		return e.Codes
+	}
+	return nil
+}
+
 func ErrorDetail(err error) map[string]string {
 	var e *Error
 	if err == nil {
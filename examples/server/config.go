@@ -2,18 +2,25 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aemdemir/auth"
+	"github.com/aemdemir/auth/mailer"
+	"github.com/aemdemir/auth/provider"
+	"github.com/aemdemir/auth/service"
 	"github.com/gorilla/sessions"
 	"github.com/jmoiron/sqlx"
-	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/google"
-	"github.com/markbates/goth/providers/twitter"
 	"github.com/rs/zerolog"
 	"gopkg.in/mail.v2"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -28,67 +35,168 @@ type appconfig struct {
 	logFileMaxSize int
 	logLevel       zerolog.Level
 	dbdsn          string
+	adminKey       string
+	// rejectEmailAliases rejects the signups with an alias of a registered email, e.g. "name+1@gmail.com".
+	rejectEmailAliases bool
+	// blockDisposableEmails rejects the emails of the bundled disposable domains.
+	blockDisposableEmails bool
+	// emailDomainsAllowListOnly only accepts the emails of the domains allowed by an admin.
+	emailDomainsAllowListOnly bool
+	// minPasswordScore is the minimum zxcvbn score of the new passwords, from 0 to 4.
+	minPasswordScore int
+	// breachFile is a SHA-1 breach file sorted by hash, or a bloom filter built from it by cmd/breachfilter.
+	breachFile string
+	// passwordHistory is the number of the last passwords which can't be reused, 0 disables it.
+	passwordHistory int
+	// minPasswordLength overrides the minimum password length of the default policy, if it's set.
+	minPasswordLength int
+	// totpKeys are the hex encoded AES-256 keys to store the authenticator secrets, the authenticators are disabled if it's empty.
+	totpKeys []string
 }
 
-type oathsecret struct {
-	clientID     string
-	clientSecret string
-}
 type oathconfig struct {
-	callbackURL  string
 	secureCookie bool
-	google       oathsecret
-	twitter      oathsecret
+	// sessionStore keeps the social sign in state, one of db, memory or cookie.
+	sessionStore string
+	// sessionKeys are the hex encoded key pairs of the session cookies, shared by all instances.
+	sessionKeys       []string
+	providers         []provider.Config
+	returnToAllowList []string
+	// client ids of the native apps which sign in with the ID tokens of the provider SDKs.
+	googleAudiences []string
+	appleAudiences  []string
+	// providers whose email_verified claim is trusted when linking accounts by email.
+	trustedEmailProviders []string
+	// tokenKeys are the hex encoded AES-256 keys to store the provider tokens, they aren't stored if it's empty.
+	tokenKeys []string
 }
 
 type smtpconfig struct {
-	host   string
-	port   int
-	user   string
-	pass   string
-	sender string
+	transport   string
+	mailDir     string
+	host        string
+	port        int
+	user        string
+	pass        string
+	sender      string
+	productName string
+	supportURL  string
+	templateDir string
+}
+
+type oidcconfig struct {
+	signingKeyFile string
 }
 
 type config struct {
 	app  appconfig
 	auth oathconfig
 	smtp smtpconfig
+	oidc oidcconfig
 }
 
 func mustConfig() config {
 	return config{
 		app: appconfig{
-			port:           envIntMust("EXAMPLE_PORT"),
-			apiURL:         envStrMust("EXAMPLE_API_URL"),
-			webURL:         envStrMust("EXAMPLE_WEB_URL"),
-			logDir:         envStrMust("EXAMPLE_LOG_DIR"),
-			logFileName:    envStrMust("EXAMPLE_LOG_FILE_NAME"),
-			logFileMaxSize: envIntMust("EXAMPLE_LOG_FILE_MAX_SIZE"),
-			logLevel:       envLogDefault("EXAMPLE_LOG_LEVEL", "info"),
-			dbdsn:          envStrMust("EXAMPLE_DB_DSN"),
+			port:                      envIntMust("EXAMPLE_PORT"),
+			apiURL:                    envStrMust("EXAMPLE_API_URL"),
+			webURL:                    envStrMust("EXAMPLE_WEB_URL"),
+			logDir:                    envStrMust("EXAMPLE_LOG_DIR"),
+			logFileName:               envStrMust("EXAMPLE_LOG_FILE_NAME"),
+			logFileMaxSize:            envIntMust("EXAMPLE_LOG_FILE_MAX_SIZE"),
+			logLevel:                  envLogDefault("EXAMPLE_LOG_LEVEL", "info"),
+			dbdsn:                     envStrMust("EXAMPLE_DB_DSN"),
+			adminKey:                  envStrDefault("EXAMPLE_ADMIN_KEY", ""),
+			rejectEmailAliases:        envBlnDefault("EXAMPLE_REJECT_EMAIL_ALIASES", false),
+			blockDisposableEmails:     envBlnDefault("EXAMPLE_BLOCK_DISPOSABLE_EMAILS", false),
+			emailDomainsAllowListOnly: envBlnDefault("EXAMPLE_EMAIL_DOMAINS_ALLOW_LIST_ONLY", false),
+			minPasswordScore:          envIntDefault("EXAMPLE_MIN_PASSWORD_SCORE", auth.DefaultMinPasswordScore),
+			breachFile:                envStrDefault("EXAMPLE_BREACH_FILE", ""),
+			passwordHistory:           envIntDefault("EXAMPLE_PASSWORD_HISTORY", 0),
+			minPasswordLength:         envIntDefault("EXAMPLE_MIN_PASSWORD_LENGTH", 0),
+			totpKeys:                  envListDefault("EXAMPLE_TOTP_KEYS"),
 		},
 		auth: oathconfig{
-			callbackURL:  envStrMust("EXAMPLE_API_URL"),
-			secureCookie: envBlnMust("EXAMPLE_OAUTH_SECURE_COOKIE"),
-			google: oathsecret{
-				clientID:     envStrMust("EXAMPLE_OAUTH_GOOGLE_CLIENT_ID"),
-				clientSecret: envStrMust("EXAMPLE_OAUTH_GOOGLE_CLIENT_SECRET"),
-			},
-			twitter: oathsecret{
-				clientID:     envStrMust("EXAMPLE_OAUTH_TWITTER_CLIENT_ID"),
-				clientSecret: envStrMust("EXAMPLE_OAUTH_TWITTER_CLIENT_SECRET"),
-			},
+			secureCookie:          envBlnMust("EXAMPLE_OAUTH_SECURE_COOKIE"),
+			sessionStore:          envStrDefault("EXAMPLE_OAUTH_SESSION_STORE", "db"),
+			sessionKeys:           envListDefault("EXAMPLE_OAUTH_SESSION_KEYS"),
+			providers:             mustProviderConfigs(envStrMust("EXAMPLE_API_URL")),
+			returnToAllowList:     envListDefault("EXAMPLE_OAUTH_RETURN_TO_ALLOW_LIST"),
+			googleAudiences:       envListDefault("EXAMPLE_OAUTH_NATIVE_GOOGLE_AUDIENCES"),
+			appleAudiences:        envListDefault("EXAMPLE_OAUTH_NATIVE_APPLE_AUDIENCES"),
+			trustedEmailProviders: envListDefault("EXAMPLE_OAUTH_TRUSTED_EMAIL_PROVIDERS"),
+			tokenKeys:             envListDefault("EXAMPLE_OAUTH_TOKEN_KEYS"),
 		},
-		smtp: smtpconfig{
-			host:   envStrMust("EXAMPLE_SMTP_HOST"),
-			port:   envIntMust("EXAMPLE_SMTP_PORT"),
-			user:   envStrMust("EXAMPLE_SMTP_USERNAME"),
-			pass:   envStrMust("EXAMPLE_SMTP_PASSWORD"),
-			sender: envStrMust("EXAMPLE_SMTP_SENDER"),
+		smtp: mustSMTPConfig(),
+		oidc: oidcconfig{
+			signingKeyFile: envStrDefault("EXAMPLE_OIDC_SIGNING_KEY_FILE", ""),
 		},
 	}
 }
 
+func mustSMTPConfig() smtpconfig {
+	c := smtpconfig{
+		transport:   envStrDefault("EXAMPLE_MAIL_TRANSPORT", "smtp"),
+		sender:      envStrMust("EXAMPLE_SMTP_SENDER"),
+		productName: envStrDefault("EXAMPLE_MAIL_PRODUCT_NAME", "Example Server"),
+		supportURL:  envStrDefault("EXAMPLE_MAIL_SUPPORT_URL", ""),
+		templateDir: envStrDefault("EXAMPLE_MAIL_TEMPLATE_DIR", ""),
+	}
+
+	switch c.transport {
+	case "smtp":
+		c.host = envStrMust("EXAMPLE_SMTP_HOST")
+		c.port = envIntMust("EXAMPLE_SMTP_PORT")
+		c.user = envStrMust("EXAMPLE_SMTP_USERNAME")
+		c.pass = envStrMust("EXAMPLE_SMTP_PASSWORD")
+	case "file":
+		c.mailDir = envStrDefault("EXAMPLE_MAIL_DIR", "./maildir")
+	case "log":
+	default:
+		panic("env variable EXAMPLE_MAIL_TRANSPORT must be one of smtp, file or log")
+	}
+	return c
+}
+
+// mustProviderConfigs reads the providers listed in EXAMPLE_OAUTH_PROVIDERS.
+// Each provider is configured by the variables prefixed with its name,
+// e.g. EXAMPLE_OAUTH_ACME_TYPE=oidc and EXAMPLE_OAUTH_ACME_DISCOVERY_URL=...
+func mustProviderConfigs(apiURL string) []provider.Config {
+	names := strings.Split(envStrDefault("EXAMPLE_OAUTH_PROVIDERS", "google,twitter"), ",")
+
+	cc := make([]provider.Config, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "EXAMPLE_OAUTH_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		c := provider.Config{
+			Name:         name,
+			Type:         envStrDefault(prefix+"TYPE", name),
+			ClientID:     envStrMust(prefix + "CLIENT_ID"),
+			ClientSecret: envStrDefault(prefix+"CLIENT_SECRET", ""),
+			CallbackURL:  fmt.Sprintf("%s/api/v1/auth/%s/callback", apiURL, name),
+			DiscoveryURL: envStrDefault(prefix+"DISCOVERY_URL", ""),
+			Tenant:       envStrDefault(prefix+"TENANT", ""),
+			BaseURL:      envStrDefault(prefix+"BASE_URL", ""),
+			AppleTeamID:  envStrDefault(prefix+"APPLE_TEAM_ID", ""),
+			AppleKeyID:   envStrDefault(prefix+"APPLE_KEY_ID", ""),
+		}
+		c.Scopes = envListDefault(prefix + "SCOPES")
+		if f := envStrDefault(prefix+"APPLE_PRIVATE_KEY_FILE", ""); f != "" {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				panic("env variable " + prefix + "APPLE_PRIVATE_KEY_FILE must be a readable file")
+			}
+			c.ApplePrivateKey = string(b)
+		}
+		cc = append(cc, c)
+	}
+	return cc
+}
+
 type logwrap struct {
 	logger zerolog.Logger
 	roller *lumberjack.Logger
@@ -144,33 +252,178 @@ func newSMTPDialer(c smtpconfig) *mail.Dialer {
 	return dialer
 }
 
-func setupOAuth(c oathconfig) error {
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
+func newMailTransport(c smtpconfig, logger zerolog.Logger) (mailer.Transport, error) {
+	switch c.transport {
+	case "file":
+		return mailer.NewFileTransport(c.mailDir)
+	case "log":
+		return mailer.NewLogTransport(logger), nil
+	default:
+		return mailer.NewSMTPTransport(newSMTPDialer(c), 4, 30*time.Second), nil
+	}
+}
+
+func newMailer(c smtpconfig, transport mailer.Transport, webURL string) (*mailer.Mailer, error) {
+	mc := mailer.Config{
+		Sender:        c.sender,
+		ProductName:   c.productName,
+		SupportURL:    c.supportURL,
+		ActionBaseURL: webURL,
+	}
+	if c.templateDir != "" {
+		mc.Templates = os.DirFS(c.templateDir)
+	}
+	return mailer.NewMailer(transport, mc)
+}
+
+// newOIDCConfig loads the PEM encoded RSA key to sign the ID tokens.
+// If no key file is given, a key is generated, so the tokens are invalid after a restart.
+func newOIDCConfig(c oidcconfig, issuer string) (service.OIDCConfig, error) {
+	var key *rsa.PrivateKey
+	if c.signingKeyFile == "" {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return service.OIDCConfig{}, err
+		}
+		key = k
+	} else {
+		b, err := os.ReadFile(c.signingKeyFile)
+		if err != nil {
+			return service.OIDCConfig{}, err
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return service.OIDCConfig{}, fmt.Errorf("no pem data is found in %s", c.signingKeyFile)
+		}
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			k, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return service.OIDCConfig{}, err
+			}
+		}
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return service.OIDCConfig{}, fmt.Errorf("%s is not an rsa key", c.signingKeyFile)
+		}
+		key = rk
+	}
+
+	// the key id is derived from the public key, so it changes with the key.
+	h := sha256.Sum256(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	return service.OIDCConfig{
+		Issuer:     issuer,
+		SigningKey: key,
+		KeyID:      hex.EncodeToString(h[:8]),
+	}, nil
+}
+
+// setupOAuth sets the gothic store and providers. It returns the session store to run its cleanup,
+// which also keeps the state of the sign ins started with mode=json, even if the sessions are kept in cookies.
+func setupOAuth(c oathconfig, db *service.DB, logger zerolog.Logger) (*provider.Registry, *service.SessionStore, error) {
+	keys, err := sessionKeys(c, logger)
 	if err != nil {
-		return fmt.Errorf("cant generate auth key: %s", err)
-	}
-
-	store := sessions.NewCookieStore(key)
-	store.MaxAge(300)
-	store.Options.Path = "/"
-	store.Options.HttpOnly = true
-	store.Options.Secure = c.secureCookie
-	gothic.Store = store
-
-	goth.UseProviders(
-		google.New(
-			c.google.clientID,
-			c.google.clientSecret,
-			fmt.Sprintf("%s/api/v1/auth/google/callback", c.callbackURL),
-		),
-		twitter.New(
-			c.twitter.clientID,
-			c.twitter.clientSecret,
-			fmt.Sprintf("%s/api/v1/auth/twitter/callback", c.callbackURL),
-		),
-	)
-	return nil
+		return nil, nil, err
+	}
+
+	var ss *service.SessionStore
+	sc := service.DefaultSessionStoreConfig
+	sc.KeyPairs = keys
+	sc.Secure = c.secureCookie
+
+	switch c.sessionStore {
+	case "db":
+		ss = service.NewSessionStore(db, logger, sc)
+		gothic.Store = ss
+	case "memory":
+		ss = service.NewMemorySessionStore(sc)
+		gothic.Store = ss
+	case "cookie":
+		ss = service.NewSessionStore(db, logger, sc)
+		store := sessions.NewCookieStore(keys...)
+		store.MaxAge(int(sc.MaxAge.Seconds()))
+		store.Options.Path = "/"
+		store.Options.HttpOnly = true
+		store.Options.Secure = c.secureCookie
+		gothic.Store = store
+	default:
+		return nil, nil, fmt.Errorf("env variable EXAMPLE_OAUTH_SESSION_STORE must be one of db, memory or cookie")
+	}
+
+	registry, err := provider.NewRegistry(c.providers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	registry.Use()
+	return registry, ss, nil
+}
+
+// sessionKeys decodes the configured session keys. A random key is generated if there is none,
+// then the sessions don't survive a restart and don't work with multiple instances.
+func sessionKeys(c oathconfig, logger zerolog.Logger) ([][]byte, error) {
+	if len(c.sessionKeys) == 0 {
+		logger.Warn().Msg("EXAMPLE_OAUTH_SESSION_KEYS is not set, using a random session key")
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("cant generate auth key: %s", err)
+		}
+		return [][]byte{key}, nil
+	}
+
+	keys := make([][]byte, len(c.sessionKeys))
+	for i, k := range c.sessionKeys {
+		b, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session key %d: %s", i, err)
+		}
+		keys[i] = b
+	}
+	return keys, nil
+}
+
+// encryptionKeys decodes the hex encoded AES-256 keys, e.g. the ones which encrypt the provider tokens.
+func encryptionKeys(name string, hexKeys []string) ([][]byte, error) {
+	keys := make([][]byte, len(hexKeys))
+	for i, k := range hexKeys {
+		b, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s key %d: %s", name, i, err)
+		}
+		if len(b) != 32 {
+			return nil, fmt.Errorf("invalid %s key %d: must be 32 bytes", name, i)
+		}
+		keys[i] = b
+	}
+	return keys, nil
+}
+
+// newIDTokenVerifier returns the verifier of the native sign in, or nil if no app is configured.
+func newIDTokenVerifier(c oathconfig) (auth.IDTokenVerifier, error) {
+	var configs []provider.IDTokenConfig
+	if len(c.googleAudiences) > 0 {
+		configs = append(configs, provider.GoogleIDToken(c.googleAudiences...))
+	}
+	if len(c.appleAudiences) > 0 {
+		configs = append(configs, provider.AppleIDToken(c.appleAudiences...))
+	}
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	return provider.NewVerifier(configs...)
+}
+
+// newBreachedPasswords opens the breach file, or returns nil if it's not set.
+// The files with the .bloom extension are read as bloom filters.
+func newBreachedPasswords(c appconfig) (auth.BreachedPasswords, error) {
+	switch {
+	case c.breachFile == "":
+		return nil, nil
+	case strings.HasSuffix(c.breachFile, ".bloom"):
+		return auth.OpenBloomFilter(c.breachFile)
+	default:
+		return auth.OpenBreachFile(c.breachFile)
+	}
 }
 
 //
@@ -208,6 +461,18 @@ func envStrDefault(key string, def string) string {
 	}
 	return envStrMust(key)
 }
+func envBlnDefault(key string, def bool) bool {
+	if os.Getenv(key) == "" {
+		return def
+	}
+	return envBlnMust(key)
+}
+func envIntDefault(key string, def int) int {
+	if os.Getenv(key) == "" {
+		return def
+	}
+	return envIntMust(key)
+}
 func envLogDefault(key string, def string) zerolog.Level {
 	str := envStrDefault(key, def)
 	lvl, err := zerolog.ParseLevel(str)
@@ -216,3 +481,10 @@ func envLogDefault(key string, def string) zerolog.Level {
 	}
 	return lvl
 }
+func envListDefault(key string) []string {
+	str := envStrDefault(key, "")
+	if str == "" {
+		return nil
+	}
+	return strings.Split(str, ",")
+}
@@ -3,14 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/aemdemir/auth"
 	"github.com/aemdemir/auth/handler"
-	"github.com/aemdemir/auth/mailer"
 	"github.com/aemdemir/auth/service"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
@@ -38,14 +40,73 @@ func main() {
 	}
 	defer db.Close()
 
-	if err := setupOAuth(cfg.auth); err != nil {
+	idTokens, err := newIDTokenVerifier(cfg.auth)
+	if err != nil {
 		panic(err)
 	}
 
-	sv := service.NewService(
-		&service.DB{DB: db},
-		lw.logger,
-		mailer.NewMailer(newSMTPDialer(cfg.smtp), cfg.smtp.sender))
+	sdb := &service.DB{DB: db}
+	// the emails stored before the canonical forms, or before the rules changed, are canonicalized.
+	n, err := service.CanonicalizeEmails(context.Background(), sdb, nil)
+	if err != nil {
+		panic(err)
+	}
+	if n > 0 {
+		lw.logger.Info().Int("count", n).Msg("canonicalized emails")
+	}
+	providers, sessionStore, err := setupOAuth(cfg.auth, sdb, lw.logger)
+	if err != nil {
+		panic(err)
+	}
+	oc, err := newOIDCConfig(cfg.oidc, cfg.app.apiURL)
+	if err != nil {
+		panic(err)
+	}
+	tokenKeys, err := encryptionKeys("token", cfg.auth.tokenKeys)
+	if err != nil {
+		panic(err)
+	}
+	totpKeys, err := encryptionKeys("totp", cfg.app.totpKeys)
+	if err != nil {
+		panic(err)
+	}
+	breached, err := newBreachedPasswords(cfg.app)
+	if err != nil {
+		panic(err)
+	}
+	var disposable auth.DomainList
+	if cfg.app.blockDisposableEmails {
+		disposable = auth.DisposableDomains()
+	}
+	sv := service.NewService(sdb, lw.logger, service.Config{
+		OIDC:                  oc,
+		Providers:             providers,
+		TrustedEmailProviders: cfg.auth.trustedEmailProviders,
+		ProviderTokens: service.ProviderTokensConfig{
+			Keys:   tokenKeys,
+			Source: providers,
+		},
+		TOTPIssuer:               cfg.smtp.productName,
+		TOTPKeys:                 totpKeys,
+		RejectEmailAliases:       cfg.app.rejectEmailAliases,
+		DisposableDomains:        disposable,
+		EmailDomainAllowListOnly: cfg.app.emailDomainsAllowListOnly,
+		MinPasswordScore:         &cfg.app.minPasswordScore,
+		BreachedPasswords:        breached,
+		PasswordHistory:          cfg.app.passwordHistory,
+		Policy:                   auth.Policy{MinPasswordLength: cfg.app.minPasswordLength},
+	})
+	mt, err := newMailTransport(cfg.smtp, lw.logger)
+	if err != nil {
+		panic(err)
+	}
+	if c, ok := mt.(io.Closer); ok {
+		defer c.Close()
+	}
+	ml, err := newMailer(cfg.smtp, mt, cfg.app.webURL)
+	if err != nil {
+		panic(err)
+	}
 
 	handler.SetLogger(lw.logger)
 	h := handler.New(
@@ -54,10 +115,41 @@ func main() {
 		handler.Config{
 			SocialSigninRedirectURL:    fmt.Sprintf("%s/auth/signin_complete", cfg.app.webURL),
 			LinkUserAccountRedirectURL: fmt.Sprintf("%s/auth/link_complete", cfg.app.webURL),
+			SetPasswordRedirectURL:     fmt.Sprintf("%s/auth/password", cfg.app.webURL),
+			ConfirmationRedirectURL:    fmt.Sprintf("%s/auth/confirm", cfg.app.webURL),
+			AdminKey:                   cfg.app.adminKey,
+			ReturnToAllowList:          cfg.auth.returnToAllowList,
+			Providers:                  providers,
+			IDTokens:                   idTokens,
+			OAuthStates:                sessionStore,
+			OIDCLoginURL:               fmt.Sprintf("%s/oauth2/login", cfg.app.webURL),
 		})
 
+	// background workers stop after the server is shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		service.NewWebhookDispatcher(sdb, lw.logger, service.DefaultWebhookDispatcherConfig).Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		service.NewOutboxWorker(sdb, lw.logger, ml, service.DefaultOutboxWorkerConfig).Run(ctx)
+	}()
+	if sessionStore != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sessionStore.Run(ctx)
+		}()
+	}
+
 	r := routes(h, lw.logger)
 	listen(cfg.app.port, r, lw.logger)
+
+	cancel()
+	wg.Wait()
 }
 
 // routes builds server routes.
@@ -79,6 +171,7 @@ func routes(h *handler.Handler, logger zerolog.Logger) http.Handler {
 	router.Use(hlog.RemoteAddrHandler("ip"))
 	router.Use(hlog.UserAgentHandler("user_agent"))
 	router.Use(hlog.RefererHandler("referer"))
+	router.Use(h.Locale)
 
 	return h.Recoverer(h.CORS(router))
 }
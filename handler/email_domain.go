@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/aemdemir/auth"
+)
+
+// CreateEmailDomainRule allows or denies the new emails of a domain and its subdomains.
+//
+// Method: POST
+// URL:    /api/v1/email-domains
+func (h *Handler) CreateEmailDomainRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domain string `json:"domain"`
+		Kind   string `json:"kind"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	rule, err := h.service.CreateEmailDomainRule(r.Context(), auth.EmailDomainRuleInput{
+		Domain: req.Domain,
+		Kind:   req.Kind,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusCreated, Map{"rule": rule})
+}
+
+// ListEmailDomainRules returns the allowed and denied email domains.
+//
+// Method: GET
+// URL:    /api/v1/email-domains
+func (h *Handler) ListEmailDomainRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.service.ListEmailDomainRules(r.Context())
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"rules": rules})
+}
+
+// DeleteEmailDomainRule deletes the rule of a domain.
+//
+// Method: DELETE
+// URL:    /api/v1/email-domains/{domain}
+func (h *Handler) DeleteEmailDomainRule(w http.ResponseWriter, r *http.Request) {
+	domain, err := routeStr(r, "domain")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	err = h.service.DeleteEmailDomainRule(r.Context(), domain)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "domain rule is deleted"})
+}
@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/aemdemir/auth"
 	"github.com/gorilla/mux"
+	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
 	"github.com/rs/zerolog"
 )
@@ -13,8 +17,31 @@ import (
 type Config struct {
 	SocialSigninRedirectURL    string
 	LinkUserAccountRedirectURL string
+	// SetPasswordRedirectURL receives the exchange code of the password setup code of the provider re-authentication.
+	SetPasswordRedirectURL string
+	// ConfirmationRedirectURL receives the exchange code of the confirmation token of the provider re-authentication.
+	ConfirmationRedirectURL string
+	// AdminKey authorizes the admin endpoints, they are disabled if it's empty.
+	AdminKey string
+	// ReturnToAllowList are the urls the social sign in can return to, besides the redirect urls above.
+	// A return_to matches an entry with the same scheme and host, and a path under the entry's path,
+	// e.g. "myapp://auth" allows "myapp://auth/callback".
+	ReturnToAllowList []string
+	// Providers are the social sign in providers, gothic's providers are used if it's nil.
+	Providers auth.ProviderRegistry
+	// IDTokens verifies the ID tokens of the native sign in, it's disabled if it's nil.
+	IDTokens auth.IDTokenVerifier
+	// OAuthStates keeps the state of the social sign ins started with mode=json, e.g. a service.SessionStore.
+	// If it's nil, the callback only works in a browser which shares the cookies of the app.
+	OAuthStates auth.OAuthStateStore
+	// OIDCLoginURL is the page of the web app which signs in the user,
+	// and completes the authorization requests of the OpenID Connect clients.
+	OIDCLoginURL string
 }
 
+// loginSessionName is the session which keeps the action of the social sign in, besides the one of gothic.
+const loginSessionName = "_login_session"
+
 type Handler struct {
 	service auth.Service
 	logger  zerolog.Logger
@@ -39,17 +66,22 @@ func (h *Handler) Signup(w http.ResponseWriter, r *http.Request) {
 		Username string `json:"username"`
 		Name     string `json:"name"`
 		Password string `json:"password"`
+		Locale   string `json:"locale"`
 	}
 	if err := readRequest(w, r, &req); err != nil {
 		Error(w, r, err)
 		return
 	}
+	if req.Locale == "" {
+		req.Locale = auth.LocaleFromContext(r.Context())
+	}
 
 	err := h.service.Signup(r.Context(), auth.SignupInput{
 		Email:    req.Email,
 		Username: req.Username,
 		Name:     req.Name,
 		Password: req.Password,
+		Locale:   req.Locale,
 	})
 	if err != nil {
 		Error(w, r, err)
@@ -60,13 +92,16 @@ func (h *Handler) Signup(w http.ResponseWriter, r *http.Request) {
 }
 
 // Signin logs in users.
+// The identifier is the username or one of the user's verified emails,
+// the email field of the old clients is still accepted.
 //
 // Method: POST
 // URL:    /api/v1/auth/signin
 func (h *Handler) Signin(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Identifier string `json:"identifier"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
 	}
 	if err := readRequest(w, r, &req); err != nil {
 		Error(w, r, err)
@@ -74,8 +109,9 @@ func (h *Handler) Signin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := h.service.Signin(r.Context(), auth.SigninInput{
-		Email:    req.Email,
-		Password: req.Password,
+		Identifier: req.Identifier,
+		Email:      req.Email,
+		Password:   req.Password,
 	})
 	if err != nil {
 		Error(w, r, err)
@@ -86,11 +122,39 @@ func (h *Handler) Signin(w http.ResponseWriter, r *http.Request) {
 }
 
 // SigninSocialBegin starts oauth authentication.
+// On signin, the user agent is redirected back with a code, which is exchanged for
+// the auth token by ExchangeSigninCode. The optional code_challenge query parameter
+// (base64url encoded sha256 of a verifier) binds the code to the spa which started the flow.
+//
+// With mode=json, the provider url and the state are returned instead of a redirect,
+// and the session cookies are kept by Config.OAuthStates for the browser which completes the sign in.
+// Since anyone who completes the callback gets the session then, mode=json is only allowed
+// for action=signin, and the code_challenge is required.
+// The optional return_to parameter overrides the redirect url of the completion,
+// it must match one of Config.ReturnToAllowList.
+//
+// With action=password, a user without a password re-authenticates with a linked provider,
+// and the user agent is redirected back with a code, which is exchanged for a password setup code
+// of SetPassword by ExchangeSigninCode. The code_challenge binds the code like on signin.
+// With action=confirm, the user re-authenticates with a linked provider instead of the password,
+// and the user agent is redirected back with a code, which is exchanged for a confirmation token,
+// as the one of UserConfirmation, by ExchangeSigninCode.
+// With action=link, the request is authenticated, and the confirmation_token must be one of the user.
 //
 // Method: GET
 // URL:    /api/v1/auth/{provider}
 func (h *Handler) SigninSocialBegin(w http.ResponseWriter, r *http.Request) {
-	ses, err := gothic.Store.New(r, "_login_session")
+	provider, err := routeStr(r, "provider")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	if !h.hasProvider(provider) {
+		Error(w, r, &auth.Error{Code: auth.ENOTFOUND, Message: fmt.Sprintf("unknown provider '%s'", provider)})
+		return
+	}
+
+	ses, err := gothic.Store.New(r, loginSessionName)
 	if err != nil {
 		Error(w, r, err)
 		return
@@ -106,37 +170,103 @@ func (h *Handler) SigninSocialBegin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	jsonMode := queryStrDefault(r, "mode", "redirect") == "json"
+	if jsonMode && (action != "signin" || queryStrDefault(r, "code_challenge", "") == "") {
+		Error(w, r, &auth.Error{Code: auth.EINVALID, Message: "mode=json requires action=signin and a code_challenge"})
+		return
+	}
+
 	ses.Values["action"] = action
+	if action == "signin" || action == "password" || action == "confirm" {
+		// the spa may bind the code to a pkce verifier.
+		ses.Values["code_challenge"] = queryStrDefault(r, "code_challenge", "")
+	}
 	if action == "link" {
+		// the confirmation token only links an account to the user who starts the link.
+		txt, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			Error(w, r, &auth.Error{Code: auth.EUNAUTHORIZED, Message: "missing authentication token"})
+			return
+		}
+		user, err := h.service.GetUser(r.Context(), auth.TokenInput{Text: txt})
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		if !user.Active {
+			Error(w, r, &auth.Error{Code: auth.EFORBIDDEN, Message: "this user is deactivated"})
+			return
+		}
 		tkn, err := queryStr(r, "confirmation_token")
 		if err != nil {
 			Error(w, r, err)
 			return
 		}
+		ses.Values["user_id"] = user.ID
 		ses.Values["confirmation_token"] = tkn
 	}
 
+	returnTo := queryStrDefault(r, "return_to", "")
+	if returnTo != "" && !h.allowedReturnTo(returnTo) {
+		Error(w, r, &auth.Error{Code: auth.EINVALID, Message: "return_to is not allowed"})
+		return
+	}
+	ses.Values["return_to"] = returnTo
+
 	err = ses.Save(r, w)
 	if err != nil {
 		Error(w, r, err)
 		return
 	}
 
-	gothic.BeginAuthHandler(w, r)
+	if !jsonMode {
+		gothic.BeginAuthHandler(w, r)
+		return
+	}
+
+	// apps which open the browser themselves only need the provider url.
+	authURL, err := gothic.GetAuthURL(w, r)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	state := u.Query().Get("state")
+	// the browser which completes the sign in doesn't have the cookies of the app, they are restored by the state.
+	if h.config.OAuthStates != nil {
+		err = h.config.OAuthStates.SaveState(r.Context(), state, sessionCookies(w.Header()))
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+	}
+	Response(w, r, http.StatusOK, Map{"url": authURL, "state": state})
 }
 
 // SigninSocialComplete is called by the provider, and it completes the authentication process.
+// Some providers, like apple, post the result as a form.
 //
-// Method: GET
+// Method: GET, POST
 // URL:    /api/v1/auth/{provider}/callback
 func (h *Handler) SigninSocialComplete(w http.ResponseWriter, r *http.Request) {
+	restored, err := h.restoreSessionCookies(r)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
 	othUser, err := gothic.CompleteUserAuth(w, r)
 	if err != nil {
 		Error(w, r, err)
 		return
 	}
 
-	session, err := gothic.Store.Get(r, "_login_session")
+	session, err := gothic.Store.Get(r, loginSessionName)
 	if err != nil {
 		Error(w, r, err)
 		return
@@ -151,44 +281,251 @@ func (h *Handler) SigninSocialComplete(w http.ResponseWriter, r *http.Request) {
 		Error(w, r, err)
 		return
 	}
+	if restored && action != "signin" {
+		// the session of another client must not act on the user of this browser.
+		Error(w, r, &auth.Error{Code: auth.EINVALID, Message: "invalid oauth state"})
+		return
+	}
+
+	if action == "confirm" {
+		challenge, _ := sessionStr(session.Values, "code_challenge")
+		code, err := h.service.ProviderConfirmationCode(r.Context(), auth.AccountInput{
+			ProviderName:   othUser.Provider,
+			ProviderUserID: othUser.UserID,
+		}, challenge)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		http.Redirect(w, r, withQuery(returnURL(session.Values, h.config.ConfirmationRedirectURL), "code", code), http.StatusFound)
+		return
+	}
+
+	if action == "password" {
+		challenge, _ := sessionStr(session.Values, "code_challenge")
+		code, err := h.service.PasswordSetupCode(r.Context(), auth.AccountInput{
+			ProviderName:   othUser.Provider,
+			ProviderUserID: othUser.UserID,
+		}, challenge)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		http.Redirect(w, r, withQuery(returnURL(session.Values, h.config.SetPasswordRedirectURL), "code", code), http.StatusFound)
+		return
+	}
 
 	if action == "link" {
+		uid, err := sessionInt(session.Values, "user_id")
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
 		tkn, err := sessionStr(session.Values, "confirmation_token")
 		if err != nil {
 			Error(w, r, err)
 			return
 		}
 		err = h.service.LinkUserAccount(r.Context(), auth.LinkUserAccountInput{
+			UserID: uid,
 			Token: auth.TokenInput{
 				Text: tkn,
 			},
 			Account: auth.AccountInput{
 				ProviderName:   othUser.Provider,
 				ProviderUserID: othUser.UserID,
+				Profile:        accountProfile(othUser),
+				Tokens:         providerTokens(othUser),
 			},
 		})
 		if err != nil {
 			Error(w, r, err)
 			return
 		}
-		http.Redirect(w, r, h.config.LinkUserAccountRedirectURL, http.StatusFound)
+		http.Redirect(w, r, returnURL(session.Values, h.config.LinkUserAccountRedirectURL), http.StatusFound)
 		return
 	}
 
-	user, err := h.service.SigninSocial(r.Context(), auth.SigninSocialInput{
-		Username: auth.RandomUsername(),
-		Email:    auth.NewNullString(othUser.Email),
-		Name:     auth.NewNullString(othUser.Name),
+	challenge, _ := sessionStr(session.Values, "code_challenge")
+	code, err := h.service.SigninSocialCode(r.Context(), auth.SigninSocialInput{
+		Email:         auth.NewNullString(othUser.Email),
+		EmailVerified: emailVerified(othUser),
+		Name:          auth.NewNullString(othUser.Name),
+		Locale:        auth.LocaleFromContext(r.Context()),
 		Account: auth.AccountInput{
 			ProviderName:   othUser.Provider,
 			ProviderUserID: othUser.UserID,
+			Profile:        accountProfile(othUser),
+			Tokens:         providerTokens(othUser),
 		},
+	}, challenge)
+	if link := auth.ErrorDetail(err)["link_token"]; link != "" {
+		http.Redirect(w, r, withQuery(returnURL(session.Values, h.config.SocialSigninRedirectURL), "link_token", link), http.StatusFound)
+		return
+	}
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	http.Redirect(w, r, withQuery(returnURL(session.Values, h.config.SocialSigninRedirectURL), "code", code), http.StatusFound)
+}
+
+// ExchangeSigninCode exchanges the code of the social sign in redirect for an auth token,
+// the code of the action=password redirect for a password setup code,
+// or the code of the action=confirm redirect for a confirmation token.
+// The code_verifier is required if a code_challenge is passed to SigninSocialBegin.
+//
+// Method: POST
+// URL:    /api/v1/auth/exchange
+func (h *Handler) ExchangeSigninCode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code         string `json:"code"`
+		CodeVerifier string `json:"code_verifier"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	exchange, err := h.service.ExchangeSigninCode(r.Context(), auth.SigninCodeInput{
+		Code: auth.TokenInput{
+			Text: req.Code,
+		},
+		CodeVerifier: req.CodeVerifier,
 	})
 	if err != nil {
 		Error(w, r, err)
 		return
 	}
-	http.Redirect(w, r, fmt.Sprintf("%s?token=%s", h.config.SocialSigninRedirectURL, user.Token), http.StatusFound)
+
+	switch exchange.Action {
+	case auth.CodeActionPassword:
+		Response(w, r, http.StatusOK, Map{"password_token": exchange.Token})
+	case auth.CodeActionConfirm:
+		Response(w, r, http.StatusOK, Map{"confirmation_token": exchange.Token})
+	default:
+		Response(w, r, http.StatusOK, Map{"user": exchange.User, "token": exchange.Token})
+	}
+}
+
+// SigninIDToken signs in the user of a native app with the ID token of the provider SDK,
+// e.g. sign in with google or apple on ios and android. The name is optional,
+// apple only returns it to the app on the first sign in.
+// The email is only used if the provider verified it.
+//
+// Method: POST
+// URL:    /api/v1/auth/{provider}/token
+func (h *Handler) SigninIDToken(w http.ResponseWriter, r *http.Request) {
+	provider, err := routeStr(r, "provider")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	if h.config.IDTokens == nil {
+		Error(w, r, &auth.Error{Code: auth.ENOTFOUND, Message: "native sign in is not enabled"})
+		return
+	}
+
+	var req struct {
+		IDToken string `json:"id_token"`
+		Nonce   string `json:"nonce"`
+		Name    string `json:"name"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	identity, err := h.config.IDTokens.VerifyIDToken(r.Context(), provider, req.IDToken, req.Nonce)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	email := auth.NullString{}
+	if identity.EmailVerified {
+		email = auth.NewNullString(identity.Email)
+	}
+	name := identity.Name
+	if name == "" {
+		name = req.Name
+	}
+
+	user, err := h.service.SigninSocial(r.Context(), auth.SigninSocialInput{
+		Email:         email,
+		EmailVerified: identity.EmailVerified,
+		Name:          auth.NewNullString(name),
+		Locale:        auth.LocaleFromContext(r.Context()),
+		Account: auth.AccountInput{
+			ProviderName:   identity.Provider,
+			ProviderUserID: identity.Subject,
+			Profile: auth.AccountProfile{
+				Email:     auth.NewNullString(identity.Email),
+				Name:      auth.NewNullString(name),
+				AvatarURL: auth.NewNullString(identity.AvatarURL),
+				Locale:    auth.NewNullString(identity.Locale),
+				RawClaims: identity.RawClaims,
+			},
+		},
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"user": user.User, "token": user.Token})
+}
+
+// ConfirmAccountLink links the account of a pending link and signs in the user.
+// The social sign in returns the link_token instead of the auth token, if the email of the
+// provider belongs to an existing user. The user proves its ownership with the password.
+//
+// Method: POST
+// URL:    /api/v1/auth/link/confirm
+func (h *Handler) ConfirmAccountLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LinkToken string `json:"link_token"`
+		Password  string `json:"password"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	user, err := h.service.ConfirmAccountLink(r.Context(), auth.ConfirmAccountLinkInput{
+		Token: auth.TokenInput{
+			Text: req.LinkToken,
+		},
+		Password: req.Password,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"user": user.User, "token": user.Token})
+}
+
+// VerifyAccountLink links the account of a pending link with the code emailed to the user.
+//
+// Method: POST
+// URL:    /api/v1/auth/link/verify
+func (h *Handler) VerifyAccountLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	err := h.service.VerifyAccountLink(r.Context(), auth.TokenInput{Text: req.Token})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "account is linked"})
 }
 
 // SendVerificationEmail sends verification email to the given email address.
@@ -289,20 +626,32 @@ func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 
 // UserConfirmation makes sure that the user confirms the action he/she is
 // about to perform. If so, it returns a confirmation token.
+// The method is "password" by default, "email" confirms with the code of SendConfirmationEmail,
+// and "totp" with the code of the user's authenticator app.
 //
 // Method: POST
 // URL:    /api/v1/auth/confirm
 func (h *Handler) UserConfirmation(w http.ResponseWriter, r *http.Request) {
 	var req struct {
+		Method   string `json:"method"`
 		Password string `json:"password"`
+		Code     string `json:"code"`
 	}
 	if err := readRequest(w, r, &req); err != nil {
 		Error(w, r, err)
 		return
 	}
+	if req.Method == "" {
+		req.Method = auth.ConfirmPassword
+	}
 
 	u := ctxGetUser(r)
-	token, err := h.service.UserConfirmation(r.Context(), u.ID, req.Password)
+	token, err := h.service.ConfirmUser(r.Context(), auth.ConfirmationInput{
+		UserID:   u.ID,
+		Method:   req.Method,
+		Password: req.Password,
+		Code:     req.Code,
+	})
 	if err != nil {
 		Error(w, r, err)
 		return
@@ -311,6 +660,22 @@ func (h *Handler) UserConfirmation(w http.ResponseWriter, r *http.Request) {
 	Response(w, r, http.StatusOK, Map{"token": token})
 }
 
+// SendConfirmationEmail emails a confirmation code to the user's verified primary email,
+// which is exchanged for a confirmation token by UserConfirmation.
+//
+// Method: POST
+// URL:    /api/v1/auth/confirm/email
+func (h *Handler) SendConfirmationEmail(w http.ResponseWriter, r *http.Request) {
+	u := ctxGetUser(r)
+	err := h.service.SendConfirmationEmail(r.Context(), u.ID)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "confirmation email has been sent"})
+}
+
 // AddEmail adds a new email address for a user.
 //
 // Method: POST
@@ -372,6 +737,182 @@ func (h *Handler) GetUserSettings(w http.ResponseWriter, r *http.Request) {
 	Response(w, r, http.StatusOK, Map{"user": user})
 }
 
+// UnlinkUserAccount unlinks the account of the provider from the user.
+//
+// Method: DELETE
+// URL:    /api/v1/users/me/accounts/{provider}
+func (h *Handler) UnlinkUserAccount(w http.ResponseWriter, r *http.Request) {
+	provider, err := routeStr(r, "provider")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	u := ctxGetUser(r)
+	err = h.service.UnlinkUserAccount(r.Context(), u.ID, provider)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "account is unlinked"})
+}
+
+// DeleteUser deletes the user. The token is a confirmation token of the UserConfirmation.
+//
+// Method: DELETE
+// URL:    /api/v1/users/me
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	u := ctxGetUser(r)
+	err := h.service.DeleteUser(r.Context(), auth.DeleteUserInput{
+		UserID: u.ID,
+		Token: auth.TokenInput{
+			Text: req.Token,
+		},
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "user is deleted"})
+}
+
+// SendPasswordSetupEmail emails a password setup code to the verified primary email of a user without a password.
+//
+// Method: POST
+// URL:    /api/v1/users/me/password/setup
+func (h *Handler) SendPasswordSetupEmail(w http.ResponseWriter, r *http.Request) {
+	u := ctxGetUser(r)
+	err := h.service.SendPasswordSetupEmail(r.Context(), u.ID)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "password setup email has been sent"})
+}
+
+// SetPassword sets the initial password of a user without one.
+// The token is the code of SendPasswordSetupEmail, or of the social sign in with action=password.
+//
+// Method: POST
+// URL:    /api/v1/users/me/password
+func (h *Handler) SetPassword(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	u := ctxGetUser(r)
+	err := h.service.SetPassword(r.Context(), auth.SetPasswordInput{
+		UserID: u.ID,
+		Token: auth.TokenInput{
+			Text: req.Token,
+		},
+		Password: req.Password,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "password has been set successfully"})
+}
+
+// SetupTOTP returns a new authenticator secret for the user, the token is a confirmation token.
+// The authenticator is enabled by EnableTOTP.
+//
+// Method: POST
+// URL:    /api/v1/users/me/totp
+func (h *Handler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	u := ctxGetUser(r)
+	setup, err := h.service.SetupTOTP(r.Context(), auth.TOTPInput{
+		UserID: u.ID,
+		Token: auth.TokenInput{
+			Text: req.Token,
+		},
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	Response(w, r, http.StatusOK, Map{"totp": setup})
+}
+
+// EnableTOTP enables the authenticator with a code of it.
+//
+// Method: POST
+// URL:    /api/v1/users/me/totp/enable
+func (h *Handler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	u := ctxGetUser(r)
+	err := h.service.EnableTOTP(r.Context(), u.ID, req.Code)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "authenticator has been enabled"})
+}
+
+// DisableTOTP deletes the authenticator, the token is a confirmation token.
+//
+// Method: DELETE
+// URL:    /api/v1/users/me/totp
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	u := ctxGetUser(r)
+	err := h.service.DisableTOTP(r.Context(), auth.TOTPInput{
+		UserID: u.ID,
+		Token: auth.TokenInput{
+			Text: req.Token,
+		},
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "authenticator has been disabled"})
+}
+
 // UpdateUsername updates a user's username.
 //
 // Method: PUT
@@ -395,6 +936,29 @@ func (h *Handler) UpdateUsername(w http.ResponseWriter, r *http.Request) {
 	Response(w, r, http.StatusOK, Map{"message": "username has been changed successfully"})
 }
 
+// UpdateLocale updates a user's preferred locale, used for the emails.
+//
+// Method: PATCH
+// URL:    /api/v1/users/me/locale
+func (h *Handler) UpdateLocale(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Locale string `json:"locale"`
+	}{}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	u := ctxGetUser(r)
+	err := h.service.UpdateLocale(r.Context(), u.ID, req.Locale)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "locale has been changed successfully"})
+}
+
 // UpdatePassword updates a user's password.
 //
 // Method: PUT
@@ -423,6 +987,44 @@ func (h *Handler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	Response(w, r, http.StatusOK, Map{"message": "password has been changed successfully"})
 }
 
+// PasswordStrength returns the score of a password with the feedback, for the password meters.
+// The username, name and email are optional, the passwords containing them are weaker.
+//
+// Method: POST
+// URL:    /api/v1/auth/password-strength
+func (h *Handler) PasswordStrength(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Password string `json:"password"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+	}{}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	strength, err := h.service.PasswordStrength(r.Context(), auth.PasswordStrengthInput{
+		Password: req.Password,
+		Username: req.Username,
+		Name:     req.Name,
+		Email:    req.Email,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	Response(w, r, http.StatusOK, Map{
+		"score":      strength.Score,
+		"min_score":  strength.MinScore,
+		"crack_time": strength.CrackTime,
+		"breached":   strength.Breached,
+		"feedback":   strength.Feedback(auth.LocaleFromContext(r.Context())),
+	})
+}
+
 //
 // Routes
 //
@@ -430,31 +1032,200 @@ func (h *Handler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) SetRoutes(r *mux.Router) {
 	// auth
 	r.HandleFunc("/api/v1/auth/{provider}", h.SigninSocialBegin).Methods("GET")
-	r.HandleFunc("/api/v1/auth/{provider}/callback", h.SigninSocialComplete).Methods("GET")
+	r.HandleFunc("/api/v1/auth/{provider}/callback", h.SigninSocialComplete).Methods("GET", "POST")
+	r.HandleFunc("/api/v1/auth/{provider}/token", h.SigninIDToken).Methods("POST")
 	r.HandleFunc("/api/v1/auth/signup", h.Signup).Methods("POST")
 	r.HandleFunc("/api/v1/auth/signin", h.Signin).Methods("POST")
+	r.HandleFunc("/api/v1/auth/exchange", h.ExchangeSigninCode).Methods("POST")
+	r.HandleFunc("/api/v1/auth/link/confirm", h.ConfirmAccountLink).Methods("POST")
+	r.HandleFunc("/api/v1/auth/link/verify", h.VerifyAccountLink).Methods("POST")
 	r.HandleFunc("/api/v1/auth/resend", h.SendVerificationEmail).Methods("POST")
 	r.HandleFunc("/api/v1/auth/verify", h.VerifyEmail).Methods("POST")
 	r.HandleFunc("/api/v1/auth/forget", h.SendPasswordResetEmail).Methods("POST")
 	r.HandleFunc("/api/v1/auth/reset", h.ResetPassword).Methods("POST")
+	r.HandleFunc("/api/v1/auth/password-strength", h.PasswordStrength).Methods("POST")
 	r.HandleFunc("/api/v1/auth/confirm", h.RequireUser(h.UserConfirmation)).Methods("POST")
+	r.HandleFunc("/api/v1/auth/confirm/email", h.RequireUser(h.SendConfirmationEmail)).Methods("POST")
 
 	// email
 	r.HandleFunc("/api/v1/emails", h.RequireUser(h.AddEmail)).Methods("POST")
 	r.HandleFunc("/api/v1/emails/primary", h.RequireUser(h.UpdatePrimaryEmail)).Methods("PATCH")
 
 	// user
+	r.HandleFunc("/api/v1/users/me", h.RequireUser(h.DeleteUser)).Methods("DELETE")
 	r.HandleFunc("/api/v1/users/me/settings", h.RequireUser(h.GetUserSettings)).Methods("GET")
+	r.HandleFunc("/api/v1/users/me/accounts/{provider}", h.RequireUser(h.UnlinkUserAccount)).Methods("DELETE")
 	r.HandleFunc("/api/v1/users/me/username", h.RequireUser(h.UpdateUsername)).Methods("PATCH")
+	r.HandleFunc("/api/v1/users/me/locale", h.RequireUser(h.UpdateLocale)).Methods("PATCH")
 	r.HandleFunc("/api/v1/users/me/password", h.RequireUser(h.UpdatePassword)).Methods("PATCH")
+	r.HandleFunc("/api/v1/users/me/password", h.RequireUser(h.SetPassword)).Methods("POST")
+	r.HandleFunc("/api/v1/users/me/password/setup", h.RequireUser(h.SendPasswordSetupEmail)).Methods("POST")
+	r.HandleFunc("/api/v1/users/me/totp", h.RequireUser(h.SetupTOTP)).Methods("POST")
+	r.HandleFunc("/api/v1/users/me/totp", h.RequireUser(h.DisableTOTP)).Methods("DELETE")
+	r.HandleFunc("/api/v1/users/me/totp/enable", h.RequireUser(h.EnableTOTP)).Methods("POST")
+
+	// webhook
+	r.HandleFunc("/api/v1/webhooks", h.RequireAdmin(h.CreateWebhook)).Methods("POST")
+	r.HandleFunc("/api/v1/webhooks", h.RequireAdmin(h.ListWebhooks)).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks/{id:[0-9]+}", h.RequireAdmin(h.DeleteWebhook)).Methods("DELETE")
+	r.HandleFunc("/api/v1/webhooks/{id:[0-9]+}/deliveries", h.RequireAdmin(h.ListWebhookDeliveries)).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks/deliveries/{id:[0-9]+}", h.RequireAdmin(h.GetWebhookDelivery)).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks/deliveries/{id:[0-9]+}/redeliver", h.RequireAdmin(h.RedeliverWebhook)).Methods("POST")
+
+	// oidc
+	r.HandleFunc("/.well-known/openid-configuration", h.OpenIDConfiguration).Methods("GET")
+	r.HandleFunc("/.well-known/jwks.json", h.JWKS).Methods("GET")
+	r.HandleFunc("/oauth2/authorize", h.AuthorizeBegin).Methods("GET")
+	r.HandleFunc("/oauth2/token", h.Token).Methods("POST")
+	r.HandleFunc("/oauth2/userinfo", h.UserInfo).Methods("GET", "POST")
+	r.HandleFunc("/api/v1/oauth2/authorize", h.RequireUser(h.Authorize)).Methods("POST")
+	r.HandleFunc("/api/v1/oauth2/consent", h.RequireUser(h.Consent)).Methods("POST")
+	r.HandleFunc("/api/v1/oauth2/clients", h.RequireAdmin(h.CreateOIDCClient)).Methods("POST")
+	r.HandleFunc("/api/v1/oauth2/clients", h.RequireAdmin(h.ListOIDCClients)).Methods("GET")
+	r.HandleFunc("/api/v1/oauth2/clients/{id}", h.RequireAdmin(h.DeleteOIDCClient)).Methods("DELETE")
+
+	// machine
+	r.HandleFunc("/api/v1/machines", h.RequireAdmin(h.CreateMachineClient)).Methods("POST")
+	r.HandleFunc("/api/v1/machines", h.RequireAdmin(h.ListMachineClients)).Methods("GET")
+	r.HandleFunc("/api/v1/machines/me", h.RequireMachine("", h.GetMachine)).Methods("GET")
+	r.HandleFunc("/api/v1/machines/users/{id:[0-9]+}/accounts/{provider}/token", h.RequireMachine(ScopeProviderTokens, h.GetProviderToken)).Methods("GET")
+	r.HandleFunc("/api/v1/machines/{id}", h.RequireAdmin(h.DeleteMachineClient)).Methods("DELETE")
+
+	// email domain
+	r.HandleFunc("/api/v1/email-domains", h.RequireAdmin(h.CreateEmailDomainRule)).Methods("POST")
+	r.HandleFunc("/api/v1/email-domains", h.RequireAdmin(h.ListEmailDomainRules)).Methods("GET")
+	r.HandleFunc("/api/v1/email-domains/{domain}", h.RequireAdmin(h.DeleteEmailDomainRule)).Methods("DELETE")
 }
 
 //
 // Helpers
 //
 
+func (h *Handler) hasProvider(name string) bool {
+	if h.config.Providers != nil {
+		return h.config.Providers.HasProvider(name)
+	}
+	_, err := goth.GetProvider(name)
+	return err == nil
+}
+
+// allowedReturnTo checks the return_to against the allow list.
+func (h *Handler) allowedReturnTo(returnTo string) bool {
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme == "" || u.User != nil || u.Fragment != "" {
+		return false
+	}
+	for _, a := range h.config.ReturnToAllowList {
+		allowed, err := url.Parse(a)
+		if err != nil {
+			continue
+		}
+		if !strings.EqualFold(u.Scheme, allowed.Scheme) || !strings.EqualFold(u.Host, allowed.Host) {
+			continue
+		}
+		p := strings.TrimSuffix(allowed.Path, "/")
+		if u.Path == p || strings.HasPrefix(u.Path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreSessionCookies adds the session cookies kept by the state of a sign in started with mode=json,
+// if the request doesn't have them, and reports whether they are restored.
+func (h *Handler) restoreSessionCookies(r *http.Request) (bool, error) {
+	state := r.FormValue("state")
+	if h.config.OAuthStates == nil || state == "" {
+		return false, nil
+	}
+	if _, err := r.Cookie(loginSessionName); err == nil {
+		return false, nil
+	}
+
+	cookies, err := h.config.OAuthStates.TakeState(r.Context(), state)
+	if err != nil {
+		if auth.ErrorCode(err) == auth.ENOTFOUND {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, c := range (&http.Request{Header: http.Header{"Cookie": {cookies}}}).Cookies() {
+		r.AddCookie(c)
+	}
+	return true, nil
+}
+
+// sessionCookies returns the session cookies set in the header, in the format of a Cookie header.
+func sessionCookies(header http.Header) string {
+	var ss []string
+	for _, c := range (&http.Response{Header: header}).Cookies() {
+		if c.Name == loginSessionName || c.Name == gothic.SessionName {
+			ss = append(ss, c.Name+"="+c.Value)
+		}
+	}
+	return strings.Join(ss, "; ")
+}
+
+// returnURL returns the return_to of the session, or def if there is none.
+func returnURL(values map[any]any, def string) string {
+	if returnTo, _ := sessionStr(values, "return_to"); returnTo != "" {
+		return returnTo
+	}
+	return def
+}
+
+// withQuery adds the query parameter to the url.
+func withQuery(rawURL, key, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// accountProfile returns the profile of the user at the provider.
+func accountProfile(u goth.User) auth.AccountProfile {
+	locale, _ := u.RawData["locale"].(string)
+	raw, _ := json.Marshal(u.RawData)
+	return auth.AccountProfile{
+		Email:     auth.NewNullString(u.Email),
+		Name:      auth.NewNullString(u.Name),
+		AvatarURL: auth.NewNullString(u.AvatarURL),
+		Locale:    auth.NewNullString(locale),
+		RawClaims: raw,
+	}
+}
+
+// providerTokens returns the OAuth tokens of the user, to call the provider's API later.
+func providerTokens(u goth.User) auth.ProviderTokens {
+	return auth.ProviderTokens{
+		AccessToken:  u.AccessToken,
+		RefreshToken: u.RefreshToken,
+		Expiry:       u.ExpiresAt,
+	}
+}
+
+// emailVerified returns true if the provider asserts that the email of the user is verified.
+// Providers name the claim differently, and some of them send it as a string.
+func emailVerified(u goth.User) bool {
+	for _, key := range []string{"email_verified", "verified_email"} {
+		switch v := u.RawData[key].(type) {
+		case bool:
+			return v
+		case string:
+			return v == "true"
+		}
+	}
+	return false
+}
+
 func validAction(action string) error {
-	if action != "signin" && action != "link" {
+	switch action {
+	case "signin", "link", "password", "confirm":
+	default:
 		return &auth.Error{Code: auth.EINVALID, Message: "invalid action"}
 	}
 	return nil
@@ -475,3 +1246,19 @@ func sessionStr(values map[any]any, key string) (string, error) {
 	}
 	return s, nil
 }
+
+func sessionInt(values map[any]any, key string) (int, error) {
+	v, ok := values[key]
+	if !ok {
+		return 0, &auth.Error{
+			Code:    auth.EINVALID,
+			Message: fmt.Sprintf("session value '%s' is not found", key)}
+	}
+	i, ok := v.(int)
+	if !ok {
+		return 0, &auth.Error{
+			Code:    auth.EINVALID,
+			Message: fmt.Sprintf("session value '%s' must be int", key)}
+	}
+	return i, nil
+}
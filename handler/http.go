@@ -30,7 +30,8 @@ func LogError(r *http.Request, err error) {
 
 // Error sends an http error response.
 func Error(w http.ResponseWriter, r *http.Request, err error) {
-	code, message, detail := auth.ErrorCode(err), auth.ErrorMessage(err), auth.ErrorDetail(err)
+	code, message := auth.ErrorCode(err), auth.ErrorMessage(err)
+	detail := auth.LocalizeDetail(err, auth.LocaleFromContext(r.Context()))
 
 	if code == auth.EINTERNAL {
 		LogError(r, err)
@@ -42,6 +43,13 @@ func Error(w http.ResponseWriter, r *http.Request, err error) {
 	if detail != nil {
 		v["error_detail"] = detail
 	}
+	if codes := auth.ErrorCodes(err); codes != nil {
+		c := make(map[string]string, len(codes))
+		for k, m := range codes {
+			c[k] = m.Code
+		}
+		v["error_codes"] = c
+	}
 	Response(w, r, c, v)
 }
 
@@ -196,7 +204,8 @@ func jsonDecode(w http.ResponseWriter, r *http.Request, v any) error {
 type ctxKey string
 
 const (
-	ctxUserKey ctxKey = "user"
+	ctxUserKey    ctxKey = "user"
+	ctxMachineKey ctxKey = "machine"
 )
 
 // ctxSetUser sets a user to the given request's context.
@@ -215,3 +224,21 @@ func ctxGetUser(r *http.Request) *auth.User {
 	}
 	return user
 }
+
+// ctxSetMachine sets a machine client to the given request's context.
+func ctxSetMachine(r *http.Request, machine *auth.Machine) *http.Request {
+	ctx := context.WithValue(r.Context(), ctxMachineKey, machine)
+	return r.WithContext(ctx)
+}
+
+// UserFromRequest returns the authenticated user, if the principal of the request is a user.
+func UserFromRequest(r *http.Request) (*auth.User, bool) {
+	user, ok := r.Context().Value(ctxUserKey).(*auth.User)
+	return user, ok
+}
+
+// MachineFromRequest returns the authenticated machine client, if the principal of the request is a machine.
+func MachineFromRequest(r *http.Request) (*auth.Machine, bool) {
+	machine, ok := r.Context().Value(ctxMachineKey).(*auth.Machine)
+	return machine, ok
+}
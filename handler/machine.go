@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/aemdemir/auth"
+)
+
+// ScopeProviderTokens allows a machine client to get the provider tokens of the users.
+const ScopeProviderTokens = "provider_tokens"
+
+// CreateMachineClient registers a new machine client.
+// The returned secret is only shown once.
+//
+// Method: POST
+// URL:    /api/v1/machines
+func (h *Handler) CreateMachineClient(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	client, err := h.service.CreateMachineClient(r.Context(), auth.MachineClientInput{
+		Name:   req.Name,
+		Scopes: req.Scopes,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusCreated, Map{"client": client})
+}
+
+// ListMachineClients returns the registered machine clients.
+//
+// Method: GET
+// URL:    /api/v1/machines
+func (h *Handler) ListMachineClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.service.ListMachineClients(r.Context())
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"clients": clients})
+}
+
+// DeleteMachineClient deletes a machine client, its tokens are revoked.
+//
+// Method: DELETE
+// URL:    /api/v1/machines/{id}
+func (h *Handler) DeleteMachineClient(w http.ResponseWriter, r *http.Request) {
+	id, err := routeStr(r, "id")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	err = h.service.DeleteMachineClient(r.Context(), id)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "client is deleted"})
+}
+
+// GetMachine returns the machine client of the token.
+//
+// Method: GET
+// URL:    /api/v1/machines/me
+func (h *Handler) GetMachine(w http.ResponseWriter, r *http.Request) {
+	machine, _ := MachineFromRequest(r)
+
+	Response(w, r, http.StatusOK, Map{"machine": machine})
+}
+
+// GetProviderToken returns a valid access token of the user's account at the provider,
+// so that a backend service can call the provider's API on behalf of the user.
+//
+// Method: GET
+// URL:    /api/v1/machines/users/{id}/accounts/{provider}/token
+func (h *Handler) GetProviderToken(w http.ResponseWriter, r *http.Request) {
+	id, err := routeInt(r, "id")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	provider, err := routeStr(r, "provider")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	tokens, err := h.service.GetProviderToken(r.Context(), id, provider)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	Response(w, r, http.StatusOK, Map{"token": tokens})
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
@@ -61,36 +62,73 @@ func (h *Handler) CORS(next http.Handler) http.Handler {
 	})
 }
 
+// Locale negotiates the locale from the Accept-Language header,
+// and sets it to the request context.
+func (h *Handler) Locale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+
+		locale := auth.NegotiateLocale(r.Header.Get("Accept-Language"))
+		w.Header().Set("Content-Language", locale)
+
+		r = r.WithContext(auth.WithLocale(r.Context(), locale))
+		next.ServeHTTP(w, r)
+	})
+}
+
 // authenticate checks the authorization token.
+// The principal is either a user or a machine client, and it's set to the request context.
 func (h *Handler) authenticate(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
 
-		header := r.Header.Get("Authorization")
-		splits := strings.Split(header, " ")
-
-		if len(splits) != 2 || splits[0] != "Bearer" {
+		txt, ok := bearerToken(r)
+		if !ok {
 			w.Header().Set("WWW-Authenticate", "Bearer")
 			Error(w, r, &auth.Error{Code: auth.EUNAUTHORIZED, Message: "missing authentication token"})
 			return
 		}
 
-		txt := splits[1]
 		user, err := h.service.GetUser(r.Context(), auth.TokenInput{Text: txt})
+		if err == nil {
+			r = ctxSetUser(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if auth.ErrorCode(err) != auth.EUNAUTHORIZED {
+			Error(w, r, err)
+			return
+		}
+
+		machine, err := h.service.GetMachine(r.Context(), auth.TokenInput{Text: txt})
 		if err != nil {
 			Error(w, r, err)
 			return
 		}
 
-		r = ctxSetUser(r, user)
+		r = ctxSetMachine(r, machine)
 		next.ServeHTTP(w, r)
 	}
 }
 
+// bearerToken returns the token of the bearer authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	splits := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(splits) != 2 || splits[0] != "Bearer" {
+		return "", false
+	}
+	return splits[1], true
+}
+
 // RequireUser requires an authenticated user.
 func (h *Handler) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		if user := ctxGetUser(r); !user.Active {
+		user, ok := UserFromRequest(r)
+		if !ok {
+			Error(w, r, &auth.Error{Code: auth.EFORBIDDEN, Message: "this endpoint requires a user"})
+			return
+		}
+		if !user.Active {
 			Error(w, r, &auth.Error{Code: auth.EFORBIDDEN, Message: "this user is deactivated"})
 			return
 		}
@@ -98,3 +136,49 @@ func (h *Handler) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 	}
 	return h.authenticate(fn)
 }
+
+// RequireMachine requires an authenticated machine client with the scope.
+// Any machine client is allowed if the scope is empty.
+func (h *Handler) RequireMachine(scope string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		machine, ok := MachineFromRequest(r)
+		if !ok {
+			Error(w, r, &auth.Error{Code: auth.EFORBIDDEN, Message: "this endpoint requires a machine client"})
+			return
+		}
+		if scope != "" && !machine.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			Error(w, r, &auth.Error{Code: auth.EFORBIDDEN, Message: "insufficient scope"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return h.authenticate(fn)
+}
+
+// RequireAdmin requires the admin key as the bearer token.
+func (h *Handler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		if h.config.AdminKey == "" {
+			Error(w, r, &auth.Error{Code: auth.EFORBIDDEN, Message: "admin endpoints are disabled"})
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		splits := strings.Split(header, " ")
+
+		if len(splits) != 2 || splits[0] != "Bearer" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			Error(w, r, &auth.Error{Code: auth.EUNAUTHORIZED, Message: "missing authentication token"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(splits[1]), []byte(h.config.AdminKey)) != 1 {
+			Error(w, r, &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
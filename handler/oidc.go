@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aemdemir/auth"
+)
+
+// authorizeRequest holds the parameters of an authorization request in a json body.
+type authorizeRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Prompt              string `json:"prompt"`
+}
+
+func (a authorizeRequest) input() auth.AuthorizeInput {
+	return auth.AuthorizeInput{
+		ClientID:            a.ClientID,
+		RedirectURI:         a.RedirectURI,
+		ResponseType:        a.ResponseType,
+		Scope:               a.Scope,
+		State:               a.State,
+		Nonce:               a.Nonce,
+		CodeChallenge:       a.CodeChallenge,
+		CodeChallengeMethod: a.CodeChallengeMethod,
+		Prompt:              a.Prompt,
+	}
+}
+
+// OpenIDConfiguration returns the provider metadata.
+//
+// Method: GET
+// URL:    /.well-known/openid-configuration
+func (h *Handler) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	Response(w, r, http.StatusOK, h.service.OpenIDConfiguration())
+}
+
+// JWKS returns the keys to verify the ID tokens.
+//
+// Method: GET
+// URL:    /.well-known/jwks.json
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	Response(w, r, http.StatusOK, h.service.JWKS())
+}
+
+// AuthorizeBegin is the authorization endpoint, it's opened by the client in the user agent.
+// After the request is validated, the user agent is redirected to the login page of the web app
+// with the same query. The web app signs in the user as usual, then completes the request
+// with the Authorize endpoint.
+//
+// Method: GET
+// URL:    /oauth2/authorize
+func (h *Handler) AuthorizeBegin(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	a, err := h.service.ValidateAuthorization(r.Context(), auth.AuthorizeInput{
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		ResponseType:        q.Get("response_type"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		Nonce:               q.Get("nonce"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+		Prompt:              q.Get("prompt"),
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	if a.RedirectTo != "" {
+		http.Redirect(w, r, a.RedirectTo, http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s?%s", h.config.OIDCLoginURL, r.URL.RawQuery), http.StatusFound)
+}
+
+// Authorize completes the authorization request for the signed in user.
+// It returns either the uri to redirect the user agent to, or the client and the scopes
+// to ask the user for consent.
+//
+// Method: POST
+// URL:    /api/v1/oauth2/authorize
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	var req authorizeRequest
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	user := ctxGetUser(r)
+	a, err := h.service.Authorize(r.Context(), user.ID, req.input())
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"authorization": a})
+}
+
+// Consent records the answer of the user and completes the authorization request.
+//
+// Method: POST
+// URL:    /api/v1/oauth2/consent
+func (h *Handler) Consent(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		authorizeRequest
+		Approve bool `json:"approve"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	user := ctxGetUser(r)
+	a, err := h.service.GrantConsent(r.Context(), user.ID, auth.ConsentInput{
+		AuthorizeInput: req.input(),
+		Approve:        req.Approve,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"authorization": a})
+}
+
+// Token is the token endpoint, for the authorization_code and client_credentials grants.
+// Clients authenticate with http basic auth or with the client_id and client_secret form values,
+// public clients with the client_id only.
+//
+// Method: POST
+// URL:    /oauth2/token
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, r, &auth.OAuthError{Code: auth.OAuthInvalidRequest, Description: "invalid form"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	tokens, err := h.service.ExchangeToken(r.Context(), auth.TokenExchangeInput{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		Scope:        r.PostForm.Get("scope"),
+	})
+	if err != nil {
+		oauthError(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, tokens)
+}
+
+// UserInfo returns the claims of the user of the access token.
+//
+// Method: GET, POST
+// URL:    /oauth2/userinfo
+func (h *Handler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Authorization")
+
+	header := r.Header.Get("Authorization")
+	splits := strings.Split(header, " ")
+
+	if len(splits) != 2 || splits[0] != "Bearer" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		oauthError(w, r, &auth.OAuthError{Code: auth.OAuthInvalidToken, Description: "missing access token"})
+		return
+	}
+
+	info, err := h.service.GetUserInfo(r.Context(), auth.TokenInput{Text: splits[1]})
+	if err != nil {
+		oauthError(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, info)
+}
+
+// CreateOIDCClient registers a new client.
+// The returned secret is only shown once, public clients don't have one.
+//
+// Method: POST
+// URL:    /api/v1/oauth2/clients
+func (h *Handler) CreateOIDCClient(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Public       bool     `json:"public"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	client, err := h.service.CreateOIDCClient(r.Context(), auth.OIDCClientInput{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		Public:       req.Public,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusCreated, Map{"client": client})
+}
+
+// ListOIDCClients returns the registered clients.
+//
+// Method: GET
+// URL:    /api/v1/oauth2/clients
+func (h *Handler) ListOIDCClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.service.ListOIDCClients(r.Context())
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"clients": clients})
+}
+
+// DeleteOIDCClient deletes a client, its tokens stay valid until they expire.
+//
+// Method: DELETE
+// URL:    /api/v1/oauth2/clients/{id}
+func (h *Handler) DeleteOIDCClient(w http.ResponseWriter, r *http.Request) {
+	id, err := routeStr(r, "id")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	err = h.service.DeleteOIDCClient(r.Context(), id)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "client is deleted"})
+}
+
+//
+// Helpers
+//
+
+// oauthError sends an error response in the format of RFC 6749.
+func oauthError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *auth.OAuthError
+	if !errors.As(err, &e) {
+		LogError(r, err)
+		Response(w, r, http.StatusInternalServerError, Map{"error": auth.OAuthServerError})
+		return
+	}
+
+	c := http.StatusBadRequest
+	switch e.Code {
+	case auth.OAuthInvalidClient:
+		c = http.StatusUnauthorized
+		if _, _, ok := r.BasicAuth(); ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+	case auth.OAuthInvalidToken:
+		c = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", error_description="%s"`, e.Code, e.Description))
+	}
+
+	Response(w, r, c, Map{"error": e.Code, "error_description": e.Description})
+}
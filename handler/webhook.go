@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/aemdemir/auth"
+)
+
+// CreateWebhook registers a new webhook endpoint.
+// The returned secret is used to verify payload signatures, and it's only shown once.
+//
+// Method: POST
+// URL:    /api/v1/webhooks
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := readRequest(w, r, &req); err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	webhook, err := h.service.CreateWebhook(r.Context(), auth.WebhookInput{
+		URL:    req.URL,
+		Events: req.Events,
+	})
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusCreated, Map{"webhook": webhook})
+}
+
+// ListWebhooks returns the registered webhooks.
+//
+// Method: GET
+// URL:    /api/v1/webhooks
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.service.ListWebhooks(r.Context())
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"webhooks": webhooks})
+}
+
+// DeleteWebhook deletes a webhook with its deliveries.
+//
+// Method: DELETE
+// URL:    /api/v1/webhooks/{id}
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := routeInt(r, "id")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	err = h.service.DeleteWebhook(r.Context(), id)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"message": "webhook has been deleted successfully"})
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a webhook.
+//
+// Method: GET
+// URL:    /api/v1/webhooks/{id}/deliveries
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := routeInt(r, "id")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	deliveries, err := h.service.ListWebhookDeliveries(r.Context(), id)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"deliveries": deliveries})
+}
+
+// GetWebhookDelivery returns a delivery with its attempt log.
+//
+// Method: GET
+// URL:    /api/v1/webhooks/deliveries/{id}
+func (h *Handler) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := routeInt(r, "id")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	delivery, err := h.service.GetWebhookDelivery(r.Context(), id)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusOK, Map{"delivery": delivery})
+}
+
+// RedeliverWebhook queues a delivery again.
+//
+// Method: POST
+// URL:    /api/v1/webhooks/deliveries/{id}/redeliver
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := routeInt(r, "id")
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	err = h.service.RedeliverWebhook(r.Context(), id)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+
+	Response(w, r, http.StatusAccepted, Map{"message": "delivery has been queued"})
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrJWTMalformed = errors.New("jwt: malformed token")
+	ErrJWTAlgorithm = errors.New("jwt: unsupported algorithm")
+	ErrJWTSignature = errors.New("jwt: invalid signature")
+)
+
+// JWTHeader is the JOSE header of a jwt.
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// SignJWT returns the RS256 signed jwt of the claims.
+func SignJWT(key *rsa.PrivateKey, kid string, claims any) (string, error) {
+	header, err := json.Marshal(JWTHeader{Alg: "RS256", Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := b64(header) + "." + b64(payload)
+	h := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64(sig), nil
+}
+
+// ParseJWT verifies the signature of the jwt with the key returned by keyFn,
+// and decodes its payload into claims. Only RS256 and ES256 are supported.
+//
+// It doesn't validate the claims, callers must check them.
+func ParseJWT(token string, keyFn func(h JWTHeader) (crypto.PublicKey, error), claims any) (JWTHeader, error) {
+	var h JWTHeader
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return h, ErrJWTMalformed
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return h, ErrJWTMalformed
+	}
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return h, ErrJWTMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return h, ErrJWTMalformed
+	}
+
+	key, err := keyFn(h)
+	if err != nil {
+		return h, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch h.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return h, ErrJWTAlgorithm
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return h, ErrJWTSignature
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return h, ErrJWTAlgorithm
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return h, ErrJWTSignature
+		}
+	default:
+		return h, ErrJWTAlgorithm
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return h, ErrJWTMalformed
+	}
+	if err := json.Unmarshal(pb, claims); err != nil {
+		return h, ErrJWTMalformed
+	}
+	return h, nil
+}
+
+// JWK is a json web key, only RSA and EC public keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a json web key set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewRSAJWK returns the signing JWK of the RSA public key.
+func NewRSAJWK(pub *rsa.PublicKey, kid string) JWK {
+	return JWK{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   b64(pub.N.Bytes()),
+		E:   b64(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+// PublicKey returns the public key of the JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("jwk: unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("jwk: unsupported key type %q", k.Kty)
+	}
+}
+
+// Key returns the key with the kid, or the only key if kid is empty.
+func (s JWKS) Key(kid string) (JWK, bool) {
+	if kid == "" && len(s.Keys) == 1 {
+		return s.Keys[0], true
+	}
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+)
+
+// parseList reads a lowercased item per line, the empty lines and the lines starting with # are skipped.
+func parseList(r io.Reader) (map[string]struct{}, error) {
+	l := map[string]struct{}{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.ToLower(strings.TrimSpace(sc.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l[line] = struct{}{}
+	}
+	return l, sc.Err()
+}
+
+// bundledList is a list embedded in the package, it's parsed on the first use.
+type bundledList struct {
+	once sync.Once
+	text string
+	list map[string]struct{}
+}
+
+func (b *bundledList) get() map[string]struct{} {
+	b.once.Do(func() {
+		// reading from a string can't fail.
+		b.list, _ = parseList(strings.NewReader(b.text))
+	})
+	return b.list
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale is used when no supported locale is requested.
+const DefaultLocale = "en"
+
+// SupportedLocales lists the locales that have message catalogs.
+// The first one is the default.
+var SupportedLocales = []string{DefaultLocale, "de", "tr"}
+
+var localeMatcher = language.NewMatcher(localeTags())
+
+// NegotiateLocale returns the best supported locale for the given Accept-Language header value.
+func NegotiateLocale(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+	_, i, conf := localeMatcher.Match(tags...)
+	if conf == language.No {
+		return DefaultLocale
+	}
+	return SupportedLocales[i]
+}
+
+// ValidLocale reports whether the locale is supported.
+func ValidLocale(locale string) bool {
+	return in(locale, SupportedLocales...)
+}
+
+type ctxKey string
+
+const ctxLocaleKey ctxKey = "locale"
+
+// WithLocale returns a copy of ctx carrying the locale.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, ctxLocaleKey, locale)
+}
+
+// LocaleFromContext returns the locale carried by ctx, or the default locale.
+func LocaleFromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(ctxLocaleKey).(string); ok && locale != "" {
+		return locale
+	}
+	return DefaultLocale
+}
+
+func localeTags() []language.Tag {
+	tags := make([]language.Tag, len(SupportedLocales))
+	for i, l := range SupportedLocales {
+		tags[i] = language.Make(l)
+	}
+	return tags
+}
+
+//
+// Inputs
+//
+
+func ValidateLocale(v *validator, locale string) {
+	v.Check(ValidLocale(locale), "locale", NewMessage(MsgUnsupportedLocale))
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+var machineScopeRX = regexp.MustCompile(`^[a-z0-9_.:-]+$`)
+
+// MachineService authenticates backend services with the client credentials grant.
+// Their tokens are issued by the token endpoint of the OIDCService.
+type MachineService interface {
+	CreateMachineClient(ctx context.Context, client MachineClientInput) (*MachineClient, error)
+	ListMachineClients(ctx context.Context) ([]MachineClient, error)
+	DeleteMachineClient(ctx context.Context, id string) error
+	GetMachine(ctx context.Context, token TokenInput) (*Machine, error)
+}
+
+// MachineClient is a confidential client which acts on its own behalf, not on behalf of a user.
+// Secret is only returned once when the client is created.
+type MachineClient struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Active  bool      `json:"active"`
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Machine is the principal of a client credentials token.
+type Machine struct {
+	ClientID string   `json:"client_id"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+}
+
+// HasScope returns true if the token is granted the scope.
+func (m *Machine) HasScope(scope string) bool {
+	return in(scope, m.Scopes...)
+}
+
+//
+// Inputs
+//
+
+type MachineClientInput struct {
+	Name   string
+	Scopes []string
+}
+
+func (m MachineClientInput) Validate(v *validator) {
+	v.Check(notEmpty(m.Name), "name", NewMessage(MsgRequired))
+	v.Check(len(m.Scopes) > 0, "scopes", NewMessage(MsgNoItems))
+	v.Check(unique(m.Scopes), "scopes", NewMessage(MsgDuplicateItems))
+	for _, s := range m.Scopes {
+		v.Check(matches(s, machineScopeRX), "scopes", NewMessage(MsgInvalidFormat))
+	}
+}
@@ -3,85 +3,276 @@ package mailer
 import (
 	"bytes"
 	"embed"
-	"html/template"
-	"time"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
 
-	"gopkg.in/mail.v2"
+	"github.com/aemdemir/auth"
 )
 
 const (
-	tmplEmailVerification = "email_verification.tmpl"
-	tmplPasswordReset     = "password_reset.tmpl"
+	tmplEmailVerification = "email_verification"
+	tmplPasswordReset     = "password_reset"
+	tmplAccountLink       = "account_link"
+	tmplPasswordSetup     = "password_setup"
+	tmplConfirmation      = "confirmation"
 )
 
-//go:embed "templates"
+//go:embed "templates/*.tmpl"
 var templateFS embed.FS
 
+// Config configures the sender and the common data of the emails.
+type Config struct {
+	Sender string
+	// ProductName and SupportURL are available to all templates.
+	ProductName string
+	SupportURL  string
+	// ActionBaseURL is joined with the ActionPaths to build the action urls,
+	// for example, the link to verify an email address. No links are rendered if it's empty.
+	ActionBaseURL string
+	ActionPaths   map[string]string
+	// Templates overrides and extends the bundled templates.
+	//
+	// A file in it replaces the bundled file with the same name,
+	// so, "email_verification.tmpl" replaces the default verification template,
+	// and "email_verification.fr.tmpl" adds a french one.
+	// Files starting with an underscore, like "_layout.tmpl", are layouts and partials
+	// shared by all templates.
+	Templates fs.FS
+}
+
+// DefaultActionPaths are the paths used if Config.ActionPaths is nil.
+// The code is appended to the path as the "token" query parameter.
+var DefaultActionPaths = map[string]string{
+	tmplEmailVerification: "/auth/verify",
+	tmplPasswordReset:     "/auth/reset",
+	tmplAccountLink:       "/auth/link",
+	tmplPasswordSetup:     "/auth/password",
+}
+
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport Transport
+	config    Config
+	templates map[string]*emailTemplate
 }
 
-func NewMailer(dialer *mail.Dialer, sender string) *Mailer {
-	return &Mailer{
-		dialer: dialer,
-		sender: sender,
-	}
+// emailTemplate is a template file parsed twice. The subject and the text body are plain text,
+// so they are rendered by text/template, and the html body is escaped by html/template.
+type emailTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
 }
 
-func (m *Mailer) send(recipient, mailType string, data any) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+mailType)
+// NewMailer parses the templates and returns the mailer which sends the emails with the transport.
+func NewMailer(transport Transport, config Config) (*Mailer, error) {
+	if config.ActionPaths == nil {
+		config.ActionPaths = DefaultActionPaths
+	}
+
+	templates, err := parseTemplates(config.Templates)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return &Mailer{
+		transport: transport,
+		config:    config,
+		templates: templates,
+	}, nil
+}
+
+func (m *Mailer) send(to auth.Recipient, mailType string, data map[string]any) error {
+	tmpl := m.lookup(mailType, to.Locale)
+	if tmpl == nil {
+		return fmt.Errorf("template %q is not found", mailType)
+	}
+
+	data["ProductName"] = m.config.ProductName
+	data["SupportURL"] = m.config.SupportURL
+	data["RecipientName"] = to.Name
+	code, _ := data["Code"].(string)
+	data["ActionURL"] = m.actionURL(mailType, code)
+
 	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	err := tmpl.text.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
 		return err
 	}
 
 	textBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(textBody, "textBody", data)
+	err = tmpl.text.ExecuteTemplate(textBody, "textBody", data)
 	if err != nil {
 		return err
 	}
 
 	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	err = tmpl.html.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
 		return err
 	}
 
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", textBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	return m.transport.Send(&Message{
+		From:    m.config.Sender,
+		To:      to.Address,
+		Subject: subject.String(),
+		Text:    textBody.String(),
+		HTML:    htmlBody.String(),
+		Code:    code,
+	})
+}
 
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		if err != nil {
-			time.Sleep(500 * time.Millisecond)
-			continue
-		}
-		return nil
+func (m *Mailer) SendVerificationEmail(to auth.Recipient, token string) error {
+	data := map[string]any{
+		"Code": token,
 	}
+	return m.send(to, tmplEmailVerification, data)
+}
 
-	return err
+func (m *Mailer) SendPasswordResetEmail(to auth.Recipient, token string) error {
+	data := map[string]any{
+		"Code": token,
+	}
+	return m.send(to, tmplPasswordReset, data)
 }
 
-func (m *Mailer) SendVerificationEmail(recipient, token string) error {
-	data := map[string]interface{}{
+func (m *Mailer) SendAccountLinkEmail(to auth.Recipient, token, provider string) error {
+	data := map[string]any{
+		"Code":     token,
+		"Provider": provider,
+	}
+	return m.send(to, tmplAccountLink, data)
+}
+
+func (m *Mailer) SendPasswordSetupEmail(to auth.Recipient, token string) error {
+	data := map[string]any{
 		"Code": token,
 	}
-	return m.send(recipient, tmplEmailVerification, data)
+	return m.send(to, tmplPasswordSetup, data)
 }
 
-func (m *Mailer) SendPasswordResetEmail(recipient, token string) error {
-	data := map[string]interface{}{
+func (m *Mailer) SendConfirmationEmail(to auth.Recipient, token string) error {
+	data := map[string]any{
 		"Code": token,
 	}
-	return m.send(recipient, tmplPasswordReset, data)
+	return m.send(to, tmplConfirmation, data)
+}
+
+// lookup returns the most specific template for the locale.
+// For example, for the locale "de-AT" it looks for
+// "name.de-AT", "name.de" and finally falls back to "name".
+func (m *Mailer) lookup(name, locale string) *emailTemplate {
+	for locale != "" {
+		if tmpl, ok := m.templates[name+"."+locale]; ok {
+			return tmpl
+		}
+
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return m.templates[name]
+}
+
+// actionURL returns the link for the mail type, or an empty string if it's not configured.
+func (m *Mailer) actionURL(mailType, code string) string {
+	p, ok := m.config.ActionPaths[mailType]
+	if !ok || m.config.ActionBaseURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(m.config.ActionBaseURL, "/"), p, url.QueryEscape(code))
+}
+
+// parseTemplates parses the bundled templates merged with the overrides,
+// keyed by the file name without the extension.
+func parseTemplates(overrides fs.FS) (map[string]*emailTemplate, error) {
+	sub, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := templateFiles(sub)
+	if err != nil {
+		return nil, err
+	}
+	if overrides != nil {
+		ff, err := templateFiles(overrides)
+		if err != nil {
+			return nil, err
+		}
+		for name, fsys := range ff {
+			files[name] = fsys
+		}
+	}
+
+	// the names are sorted, so that the same {{define}} in several partials
+	// is always resolved the same way, the last one by name wins.
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	partials := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, "_") {
+			partials = append(partials, name)
+		}
+	}
+
+	templates := make(map[string]*emailTemplate)
+	for _, name := range names {
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+
+		tmpl := &emailTemplate{
+			text: template.New("email"),
+			html: htmltemplate.New("email"),
+		}
+		for _, p := range partials {
+			if err := parseTemplate(tmpl, files[p], p); err != nil {
+				return nil, err
+			}
+		}
+		if err := parseTemplate(tmpl, files[name], name); err != nil {
+			return nil, err
+		}
+		templates[strings.TrimSuffix(name, ".tmpl")] = tmpl
+	}
+	return templates, nil
+}
+
+func parseTemplate(tmpl *emailTemplate, fsys fs.FS, name string) error {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if _, err := tmpl.text.New(name).Parse(string(b)); err != nil {
+		return fmt.Errorf("parse template %q: %w", name, err)
+	}
+	if _, err := tmpl.html.New(name).Parse(string(b)); err != nil {
+		return fmt.Errorf("parse template %q: %w", name, err)
+	}
+	return nil
+}
+
+// templateFiles maps the template file names in the root of fsys to fsys.
+func templateFiles(fsys fs.FS) (map[string]fs.FS, error) {
+	names, err := fs.Glob(fsys, "*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]fs.FS, len(names))
+	for _, name := range names {
+		files[path.Base(name)] = fsys
+	}
+	return files, nil
 }
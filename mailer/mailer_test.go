@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/aemdemir/auth"
+)
+
+func TestMailerEscaping(t *testing.T) {
+	tr := NewCaptureTransport()
+	m, err := NewMailer(tr, Config{ProductName: "Tom & Jerry's"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.SendVerificationEmail(auth.Recipient{Address: "tom@example.com", Name: "O'Brien <Tom>"}, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := tr.Messages()[0]
+
+	// the subject and the text body are plain text, they aren't escaped.
+	if msg.Subject != "Welcome to Tom & Jerry's!" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Hi O'Brien <Tom>,") || !strings.Contains(msg.Text, "Tom & Jerry's") {
+		t.Errorf("Text = %q", msg.Text)
+	}
+	// the html body is escaped.
+	if !strings.Contains(msg.HTML, "O&#39;Brien &lt;Tom&gt;") || strings.Contains(msg.HTML, "<Tom>") {
+		t.Errorf("HTML = %q", msg.HTML)
+	}
+}
+
+func TestMailerTemplateOverrides(t *testing.T) {
+	overrides := fstest.MapFS{
+		"_footer.tmpl":               {Data: []byte(`{{define "footer"}}a{{end}}`)},
+		"_zfooter.tmpl":              {Data: []byte(`{{define "footer"}}z{{end}}`)},
+		"email_verification.tmpl":    {Data: []byte(`{{define "subject"}}{{template "footer"}}{{end}}{{define "textBody"}}{{.Code}}{{end}}{{define "htmlBody"}}{{.Code}}{{end}}`)},
+		"email_verification.fr.tmpl": {Data: []byte(`{{define "subject"}}fr{{end}}{{define "textBody"}}{{.Code}}{{end}}{{define "htmlBody"}}{{.Code}}{{end}}`)},
+	}
+
+	// the partials are parsed by name, so the last one always wins.
+	for i := 0; i < 10; i++ {
+		tr := NewCaptureTransport()
+		m, err := NewMailer(tr, Config{Templates: overrides})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SendVerificationEmail(auth.Recipient{Address: "a@example.com"}, "1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SendVerificationEmail(auth.Recipient{Address: "a@example.com", Locale: "fr-CA"}, "1"); err != nil {
+			t.Fatal(err)
+		}
+		mm := tr.Messages()
+		if mm[0].Subject != "z" {
+			t.Fatalf("Subject = %q, want the footer of the last partial", mm[0].Subject)
+		}
+		if mm[1].Subject != "fr" {
+			t.Fatalf("Subject = %q, want the fr template", mm[1].Subject)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/mail.v2"
+)
+
+// Message is a rendered email.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Code is the code in the email, if any.
+	// It's not sent, but it's handy for the development transports.
+	Code string
+}
+
+// Transport delivers the rendered emails.
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// writeTo writes msg in the RFC 5322 format.
+func (msg *Message) writeTo(w io.Writer) error {
+	_, err := toMail(msg).WriteTo(w)
+	return err
+}
+
+func toMail(msg *Message) *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	m.AddAlternative("text/html", msg.HTML)
+	return m
+}
+
+//
+// SMTP
+//
+
+// SMTPTransport sends emails through persistent smtp connections.
+//
+// At most size connections are kept open, and the ones idle for longer
+// than idleTimeout are closed before reuse, since servers drop them anyway.
+type SMTPTransport struct {
+	dialer      *mail.Dialer
+	idleTimeout time.Duration
+	pool        chan *smtpConn
+}
+
+type smtpConn struct {
+	sc       mail.SendCloser
+	lastUsed time.Time
+}
+
+func NewSMTPTransport(dialer *mail.Dialer, size int, idleTimeout time.Duration) *SMTPTransport {
+	return &SMTPTransport{
+		dialer:      dialer,
+		idleTimeout: idleTimeout,
+		pool:        make(chan *smtpConn, size),
+	}
+}
+
+// Send sends msg with a pooled connection.
+// A broken connection is replaced once before giving up.
+func (t *SMTPTransport) Send(msg *Message) error {
+	m := toMail(msg)
+
+	c, err := t.get()
+	if err != nil {
+		return err
+	}
+	if err := mail.Send(c.sc, m); err != nil {
+		c.sc.Close()
+
+		c, err = t.dial()
+		if err != nil {
+			return err
+		}
+		if err := mail.Send(c.sc, m); err != nil {
+			c.sc.Close()
+			return err
+		}
+	}
+
+	t.put(c)
+	return nil
+}
+
+// Close closes the pooled connections.
+func (t *SMTPTransport) Close() error {
+	for {
+		select {
+		case c := <-t.pool:
+			c.sc.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (t *SMTPTransport) get() (*smtpConn, error) {
+	for {
+		select {
+		case c := <-t.pool:
+			if time.Since(c.lastUsed) > t.idleTimeout {
+				c.sc.Close()
+				continue
+			}
+			return c, nil
+		default:
+			return t.dial()
+		}
+	}
+}
+
+func (t *SMTPTransport) put(c *smtpConn) {
+	c.lastUsed = time.Now()
+	select {
+	case t.pool <- c:
+	default:
+		// pool is full.
+		c.sc.Close()
+	}
+}
+
+func (t *SMTPTransport) dial() (*smtpConn, error) {
+	sc, err := t.dialer.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return &smtpConn{sc: sc, lastUsed: time.Now()}, nil
+}
+
+//
+// File
+//
+
+// FileTransport writes emails to a maildir, so they can be opened by any mail client.
+type FileTransport struct {
+	dir string
+	mu  sync.Mutex
+	seq int
+}
+
+// NewFileTransport creates the maildir structure under dir if it doesn't exist.
+// The emails contain the codes and the links of the users, so only the owner can read them.
+func NewFileTransport(dir string) (*FileTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+// Send writes msg to tmp first, then moves it to new,
+// so that readers never see partially written emails.
+func (t *FileTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	t.seq++
+	name := fmt.Sprintf("%d.%d_%d.auth.eml", time.Now().UnixNano(), os.Getpid(), t.seq)
+	t.mu.Unlock()
+
+	tmp := filepath.Join(t.dir, "tmp", name)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := msg.writeTo(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, "new", name))
+}
+
+//
+// Log
+//
+
+// LogTransport logs emails instead of sending them, for local development.
+type LogTransport struct {
+	logger zerolog.Logger
+}
+
+func NewLogTransport(logger zerolog.Logger) *LogTransport {
+	return &LogTransport{logger: logger}
+}
+
+func (t *LogTransport) Send(msg *Message) error {
+	t.logger.Info().
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("code", msg.Code).
+		Str("text", msg.Text).
+		Msg("email")
+	return nil
+}
+
+//
+// Capture
+//
+
+// CaptureTransport keeps emails in memory, for tests.
+type CaptureTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewCaptureTransport() *CaptureTransport {
+	return &CaptureTransport{}
+}
+
+func (t *CaptureTransport) Send(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, *msg)
+	return nil
+}
+
+// Messages returns the captured emails in the order they are sent.
+func (t *CaptureTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	mm := make([]Message, len(t.messages))
+	copy(mm, t.messages)
+	return mm
+}
+
+// Last returns the last email sent to the address, or nil if there is none.
+func (t *CaptureTransport) Last(address string) *Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := len(t.messages) - 1; i >= 0; i-- {
+		if recipientAddress(t.messages[i].To) == strings.ToLower(address) {
+			m := t.messages[i]
+			return &m
+		}
+	}
+	return nil
+}
+
+// LastCode returns the code in the last email sent to the address,
+// or an empty string if there is none.
+func (t *CaptureTransport) LastCode(address string) string {
+	if m := t.Last(address); m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+// Reset clears the captured emails.
+func (t *CaptureTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
+
+// recipientAddress returns the lowercased address of a "Name <address>" recipient.
+func recipientAddress(to string) string {
+	if i := strings.LastIndex(to, "<"); i >= 0 {
+		to = strings.TrimSuffix(to[i+1:], ">")
+	}
+	return strings.ToLower(strings.TrimSpace(to))
+}
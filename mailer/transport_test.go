@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCaptureTransport(t *testing.T) {
+	tr := NewCaptureTransport()
+	send := func(to, code string) {
+		if err := tr.Send(&Message{To: to, Subject: "subject", Code: code}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send("Jane <Jane@example.com>", "111111")
+	send("john@example.com", "222222")
+	send("jane@example.com", "333333")
+
+	mm := tr.Messages()
+	if len(mm) != 3 || mm[0].Code != "111111" || mm[2].Code != "333333" {
+		t.Fatalf("Messages() = %+v", mm)
+	}
+	// the returned slice is a copy.
+	mm[0].Code = "changed"
+	if tr.Messages()[0].Code != "111111" {
+		t.Errorf("Messages() shares the captured emails")
+	}
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"jane@example.com", "333333"},
+		{"JANE@example.com", "333333"},
+		{"john@example.com", "222222"},
+		{"nobody@example.com", ""},
+	}
+	for _, tt := range tests {
+		if got := tr.LastCode(tt.address); got != tt.want {
+			t.Errorf("LastCode(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+	if tr.Last("nobody@example.com") != nil {
+		t.Errorf("Last() of an unknown address is not nil")
+	}
+
+	tr.Reset()
+	if len(tr.Messages()) != 0 || tr.Last("jane@example.com") != nil {
+		t.Errorf("Reset() kept the emails")
+	}
+}
+
+func TestCaptureTransportConcurrent(t *testing.T) {
+	tr := NewCaptureTransport()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tr.Send(&Message{To: "jane@example.com"})
+			tr.Last("jane@example.com")
+		}()
+	}
+	wg.Wait()
+
+	if n := len(tr.Messages()); n != 50 {
+		t.Errorf("captured %d emails, want 50", n)
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	tr, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		fi, err := os.Stat(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0700 {
+			t.Errorf("%s mode = %o, want 700", sub, perm)
+		}
+	}
+
+	err = tr.Send(&Message{From: "auth@example.com", To: "jane@example.com", Subject: "Verify", Text: "code 123456", HTML: "<p>code 123456</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, _ := os.ReadDir(filepath.Join(dir, "tmp"))
+	if len(tmp) != 0 {
+		t.Errorf("tmp has %d files", len(tmp))
+	}
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("new has %d files, %v", len(files), err)
+	}
+	fi, err := files[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("email mode = %o, want 600", perm)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "Subject: Verify") || !strings.Contains(string(b), "code 123456") {
+		t.Errorf("email = %s", b)
+	}
+}
@@ -0,0 +1,199 @@
+package auth
+
+import "fmt"
+
+// validation message codes.
+//
+// The codes are stable, clients may rely on them to show their own messages.
+const (
+	MsgRequired          = "required"
+	MsgTooShort          = "too_short"
+	MsgTooLong           = "too_long"
+	MsgTooLongBytes      = "too_long_bytes"
+	MsgInvalidFormat     = "invalid_format"
+	MsgInvalidEmail      = "invalid_email"
+	MsgInvalidUsername   = "invalid_username"
+	MsgReservedUsername  = "reserved_username"
+	MsgInvalidURL        = "invalid_url"
+	MsgNoItems           = "no_items"
+	MsgDuplicateItems    = "duplicate_items"
+	MsgUnknownItems      = "unknown_items"
+	MsgUnsupportedLocale = "unsupported_locale"
+	MsgUnknownProvider   = "unknown_provider"
+	MsgUnknownMethod     = "unknown_method"
+	MsgUnknownKind       = "unknown_kind"
+	MsgEmailAlias        = "email_alias"
+	MsgDisposableEmail   = "disposable_email"
+	MsgEmailDomainDenied = "email_domain_denied"
+	MsgWeakPassword      = "weak_password"
+	MsgBreachedPassword  = "breached_password"
+	MsgReusedPassword    = "reused_password"
+	MsgPasswordClasses   = "password_char_classes"
+
+	// password strength feedback.
+	MsgPasswordCommon        = "password_common"
+	MsgPasswordWord          = "password_word"
+	MsgPasswordPersonal      = "password_personal"
+	MsgPasswordSpatial       = "password_spatial"
+	MsgPasswordRepeat        = "password_repeat"
+	MsgPasswordSequence      = "password_sequence"
+	MsgPasswordDate          = "password_date"
+	MsgPasswordAddWords      = "password_add_words"
+	MsgPasswordAvoidPersonal = "password_avoid_personal"
+)
+
+// catalogs maps locales to the message formats of the codes.
+// The formats are passed to fmt.Sprintf with the message args.
+var catalogs = map[string]map[string]string{
+	"en": {
+		MsgRequired:          "must be provided",
+		MsgTooShort:          "cannot be shorter than %d characters",
+		MsgTooLong:           "cannot be longer than %d characters",
+		MsgTooLongBytes:      "cannot be longer than %d bytes",
+		MsgInvalidFormat:     "must be in a valid format",
+		MsgInvalidEmail:      "must be a valid email address",
+		MsgInvalidUsername:   "can only contain alphanumeric characters and underscores",
+		MsgReservedUsername:  "cannot be a reserved name, for example, login, register etc.",
+		MsgInvalidURL:        "must be an absolute http or https url",
+		MsgNoItems:           "must contain at least one item",
+		MsgDuplicateItems:    "must not contain duplicate values",
+		MsgUnknownItems:      "must only contain known values",
+		MsgUnsupportedLocale: "must be a supported locale",
+		MsgUnknownProvider:   "must be a configured provider",
+		MsgUnknownMethod:     "must be one of %s",
+		MsgUnknownKind:       "must be one of %s",
+		MsgEmailAlias:        "must not be an alias of another account's email",
+		MsgDisposableEmail:   "must not be a disposable email address",
+		MsgEmailDomainDenied: "must use an allowed email domain",
+		MsgWeakPassword:      "is too easy to guess",
+		MsgBreachedPassword:  "has appeared in a data breach, so it's easy to guess",
+		MsgReusedPassword:    "must not be one of your last %d passwords",
+		MsgPasswordClasses:   "must contain at least %d of lowercase letters, uppercase letters, digits and symbols",
+
+		MsgPasswordCommon:        "This is a commonly used password.",
+		MsgPasswordWord:          "A single word is easy to guess.",
+		MsgPasswordPersonal:      "Your name, username or email is easy to guess.",
+		MsgPasswordSpatial:       "Keyboard patterns like qwerty are easy to guess.",
+		MsgPasswordRepeat:        "Repeats like aaa or abcabc are easy to guess.",
+		MsgPasswordSequence:      "Sequences like abc or 6543 are easy to guess.",
+		MsgPasswordDate:          "Dates and years are easy to guess.",
+		MsgPasswordAddWords:      "Add a few more words, uncommon words are better.",
+		MsgPasswordAvoidPersonal: "Avoid your name, username and email.",
+	},
+	"de": {
+		MsgRequired:          "muss angegeben werden",
+		MsgTooShort:          "darf nicht kürzer als %d Zeichen sein",
+		MsgTooLong:           "darf nicht länger als %d Zeichen sein",
+		MsgTooLongBytes:      "darf nicht länger als %d Bytes sein",
+		MsgInvalidFormat:     "muss ein gültiges Format haben",
+		MsgInvalidEmail:      "muss eine gültige E-Mail-Adresse sein",
+		MsgInvalidUsername:   "darf nur Buchstaben, Ziffern und Unterstriche enthalten",
+		MsgReservedUsername:  "darf kein reservierter Name sein, zum Beispiel login, register usw.",
+		MsgInvalidURL:        "muss eine absolute http- oder https-URL sein",
+		MsgNoItems:           "muss mindestens einen Eintrag enthalten",
+		MsgDuplicateItems:    "darf keine doppelten Werte enthalten",
+		MsgUnknownItems:      "darf nur bekannte Werte enthalten",
+		MsgUnsupportedLocale: "muss eine unterstützte Sprache sein",
+		MsgUnknownProvider:   "muss ein konfigurierter Anbieter sein",
+		MsgUnknownMethod:     "muss eines von %s sein",
+		MsgUnknownKind:       "muss eines von %s sein",
+		MsgEmailAlias:        "darf kein Alias der E-Mail-Adresse eines anderen Kontos sein",
+		MsgDisposableEmail:   "darf keine Wegwerf-E-Mail-Adresse sein",
+		MsgEmailDomainDenied: "muss eine erlaubte E-Mail-Domain verwenden",
+		MsgWeakPassword:      "ist zu leicht zu erraten",
+		MsgBreachedPassword:  "ist in einem Datenleck aufgetaucht und daher leicht zu erraten",
+		MsgReusedPassword:    "darf keines deiner letzten %d Passwörter sein",
+		MsgPasswordClasses:   "muss mindestens %d von Kleinbuchstaben, Großbuchstaben, Ziffern und Sonderzeichen enthalten",
+
+		MsgPasswordCommon:        "Das ist ein häufig verwendetes Passwort.",
+		MsgPasswordWord:          "Ein einzelnes Wort ist leicht zu erraten.",
+		MsgPasswordPersonal:      "Dein Name, Benutzername oder deine E-Mail-Adresse ist leicht zu erraten.",
+		MsgPasswordSpatial:       "Tastaturmuster wie qwertz sind leicht zu erraten.",
+		MsgPasswordRepeat:        "Wiederholungen wie aaa oder abcabc sind leicht zu erraten.",
+		MsgPasswordSequence:      "Folgen wie abc oder 6543 sind leicht zu erraten.",
+		MsgPasswordDate:          "Daten und Jahreszahlen sind leicht zu erraten.",
+		MsgPasswordAddWords:      "Füge ein paar weitere Wörter hinzu, ungewöhnliche Wörter sind besser.",
+		MsgPasswordAvoidPersonal: "Vermeide deinen Namen, Benutzernamen und deine E-Mail-Adresse.",
+	},
+	"tr": {
+		MsgRequired:          "girilmesi zorunludur",
+		MsgTooShort:          "%d karakterden kısa olamaz",
+		MsgTooLong:           "%d karakterden uzun olamaz",
+		MsgTooLongBytes:      "%d bayttan uzun olamaz",
+		MsgInvalidFormat:     "geçerli bir biçimde olmalıdır",
+		MsgInvalidEmail:      "geçerli bir e-posta adresi olmalıdır",
+		MsgInvalidUsername:   "yalnızca harf, rakam ve alt çizgi içerebilir",
+		MsgReservedUsername:  "ayrılmış bir isim olamaz, örneğin login, register vb.",
+		MsgInvalidURL:        "mutlak bir http veya https adresi olmalıdır",
+		MsgNoItems:           "en az bir değer içermelidir",
+		MsgDuplicateItems:    "tekrarlanan değerler içeremez",
+		MsgUnknownItems:      "yalnızca bilinen değerler içerebilir",
+		MsgUnsupportedLocale: "desteklenen bir dil olmalıdır",
+		MsgUnknownProvider:   "yapılandırılmış bir sağlayıcı olmalıdır",
+		MsgUnknownMethod:     "%s değerlerinden biri olmalıdır",
+		MsgUnknownKind:       "%s değerlerinden biri olmalıdır",
+		MsgEmailAlias:        "başka bir hesabın e-posta adresinin takma adı olmamalıdır",
+		MsgDisposableEmail:   "geçici bir e-posta adresi olmamalıdır",
+		MsgEmailDomainDenied: "izin verilen bir e-posta alan adı kullanmalıdır",
+		MsgWeakPassword:      "tahmin edilmesi çok kolay",
+		MsgBreachedPassword:  "bir veri sızıntısında yer aldığı için tahmin edilmesi kolaydır",
+		MsgReusedPassword:    "son %d parolanızdan biri olmamalıdır",
+		MsgPasswordClasses:   "küçük harf, büyük harf, rakam ve sembollerden en az %d türünü içermelidir",
+
+		MsgPasswordCommon:        "Bu sık kullanılan bir parola.",
+		MsgPasswordWord:          "Tek bir kelimeyi tahmin etmek kolaydır.",
+		MsgPasswordPersonal:      "Adınızı, kullanıcı adınızı veya e-postanızı tahmin etmek kolaydır.",
+		MsgPasswordSpatial:       "qwerty gibi klavye desenlerini tahmin etmek kolaydır.",
+		MsgPasswordRepeat:        "aaa veya abcabc gibi tekrarları tahmin etmek kolaydır.",
+		MsgPasswordSequence:      "abc veya 6543 gibi dizileri tahmin etmek kolaydır.",
+		MsgPasswordDate:          "Tarihleri ve yılları tahmin etmek kolaydır.",
+		MsgPasswordAddWords:      "Birkaç kelime daha ekleyin, az kullanılan kelimeler daha iyidir.",
+		MsgPasswordAvoidPersonal: "Adınızı, kullanıcı adınızı ve e-postanızı kullanmaktan kaçının.",
+	},
+}
+
+// Message is a validation message identified by a stable code.
+type Message struct {
+	Code string
+	Args []any
+}
+
+func NewMessage(code string, args ...any) Message {
+	return Message{Code: code, Args: args}
+}
+
+// Localize returns the message in the given locale.
+// It falls back to the default locale, and then to the code itself.
+func (m Message) Localize(locale string) string {
+	format, ok := catalogs[locale][m.Code]
+	if !ok {
+		format, ok = catalogs[DefaultLocale][m.Code]
+	}
+	if !ok {
+		return m.Code
+	}
+	if len(m.Args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, m.Args...)
+}
+
+// String returns the message in the default locale.
+func (m Message) String() string {
+	return m.Localize(DefaultLocale)
+}
+
+// LocalizeDetail returns the error detail in the given locale.
+// Details without message codes are returned as is.
+func LocalizeDetail(err error, locale string) map[string]string {
+	codes := ErrorCodes(err)
+	if codes == nil {
+		return ErrorDetail(err)
+	}
+
+	detail := make(map[string]string, len(codes))
+	for k, m := range codes {
+		detail[k] = m.Localize(locale)
+	}
+	return detail
+}
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_updated_timestamp ON webhook;
+DROP TABLE IF EXISTS webhook;
\ No newline at end of file
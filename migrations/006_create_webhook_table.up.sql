@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS webhook (
+    id          BIGSERIAL    NOT NULL,
+    url         TEXT         NOT NULL,
+    events      TEXT[]       NOT NULL,
+    secret      TEXT         NOT NULL,
+    active      BOOLEAN      NOT NULL DEFAULT true,
+    created     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON webhook
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
\ No newline at end of file
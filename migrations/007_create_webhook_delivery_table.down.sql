@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS webhook_attempt;
+DROP TRIGGER IF EXISTS update_updated_timestamp ON webhook_delivery;
+DROP TABLE IF EXISTS webhook_delivery;
\ No newline at end of file
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id           BIGSERIAL    NOT NULL,
+    webhook_id   BIGINT       NOT NULL,
+    event_id     TEXT         NOT NULL,
+    event        TEXT         NOT NULL,
+    payload      JSONB        NOT NULL,
+    status       TEXT         NOT NULL DEFAULT 'pending',
+    attempts     INTEGER      NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY  (id),
+    CONSTRAINT   fk_webhook_delivery_webhook_id FOREIGN KEY (webhook_id) REFERENCES webhook (id) ON DELETE CASCADE,
+    CONSTRAINT   check_status                   CHECK (status IN ('pending', 'succeeded', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_status_next_attempt ON webhook_delivery (status, next_attempt);
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON webhook_delivery
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
+
+CREATE TABLE IF NOT EXISTS webhook_attempt (
+    delivery_id  BIGINT       NOT NULL,
+    status_code  INTEGER      NOT NULL,
+    error        TEXT,
+    duration     INTEGER      NOT NULL,
+    created      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT   fk_webhook_attempt_delivery_id FOREIGN KEY (delivery_id) REFERENCES webhook_delivery (id) ON DELETE CASCADE
+);
\ No newline at end of file
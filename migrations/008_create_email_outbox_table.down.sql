@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_updated_timestamp ON email_outbox;
+DROP TABLE IF EXISTS email_outbox;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id           BIGSERIAL    NOT NULL,
+    kind         TEXT         NOT NULL,
+    recipient    TEXT         NOT NULL,
+    data         JSONB        NOT NULL,
+    status       TEXT         NOT NULL DEFAULT 'pending',
+    attempts     INTEGER      NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error   TEXT,
+    created      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated      TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY  (id),
+    CONSTRAINT   check_status CHECK (status IN ('pending', 'sent', 'dead'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_outbox_status_next_attempt ON email_outbox (status, next_attempt);
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON email_outbox
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
\ No newline at end of file
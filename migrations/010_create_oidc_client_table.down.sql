@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS update_updated_timestamp ON oidc_consent;
+DROP TABLE IF EXISTS oidc_consent;
+DROP TRIGGER IF EXISTS update_updated_timestamp ON oidc_client;
+DROP TABLE IF EXISTS oidc_client;
\ No newline at end of file
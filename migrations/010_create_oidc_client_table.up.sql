@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS oidc_client (
+    id            TEXT         NOT NULL,
+    name          TEXT         NOT NULL,
+    secret_hash   BYTEA,
+    redirect_uris TEXT[]       NOT NULL,
+    scopes        TEXT[]       NOT NULL,
+    created       TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated       TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY   (id)
+);
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON oidc_client
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
+
+CREATE TABLE IF NOT EXISTS oidc_consent (
+    user_id     BIGINT       NOT NULL,
+    client_id   TEXT         NOT NULL,
+    scopes      TEXT[]       NOT NULL,
+    created     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT  fk_oidc_consent_user_id   FOREIGN KEY (user_id)   REFERENCES users (id)       ON DELETE CASCADE,
+    CONSTRAINT  fk_oidc_consent_client_id FOREIGN KEY (client_id) REFERENCES oidc_client (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON oidc_consent
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS machine_token;
+DROP TRIGGER IF EXISTS update_updated_timestamp ON machine_client;
+DROP TABLE IF EXISTS machine_client;
\ No newline at end of file
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS machine_client (
+    id          TEXT         NOT NULL,
+    name        TEXT         NOT NULL,
+    secret_hash BYTEA        NOT NULL,
+    scopes      TEXT[]       NOT NULL,
+    active      BOOLEAN      NOT NULL DEFAULT true,
+    created     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON machine_client
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
+
+CREATE TABLE IF NOT EXISTS machine_token (
+    hash        BYTEA        NOT NULL,
+    client_id   TEXT         NOT NULL,
+    scopes      TEXT[]       NOT NULL,
+    expiry      TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    created     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT  fk_machine_token_client_id FOREIGN KEY (client_id) REFERENCES machine_client (id) ON DELETE CASCADE,
+    PRIMARY KEY (hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_machine_token_client_id ON machine_token (client_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+-- NOT VALID keeps the accounts of the providers added in the meantime.
+ALTER TABLE user_account ADD CONSTRAINT check_provider_name
+    CHECK (provider_name IN ('google', 'twitter')) NOT VALID;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE user_account DROP CONSTRAINT IF EXISTS check_provider_name;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS oauth_session;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS oauth_session (
+    id          TEXT         NOT NULL,
+    data        TEXT         NOT NULL,
+    expiry      TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    created     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_session_expiry ON oauth_session (expiry);
\ No newline at end of file
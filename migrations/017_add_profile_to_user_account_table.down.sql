@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS update_updated_timestamp ON user_account;
+
+ALTER TABLE user_account
+    DROP COLUMN IF EXISTS email,
+    DROP COLUMN IF EXISTS name,
+    DROP COLUMN IF EXISTS avatar_url,
+    DROP COLUMN IF EXISTS locale,
+    DROP COLUMN IF EXISTS raw_claims,
+    DROP COLUMN IF EXISTS updated;
\ No newline at end of file
@@ -0,0 +1,10 @@
+ALTER TABLE user_account
+    ADD COLUMN IF NOT EXISTS email      TEXT,
+    ADD COLUMN IF NOT EXISTS name       TEXT,
+    ADD COLUMN IF NOT EXISTS avatar_url TEXT,
+    ADD COLUMN IF NOT EXISTS locale     TEXT,
+    ADD COLUMN IF NOT EXISTS raw_claims JSONB        NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS updated    TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON user_account
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE user_account DROP COLUMN IF EXISTS encrypted_tokens;
\ No newline at end of file
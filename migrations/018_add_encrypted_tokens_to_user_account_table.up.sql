@@ -0,0 +1 @@
+ALTER TABLE user_account ADD COLUMN IF NOT EXISTS encrypted_tokens BYTEA;
\ No newline at end of file
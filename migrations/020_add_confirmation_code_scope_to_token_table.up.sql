@@ -0,0 +1,3 @@
+ALTER TABLE token DROP CONSTRAINT IF EXISTS check_scope;
+ALTER TABLE token ADD CONSTRAINT check_scope
+    CHECK (scope IN ('auth', 'confirmation', 'email_verification', 'password_reset', 'oidc_code', 'oidc_access', 'signin_code', 'account_link', 'account_link_verification', 'password_setup', 'confirmation_code'));
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id          BIGINT       NOT NULL,
+    encrypted_secret BYTEA        NOT NULL,
+    enabled          BOOLEAN      NOT NULL DEFAULT false,
+    last_counter     BIGINT       NOT NULL DEFAULT 0,
+    failed_attempts  INTEGER      NOT NULL DEFAULT 0,
+    locked_until     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT 'epoch',
+    created          TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated          TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id),
+    CONSTRAINT fk_user_totp_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE OR REPLACE TRIGGER update_updated_timestamp BEFORE INSERT OR UPDATE ON user_totp
+    FOR EACH ROW EXECUTE FUNCTION update_updated_timestamp();
\ No newline at end of file
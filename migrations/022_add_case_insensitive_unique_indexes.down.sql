@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS uq_user_username_lower;
+ALTER TABLE users ADD CONSTRAINT uq_user_username UNIQUE (username);
+
+DROP INDEX IF EXISTS uq_user_email_address_lower;
+ALTER TABLE user_email ADD CONSTRAINT uq_user_email_address UNIQUE (address);
\ No newline at end of file
@@ -0,0 +1,47 @@
+-- the existing identities must be unique case-insensitively before the indexes are created,
+-- the migration fails with the colliding rows otherwise, so that they can be merged or renamed first.
+DO $$
+DECLARE
+    collisions TEXT;
+BEGIN
+    SELECT string_agg(format('%s (user ids: %s)', address, user_ids), '; ')
+    INTO   collisions
+    FROM (
+        SELECT   lower(trim(address)) AS address, string_agg(user_id::TEXT, ', ' ORDER BY user_id) AS user_ids
+        FROM     user_email
+        GROUP BY lower(trim(address))
+        HAVING   count(*) > 1
+    ) AS c;
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'email addresses collide case-insensitively: %', collisions
+            USING HINT = 'merge or delete the colliding emails, then run the migration again';
+    END IF;
+
+    SELECT string_agg(format('%s (user ids: %s)', username, user_ids), '; ')
+    INTO   collisions
+    FROM (
+        SELECT   lower(normalize(trim(username), NFKC)) AS username, string_agg(id::TEXT, ', ' ORDER BY id) AS user_ids
+        FROM     users
+        GROUP BY lower(normalize(trim(username), NFKC))
+        HAVING   count(*) > 1
+    ) AS c;
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'usernames collide case-insensitively: %', collisions
+            USING HINT = 'rename the colliding users, then run the migration again';
+    END IF;
+END
+$$;
+
+UPDATE user_email
+SET    address = substring(trim(address) FROM '^(.*)@') || '@' || lower(substring(trim(address) FROM '@([^@]*)$'))
+WHERE  address <> substring(trim(address) FROM '^(.*)@') || '@' || lower(substring(trim(address) FROM '@([^@]*)$'));
+
+UPDATE users
+SET    username = normalize(trim(username), NFKC)
+WHERE  username <> normalize(trim(username), NFKC);
+
+ALTER TABLE user_email DROP CONSTRAINT IF EXISTS uq_user_email_address;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_user_email_address_lower ON user_email (lower(address));
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS uq_user_username;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_user_username_lower ON users (lower(username));
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_user_email_canonical_address;
+ALTER TABLE user_email DROP COLUMN IF EXISTS canonical_address;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE user_email ADD COLUMN IF NOT EXISTS canonical_address TEXT;
+
+-- the existing emails are canonicalized by service.CanonicalizeEmails at startup,
+-- since the rules of the canonicalizer are configured in go.
+CREATE INDEX IF NOT EXISTS idx_user_email_canonical_address ON user_email (canonical_address);
\ No newline at end of file
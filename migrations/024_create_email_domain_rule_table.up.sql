@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS email_domain_rule (
+    domain      TEXT         NOT NULL,
+    kind        TEXT         NOT NULL,
+    created     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT  check_kind CHECK (kind IN ('allow', 'deny')),
+    PRIMARY KEY (domain)
+);
\ No newline at end of file
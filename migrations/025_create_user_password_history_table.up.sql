@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_password_history (
+    id            BIGSERIAL    NOT NULL,
+    user_id       BIGINT       NOT NULL,
+    password_hash BYTEA        NOT NULL,
+    created       TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id),
+    CONSTRAINT fk_user_password_history_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_password_history_user_id ON user_password_history (user_id, id);
\ No newline at end of file
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeEmail trims the address and lowercases its domain. The local part is kept as it's typed,
+// since the mail server may treat it case-sensitively, but the addresses are unique case-insensitively.
+func NormalizeEmail(address string) string {
+	address = strings.TrimSpace(address)
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return address
+	}
+	return address[:i] + strings.ToLower(address[i:])
+}
+
+// NormalizeUsername trims the username and applies the unicode NFKC normalization,
+// so that e.g. the fullwidth letters become ascii. The usernames are unique case-insensitively.
+func NormalizeUsername(username string) string {
+	return norm.NFKC.String(strings.TrimSpace(username))
+}
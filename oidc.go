@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// oidc scopes.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// OIDCScopes lists the scopes a client can request.
+var OIDCScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// oauth error codes, as defined in RFC 6749 and OpenID Connect Core.
+const (
+	OAuthInvalidRequest       = "invalid_request"
+	OAuthInvalidClient        = "invalid_client"
+	OAuthInvalidGrant         = "invalid_grant"
+	OAuthInvalidScope         = "invalid_scope"
+	OAuthInvalidToken         = "invalid_token"
+	OAuthUnauthorizedClient   = "unauthorized_client"
+	OAuthUnsupportedGrantType = "unsupported_grant_type"
+	OAuthUnsupportedResponse  = "unsupported_response_type"
+	OAuthAccessDenied         = "access_denied"
+	OAuthConsentRequired      = "consent_required"
+	OAuthServerError          = "server_error"
+)
+
+// OIDCService lets other apps sign in users with this service, as an OpenID Connect provider.
+type OIDCService interface {
+	CreateOIDCClient(ctx context.Context, client OIDCClientInput) (*OIDCClient, error)
+	ListOIDCClients(ctx context.Context) ([]OIDCClient, error)
+	DeleteOIDCClient(ctx context.Context, id string) error
+	ValidateAuthorization(ctx context.Context, authz AuthorizeInput) (*Authorization, error)
+	Authorize(ctx context.Context, uid int, authz AuthorizeInput) (*Authorization, error)
+	GrantConsent(ctx context.Context, uid int, consent ConsentInput) (*Authorization, error)
+	ExchangeToken(ctx context.Context, exchange TokenExchangeInput) (*OAuthTokens, error)
+	GetUserInfo(ctx context.Context, token TokenInput) (*UserInfo, error)
+	OpenIDConfiguration() OpenIDConfiguration
+	JWKS() JWKS
+}
+
+// OAuthError is an error returned by the oauth endpoints.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("oauth error: code=%s description=%s", e.Code, e.Description)
+}
+
+// OIDCClient is an app which signs in users with this service.
+//
+// Public clients, like SPAs and mobile apps, can't keep a secret,
+// so they don't have one and must use PKCE instead.
+// Secret is only returned once when the client is created.
+type OIDCClient struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	Secret       string    `json:"secret,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// Authorization is the result of an authorization request.
+//
+// RedirectTo is set when the request is complete, successfully or not,
+// and the user agent must be redirected to it. Otherwise, if ConsentRequired
+// is true, the user must be asked for consent.
+type Authorization struct {
+	Client          OIDCClient `json:"client"`
+	Scopes          []string   `json:"scopes"`
+	ConsentRequired bool       `json:"consent_required"`
+	RedirectTo      string     `json:"redirect_to,omitempty"`
+}
+
+// OAuthTokens is the token endpoint response.
+type OAuthTokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+	IDToken     string `json:"id_token,omitempty"`
+}
+
+// UserInfo holds the standard claims of a user, based on the granted scopes.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Locale            string `json:"locale,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// IDTokenClaims are the claims of an ID token.
+type IDTokenClaims struct {
+	UserInfo
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	Expiry   int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+	Nonce    string `json:"nonce,omitempty"`
+}
+
+// OpenIDConfiguration is the provider metadata served at /.well-known/openid-configuration.
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+//
+// Inputs
+//
+
+type OIDCClientInput struct {
+	Name         string
+	RedirectURIs []string
+	Scopes       []string
+	Public       bool
+}
+
+func (o OIDCClientInput) Validate(v *validator) {
+	v.Check(notEmpty(o.Name), "name", NewMessage(MsgRequired))
+	v.Check(len(o.RedirectURIs) > 0, "redirect_uris", NewMessage(MsgNoItems))
+	v.Check(unique(o.RedirectURIs), "redirect_uris", NewMessage(MsgDuplicateItems))
+	for _, u := range o.RedirectURIs {
+		v.Check(validRedirectURI(u), "redirect_uris", NewMessage(MsgInvalidURL))
+	}
+	v.Check(in(ScopeOpenID, o.Scopes...), "scopes", NewMessage(MsgRequired))
+	v.Check(unique(o.Scopes), "scopes", NewMessage(MsgDuplicateItems))
+	for _, s := range o.Scopes {
+		v.Check(in(s, OIDCScopes...), "scopes", NewMessage(MsgUnknownItems))
+	}
+}
+
+// AuthorizeInput holds the parameters of an authorization request.
+type AuthorizeInput struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+// Scopes returns the requested scopes.
+func (a AuthorizeInput) Scopes() []string {
+	return strings.Fields(a.Scope)
+}
+
+func (a AuthorizeInput) Validate(v *validator) {
+	v.Check(notEmpty(a.ClientID), "client_id", NewMessage(MsgRequired))
+	v.Check(notEmpty(a.RedirectURI), "redirect_uri", NewMessage(MsgRequired))
+	v.Check(in(ScopeOpenID, a.Scopes()...), "scope", NewMessage(MsgRequired))
+	v.Check(a.CodeChallenge == "" || a.CodeChallengeMethod == "S256", "code_challenge_method", NewMessage(MsgInvalidFormat))
+	v.Check(in(a.Prompt, "", "none", "consent"), "prompt", NewMessage(MsgInvalidFormat))
+}
+
+// ConsentInput is the answer of the user to an authorization request.
+type ConsentInput struct {
+	AuthorizeInput
+	Approve bool
+}
+
+// TokenExchangeInput holds the parameters of a token request.
+type TokenExchangeInput struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+	Scope        string
+}
+
+//
+// Helpers
+//
+
+// validRedirectURI returns true if s is an absolute url without a fragment.
+// Custom schemes are allowed for native apps.
+func validRedirectURI(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return u.IsAbs() && u.Fragment == "" && (u.Host != "" || (u.Scheme != "http" && u.Scheme != "https"))
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	_ "embed"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Policy is the rules of the usernames and passwords, which are checked by the Validate methods of the inputs.
+// The zero values of the limits are the ones of the DefaultPolicy.
+type Policy struct {
+	MinUsernameLength int
+	MaxUsernameLength int
+	// UsernamePattern is the allowed characters of the usernames.
+	UsernamePattern *regexp.Regexp
+	// UsernameMessage is the error of the usernames which don't match the pattern, it's invalid_username by default.
+	// A custom pattern should set a message which describes it, e.g. NewMessage(MsgInvalidFormat).
+	UsernameMessage Message
+	// ReservedUsernames can't be taken by the users, it's the bundled list of DefaultReservedUsernames by default.
+	ReservedUsernames NameList
+	MinPasswordLength int
+	// MaxPasswordBytes can't be more than 72, the limit of bcrypt.
+	MaxPasswordBytes int
+	// PasswordCharClasses is the number of the character classes a password must contain,
+	// out of the lowercase letters, uppercase letters, digits and symbols.
+	PasswordCharClasses int
+}
+
+// DefaultPolicy is the policy of the validators, unless a service is configured with another one.
+var DefaultPolicy = Policy{
+	MinUsernameLength: 4,
+	MaxUsernameLength: 15,
+	UsernamePattern:   usernameRX,
+	UsernameMessage:   NewMessage(MsgInvalidUsername),
+	MinPasswordLength: 6,
+	MaxPasswordBytes:  maxPasswordBytes,
+}
+
+// WithDefaults returns the policy with the limits it doesn't set taken from the DefaultPolicy.
+func (p Policy) WithDefaults() Policy {
+	if p.MinUsernameLength == 0 {
+		p.MinUsernameLength = DefaultPolicy.MinUsernameLength
+	}
+	if p.MaxUsernameLength == 0 {
+		p.MaxUsernameLength = DefaultPolicy.MaxUsernameLength
+	}
+	if p.UsernamePattern == nil {
+		p.UsernamePattern = DefaultPolicy.UsernamePattern
+	}
+	if p.UsernameMessage.Code == "" {
+		p.UsernameMessage = DefaultPolicy.UsernameMessage
+	}
+	if p.ReservedUsernames == nil {
+		p.ReservedUsernames = DefaultReservedUsernames()
+	}
+	if p.MinPasswordLength == 0 {
+		p.MinPasswordLength = DefaultPolicy.MinPasswordLength
+	}
+	if p.MaxPasswordBytes == 0 || p.MaxPasswordBytes > maxPasswordBytes {
+		p.MaxPasswordBytes = maxPasswordBytes
+	}
+	return p
+}
+
+// NewValidator returns a validator which checks the inputs by the policy.
+func (p Policy) NewValidator() *validator {
+	v := NewValidator()
+	v.policy = p.WithDefaults()
+	return v
+}
+
+// passwordCharClasses returns the number of the character classes in the password.
+func passwordCharClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+//
+// Names
+//
+
+// NameList is a set of names, compared case-insensitively.
+type NameList map[string]struct{}
+
+func NewNameList(names ...string) NameList {
+	l := NameList{}
+	for _, n := range names {
+		l[strings.ToLower(n)] = struct{}{}
+	}
+	return l
+}
+
+// ParseNameList reads a name per line, the empty lines and the lines starting with # are skipped.
+func ParseNameList(r io.Reader) (NameList, error) {
+	l, err := parseList(r)
+	return NameList(l), err
+}
+
+func (l NameList) Contains(name string) bool {
+	_, ok := l[strings.ToLower(name)]
+	return ok
+}
+
+//go:embed reserved_usernames.conf
+var reservedUsernamesConf string
+
+var reservedUsernames = &bundledList{text: reservedUsernamesConf}
+
+// DefaultReservedUsernames returns the bundled list of the reserved usernames,
+// e.g. the names of the routes, the system accounts and the support roles.
+func DefaultReservedUsernames() NameList {
+	return NameList(reservedUsernames.get())
+}
@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/apple"
+	"golang.org/x/oauth2"
+)
+
+// appleProvider is the apple provider with a generated client secret.
+// The secret expires after appleSecretTTL, so the provider is built again with a new secret before that,
+// and a long running process keeps working.
+//
+// The sessions of the apple provider only authorize with an *apple.Provider,
+// so they are wrapped to authorize with the current one.
+type appleProvider struct {
+	config Config
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	provider *apple.Provider
+	expiry   time.Time
+}
+
+func newAppleProvider(c Config, client *http.Client) (*appleProvider, error) {
+	ap := &appleProvider{config: c, client: client, now: time.Now}
+	if _, err := ap.current(); err != nil {
+		return nil, err
+	}
+	return ap, nil
+}
+
+// current returns the provider, it's built with a new secret once less than appleSecretRenewal is left.
+func (ap *appleProvider) current() (*apple.Provider, error) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	now := ap.now()
+	if ap.provider != nil && now.Before(ap.expiry.Add(-appleSecretRenewal)) {
+		return ap.provider, nil
+	}
+
+	c := ap.config
+	secret, err := appleSecret(c, now)
+	if err != nil {
+		return nil, err
+	}
+	c.ClientSecret = secret
+	p, err := newProvider(c, ap.client)
+	if err != nil {
+		return nil, err
+	}
+	p.SetName(ap.config.Name)
+
+	ap.provider, ap.expiry = p.(*apple.Provider), now.Add(appleSecretTTL)
+	return ap.provider, nil
+}
+
+// secret returns the current client secret.
+func (ap *appleProvider) secret() (string, error) {
+	p, err := ap.current()
+	if err != nil {
+		return "", err
+	}
+	return p.Secret(), nil
+}
+
+func (ap *appleProvider) Name() string {
+	return ap.config.Name
+}
+
+func (ap *appleProvider) SetName(name string) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	ap.config.Name = name
+	if ap.provider != nil {
+		ap.provider.SetName(name)
+	}
+}
+
+func (ap *appleProvider) BeginAuth(state string) (goth.Session, error) {
+	p, err := ap.current()
+	if err != nil {
+		return nil, err
+	}
+	s, err := p.BeginAuth(state)
+	if err != nil {
+		return nil, err
+	}
+	return &appleSession{Session: s, provider: ap}, nil
+}
+
+func (ap *appleProvider) UnmarshalSession(data string) (goth.Session, error) {
+	p, err := ap.current()
+	if err != nil {
+		return nil, err
+	}
+	s, err := p.UnmarshalSession(data)
+	if err != nil {
+		return nil, err
+	}
+	return &appleSession{Session: s, provider: ap}, nil
+}
+
+func (ap *appleProvider) FetchUser(session goth.Session) (goth.User, error) {
+	p, err := ap.current()
+	if err != nil {
+		return goth.User{}, err
+	}
+	if s, ok := session.(*appleSession); ok {
+		session = s.Session
+	}
+	return p.FetchUser(session)
+}
+
+func (ap *appleProvider) Debug(bool) {}
+
+func (ap *appleProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	p, err := ap.current()
+	if err != nil {
+		return nil, err
+	}
+	return p.RefreshToken(refreshToken)
+}
+
+func (ap *appleProvider) RefreshTokenAvailable() bool {
+	return true
+}
+
+// appleSession authorizes the apple session with the current provider.
+type appleSession struct {
+	goth.Session
+	provider *appleProvider
+}
+
+func (s *appleSession) Authorize(_ goth.Provider, params goth.Params) (string, error) {
+	p, err := s.provider.current()
+	if err != nil {
+		return "", err
+	}
+	return s.Session.Authorize(p, params)
+}
+
+// appleSecret signs the client secret with the private key of the apple developer account.
+func appleSecret(c Config, now time.Time) (string, error) {
+	if c.AppleTeamID == "" || c.AppleKeyID == "" || c.ApplePrivateKey == "" {
+		return "", fmt.Errorf("client secret or team id, key id and private key are required")
+	}
+
+	s, err := apple.MakeSecret(apple.SecretParams{
+		PKCS8PrivateKey: c.ApplePrivateKey,
+		TeamId:          c.AppleTeamID,
+		KeyId:           c.AppleKeyID,
+		ClientId:        c.ClientID,
+		Iat:             int(now.Unix()),
+		Exp:             int(now.Add(appleSecretTTL).Unix()),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *s, nil
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func newAppleTestConfig(t *testing.T) Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Config{
+		Name:            "apple",
+		Type:            TypeApple,
+		ClientID:        "com.example.app",
+		CallbackURL:     "https://auth.example.com/api/v1/auth/apple/callback",
+		AppleTeamID:     "TEAM",
+		AppleKeyID:      "KEY",
+		ApplePrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+}
+
+func TestAppleProviderRenewsSecret(t *testing.T) {
+	r, err := NewRegistry(newAppleTestConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := r.providers["apple"].(*appleProvider)
+
+	now := time.Now()
+	ap.now = func() time.Time { return now }
+
+	first, err := ap.secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := r.revokers["apple"].clientSecret()
+	if err != nil || secret != first {
+		t.Fatalf("revoker secret = %q, %v, want the provider's", secret, err)
+	}
+
+	// the secret is kept while it's valid for longer than the renewal period.
+	now = now.Add(appleSecretTTL - appleSecretRenewal - time.Hour)
+	if s, _ := ap.secret(); s != first {
+		t.Errorf("secret is renewed %s before it expires", appleSecretRenewal+time.Hour)
+	}
+
+	now = now.Add(2 * time.Hour)
+	renewed, err := ap.secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed == first {
+		t.Fatal("secret isn't renewed before it expires")
+	}
+	if secret, _ := r.revokers["apple"].clientSecret(); secret != renewed {
+		t.Errorf("revoker secret isn't renewed")
+	}
+	if ap.provider.Name() != "apple" {
+		t.Errorf("renewed provider name = %q", ap.provider.Name())
+	}
+}
+
+func TestAppleProviderSession(t *testing.T) {
+	r, err := NewRegistry(newAppleTestConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := r.providers["apple"].(*appleProvider)
+
+	sess, err := ap.BeginAuth("state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sess.(*appleSession); !ok {
+		t.Fatalf("BeginAuth() = %T, want *appleSession", sess)
+	}
+
+	// the marshaled session is wrapped again, so that it authorizes with the current provider.
+	sess, err = ap.UnmarshalSession(sess.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sess.(*appleSession); !ok {
+		t.Fatalf("UnmarshalSession() = %T, want *appleSession", sess)
+	}
+}
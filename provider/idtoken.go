@@ -0,0 +1,346 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aemdemir/auth"
+)
+
+// ID token issuers and key urls of the presets.
+const (
+	googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+	appleJWKSURL  = "https://appleid.apple.com/auth/keys"
+)
+
+var (
+	googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+	appleIssuers  = []string{"https://appleid.apple.com"}
+)
+
+// idTokenLeeway is the allowed clock skew between the provider and us.
+const idTokenLeeway = time.Minute
+
+// KeySource returns the keys which sign the ID tokens of a provider.
+type KeySource interface {
+	Keys(ctx context.Context) (auth.JWKS, error)
+}
+
+// refresher is implemented by the key sources which can refetch their keys,
+// the verifier refreshes them once when a token is signed with an unknown key.
+type refresher interface {
+	Refresh(ctx context.Context) (auth.JWKS, error)
+}
+
+// StaticKeySource is a fixed set of keys, e.g. the local keys of the tests.
+type StaticKeySource struct {
+	JWKS auth.JWKS
+}
+
+func (s StaticKeySource) Keys(ctx context.Context) (auth.JWKS, error) {
+	return s.JWKS, nil
+}
+
+// RemoteKeySource fetches the JWKS of a provider, and caches it for TTL.
+type RemoteKeySource struct {
+	URL    string
+	Client *http.Client
+	TTL    time.Duration
+	// MinRefresh limits how often an unknown key id can trigger a refetch.
+	MinRefresh time.Duration
+
+	mu      sync.Mutex
+	keys    auth.JWKS
+	fetched time.Time
+}
+
+func NewRemoteKeySource(url string) *RemoteKeySource {
+	return &RemoteKeySource{
+		URL:        url,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		TTL:        time.Hour,
+		MinRefresh: time.Minute,
+	}
+}
+
+// Keys returns the cached keys, they are fetched if they are older than TTL.
+func (s *RemoteKeySource) Keys(ctx context.Context) (auth.JWKS, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fetched.IsZero() && time.Since(s.fetched) < s.TTL {
+		return s.keys, nil
+	}
+	return s.fetch(ctx)
+}
+
+// Refresh refetches the keys, unless they are fetched in the last MinRefresh.
+func (s *RemoteKeySource) Refresh(ctx context.Context) (auth.JWKS, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fetched.IsZero() && time.Since(s.fetched) < s.MinRefresh {
+		return s.keys, nil
+	}
+	return s.fetch(ctx)
+}
+
+// fetch gets the keys, the stale keys are kept if the provider can't be reached.
+func (s *RemoteKeySource) fetch(ctx context.Context) (auth.JWKS, error) {
+	keys, err := s.get(ctx)
+	if err != nil {
+		if s.fetched.IsZero() {
+			return auth.JWKS{}, err
+		}
+		return s.keys, nil
+	}
+	s.keys, s.fetched = keys, time.Now()
+	return keys, nil
+}
+
+func (s *RemoteKeySource) get(ctx context.Context) (auth.JWKS, error) {
+	var keys auth.JWKS
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return keys, err
+	}
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return keys, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return keys, fmt.Errorf("fetch jwks: unexpected status %d", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil {
+		return keys, fmt.Errorf("fetch jwks: %w", err)
+	}
+	return keys, nil
+}
+
+// IDTokenConfig configures the verification of the ID tokens of a provider.
+type IDTokenConfig struct {
+	// Name is the provider of the linked accounts, it should match the name of the web sign in provider.
+	Name string
+	// Issuers are the accepted iss claims.
+	Issuers []string
+	// Audiences are the client ids of the apps, e.g. the bundle id of the ios app for apple.
+	Audiences []string
+	Keys      KeySource
+}
+
+// GoogleIDToken returns the config of the google ID tokens for the client ids.
+func GoogleIDToken(audiences ...string) IDTokenConfig {
+	return IDTokenConfig{
+		Name:      TypeGoogle,
+		Issuers:   googleIssuers,
+		Audiences: audiences,
+		Keys:      NewRemoteKeySource(googleJWKSURL),
+	}
+}
+
+// AppleIDToken returns the config of the apple ID tokens for the bundle and service ids.
+func AppleIDToken(audiences ...string) IDTokenConfig {
+	return IDTokenConfig{
+		Name:      TypeApple,
+		Issuers:   appleIssuers,
+		Audiences: audiences,
+		Keys:      NewRemoteKeySource(appleJWKSURL),
+	}
+}
+
+// Verifier verifies the ID tokens which the native apps get from the provider SDKs.
+type Verifier struct {
+	configs map[string]IDTokenConfig
+	now     func() time.Time
+}
+
+func NewVerifier(configs ...IDTokenConfig) (*Verifier, error) {
+	v := &Verifier{configs: make(map[string]IDTokenConfig, len(configs)), now: time.Now}
+	for _, c := range configs {
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("id token provider name is required")
+		case len(c.Issuers) == 0 || len(c.Audiences) == 0 || c.Keys == nil:
+			return nil, fmt.Errorf("id token provider %q: issuers, audiences and keys are required", c.Name)
+		}
+		if _, ok := v.configs[c.Name]; ok {
+			return nil, fmt.Errorf("id token provider %q is configured twice", c.Name)
+		}
+		v.configs[c.Name] = c
+	}
+	return v, nil
+}
+
+// VerifyIDToken verifies the signature, the issuer, the audience, the expiry and the nonce of the token.
+// The nonce is the value generated by the app, the token may contain it or its hex encoded sha256,
+// as apple recommends to pass the hash to the SDK.
+func (v *Verifier) VerifyIDToken(ctx context.Context, provider, idToken, nonce string) (*auth.ProviderIdentity, error) {
+	c, ok := v.configs[provider]
+	if !ok {
+		return nil, &auth.Error{Code: auth.ENOTFOUND, Message: fmt.Sprintf("unknown provider '%s'", provider)}
+	}
+
+	var raw json.RawMessage
+	_, err := auth.ParseJWT(idToken, v.keyFn(ctx, c.Keys), &raw)
+	if err != nil {
+		if auth.ErrorCode(err) == auth.EUNAUTHORIZED {
+			return nil, err
+		}
+		switch err {
+		case auth.ErrJWTMalformed, auth.ErrJWTAlgorithm, auth.ErrJWTSignature:
+			return nil, invalidIDToken(err.Error())
+		}
+		return nil, err
+	}
+	var claims idTokenClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, invalidIDToken("invalid claims")
+	}
+
+	now := v.now()
+	switch {
+	case !contains(c.Issuers, claims.Issuer):
+		return nil, invalidIDToken("unexpected issuer")
+	case !claims.Audience.any(c.Audiences):
+		return nil, invalidIDToken("unexpected audience")
+	case claims.Subject == "":
+		return nil, invalidIDToken("missing subject")
+	case now.After(time.Unix(claims.Expiry, 0).Add(idTokenLeeway)):
+		return nil, invalidIDToken("token is expired")
+	case claims.IssuedAt != 0 && now.Add(idTokenLeeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, invalidIDToken("token is issued in the future")
+	case !validNonce(claims.Nonce, nonce):
+		return nil, invalidIDToken("nonce doesn't match")
+	}
+
+	return &auth.ProviderIdentity{
+		Provider:      c.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		AvatarURL:     claims.Picture,
+		Locale:        claims.Locale,
+		RawClaims:     raw,
+	}, nil
+}
+
+// keyFn finds the key of the token, the keys are refreshed once if the key id is unknown,
+// since the providers rotate them.
+func (v *Verifier) keyFn(ctx context.Context, src KeySource) func(h auth.JWTHeader) (crypto.PublicKey, error) {
+	return func(h auth.JWTHeader) (crypto.PublicKey, error) {
+		keys, err := src.Keys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := keys.Key(h.Kid)
+		if !ok {
+			if r, isRefresher := src.(refresher); isRefresher {
+				keys, err = r.Refresh(ctx)
+				if err != nil {
+					return nil, err
+				}
+				k, ok = keys.Key(h.Kid)
+			}
+		}
+		if !ok {
+			return nil, invalidIDToken("unknown signing key")
+		}
+		return k.PublicKey()
+	}
+}
+
+//
+// Helpers
+//
+
+// idTokenClaims are the claims of the ID tokens of google and apple.
+type idTokenClaims struct {
+	Issuer        string    `json:"iss"`
+	Subject       string    `json:"sub"`
+	Audience      audience  `json:"aud"`
+	Expiry        int64     `json:"exp"`
+	IssuedAt      int64     `json:"iat"`
+	Nonce         string    `json:"nonce"`
+	Email         string    `json:"email"`
+	EmailVerified looseBool `json:"email_verified"`
+	Name          string    `json:"name"`
+	Picture       string    `json:"picture"`
+	Locale        string    `json:"locale"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a audience) any(allowed []string) bool {
+	for _, s := range a {
+		if contains(allowed, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// looseBool is a bool which may be encoded as a string, apple sends email_verified as "true".
+type looseBool bool
+
+func (b *looseBool) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*b = looseBool(t)
+	case string:
+		*b = t == "true"
+	}
+	return nil
+}
+
+func validNonce(claim, nonce string) bool {
+	if claim == "" || nonce == "" {
+		return false
+	}
+	h := sha256.Sum256([]byte(nonce))
+	return subtle.ConstantTimeCompare([]byte(claim), []byte(nonce)) == 1 ||
+		subtle.ConstantTimeCompare([]byte(claim), []byte(hex.EncodeToString(h[:]))) == 1
+}
+
+func invalidIDToken(reason string) error {
+	return &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid id token: " + reason}
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
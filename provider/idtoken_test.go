@@ -0,0 +1,195 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aemdemir/auth"
+)
+
+const (
+	testIssuer   = "https://accounts.example.com"
+	testAudience = "com.example.app"
+	testNonce    = "nonce-of-the-app"
+)
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	token, err := auth.SignJWT(key, kid, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key := newTestKey(t)
+	other := newTestKey(t)
+	now := time.Unix(1700000000, 0)
+
+	v, err := NewVerifier(IDTokenConfig{
+		Name:      "example",
+		Issuers:   []string{testIssuer},
+		Audiences: []string{testAudience},
+		Keys:      StaticKeySource{JWKS: auth.JWKS{Keys: []auth.JWK{auth.NewRSAJWK(&key.PublicKey, "k1")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	// claims returns valid claims with the given changes, a nil value deletes the claim.
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":            testIssuer,
+			"sub":            "12345",
+			"aud":            testAudience,
+			"exp":            now.Add(time.Hour).Unix(),
+			"iat":            now.Unix(),
+			"nonce":          testNonce,
+			"email":          "jane@example.com",
+			"email_verified": true,
+			"name":           "Jane",
+		}
+		for k, val := range changes {
+			if val == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = val
+		}
+		return c
+	}
+	hashed := sha256.Sum256([]byte(testNonce))
+
+	tests := []struct {
+		name     string
+		provider string
+		token    string
+		nonce    string
+		wantErr  string
+	}{
+		{"valid", "example", signTestToken(t, key, "k1", claims(nil)), testNonce, ""},
+		{"hashed nonce", "example", signTestToken(t, key, "k1", claims(map[string]any{"nonce": hex.EncodeToString(hashed[:])})), testNonce, ""},
+		{"audience array", "example", signTestToken(t, key, "k1", claims(map[string]any{"aud": []string{"other", testAudience}})), testNonce, ""},
+		{"expired within leeway", "example", signTestToken(t, key, "k1", claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()})), testNonce, ""},
+		{"unknown provider", "other", signTestToken(t, key, "k1", claims(nil)), testNonce, auth.ENOTFOUND},
+		{"malformed", "example", "not.a.token", testNonce, auth.EUNAUTHORIZED},
+		{"wrong signature", "example", signTestToken(t, other, "k1", claims(nil)), testNonce, auth.EUNAUTHORIZED},
+		{"unknown key", "example", signTestToken(t, key, "k2", claims(nil)), testNonce, auth.EUNAUTHORIZED},
+		{"wrong issuer", "example", signTestToken(t, key, "k1", claims(map[string]any{"iss": "https://evil.example.com"})), testNonce, auth.EUNAUTHORIZED},
+		{"wrong audience", "example", signTestToken(t, key, "k1", claims(map[string]any{"aud": "com.other.app"})), testNonce, auth.EUNAUTHORIZED},
+		{"no subject", "example", signTestToken(t, key, "k1", claims(map[string]any{"sub": nil})), testNonce, auth.EUNAUTHORIZED},
+		{"expired", "example", signTestToken(t, key, "k1", claims(map[string]any{"exp": now.Add(-2 * time.Minute).Unix()})), testNonce, auth.EUNAUTHORIZED},
+		{"issued in the future", "example", signTestToken(t, key, "k1", claims(map[string]any{"iat": now.Add(2 * time.Minute).Unix()})), testNonce, auth.EUNAUTHORIZED},
+		{"wrong nonce", "example", signTestToken(t, key, "k1", claims(nil)), "other-nonce", auth.EUNAUTHORIZED},
+		{"no nonce claim", "example", signTestToken(t, key, "k1", claims(map[string]any{"nonce": nil})), testNonce, auth.EUNAUTHORIZED},
+		{"no nonce", "example", signTestToken(t, key, "k1", claims(nil)), "", auth.EUNAUTHORIZED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := v.VerifyIDToken(context.Background(), tt.provider, tt.token, tt.nonce)
+			if tt.wantErr != "" {
+				if code := auth.ErrorCode(err); code != tt.wantErr {
+					t.Fatalf("VerifyIDToken() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyIDToken() error = %v", err)
+			}
+			if id.Provider != "example" || id.Subject != "12345" || id.Email != "jane@example.com" || !id.EmailVerified || id.Name != "Jane" {
+				t.Errorf("VerifyIDToken() = %+v", id)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenAppleClaims(t *testing.T) {
+	key := newTestKey(t)
+	v, err := NewVerifier(IDTokenConfig{
+		Name:      TypeApple,
+		Issuers:   appleIssuers,
+		Audiences: []string{testAudience},
+		Keys:      StaticKeySource{JWKS: auth.JWKS{Keys: []auth.JWK{auth.NewRSAJWK(&key.PublicKey, "k1")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// apple sends email_verified as a string.
+	token := signTestToken(t, key, "k1", map[string]any{
+		"iss":            appleIssuers[0],
+		"sub":            "001234.abcd",
+		"aud":            testAudience,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          testNonce,
+		"email":          "jane@privaterelay.appleid.com",
+		"email_verified": "true",
+	})
+	id, err := v.VerifyIDToken(context.Background(), TypeApple, token, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.EmailVerified {
+		t.Errorf("email_verified \"true\" is not verified")
+	}
+}
+
+func TestRemoteKeySourceRotation(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+
+	var jwks atomic.Value
+	jwks.Store(auth.JWKS{Keys: []auth.JWK{auth.NewRSAJWK(&oldKey.PublicKey, "old")}})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(jwks.Load())
+	}))
+	defer srv.Close()
+
+	src := NewRemoteKeySource(srv.URL)
+	src.MinRefresh = 0
+	v, err := NewVerifier(IDTokenConfig{Name: "example", Issuers: []string{testIssuer}, Audiences: []string{testAudience}, Keys: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(key *rsa.PrivateKey, kid string) error {
+		token := signTestToken(t, key, kid, map[string]any{
+			"iss": testIssuer, "sub": "1", "aud": testAudience, "exp": time.Now().Add(time.Hour).Unix(), "nonce": testNonce,
+		})
+		_, err := v.VerifyIDToken(context.Background(), "example", token, testNonce)
+		return err
+	}
+
+	if err := verify(oldKey, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(oldKey, "old"); err != nil || fetches.Load() != 1 {
+		t.Fatalf("cached keys are refetched: %d fetches, %v", fetches.Load(), err)
+	}
+
+	// the provider rotates the key, the unknown key id refreshes the cached keys once.
+	jwks.Store(auth.JWKS{Keys: []auth.JWK{auth.NewRSAJWK(&newKey.PublicKey, "new")}})
+	if err := verify(newKey, "new"); err != nil || fetches.Load() != 2 {
+		t.Fatalf("rotated key: %d fetches, %v", fetches.Load(), err)
+	}
+}
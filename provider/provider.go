@@ -0,0 +1,331 @@
+// Package provider configures the social sign in providers at runtime.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/apple"
+	"github.com/markbates/goth/providers/azureadv2"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/gitlab"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
+	"github.com/markbates/goth/providers/twitter"
+	"golang.org/x/oauth2"
+)
+
+// provider types.
+const (
+	TypeOIDC      = "oidc"
+	TypeGoogle    = "google"
+	TypeTwitter   = "twitter"
+	TypeGitHub    = "github"
+	TypeMicrosoft = "microsoft"
+	TypeApple     = "apple"
+	TypeGitLab    = "gitlab"
+)
+
+// Types lists the supported provider types.
+var Types = []string{TypeOIDC, TypeGoogle, TypeTwitter, TypeGitHub, TypeMicrosoft, TypeApple, TypeGitLab}
+
+// appleSecretTTL is the lifetime of the generated apple client secrets, apple allows at most 6 months.
+// The secrets are signed again once less than appleSecretRenewal is left.
+const (
+	appleSecretTTL     = 180 * 24 * time.Hour
+	appleSecretRenewal = 30 * 24 * time.Hour
+)
+
+// Config configures a provider.
+type Config struct {
+	// Name identifies the provider in the urls and in the linked accounts, e.g. "github" or "acme".
+	// It defaults to the type.
+	Name         string
+	Type         string
+	ClientID     string
+	ClientSecret string
+	CallbackURL  string
+	// Scopes overrides the default scopes of the provider.
+	Scopes []string
+
+	// DiscoveryURL is the url of the OpenID configuration of an oidc provider,
+	// e.g. "https://accounts.example.com/.well-known/openid-configuration".
+	DiscoveryURL string
+	// Tenant restricts the microsoft accounts, it's "common" by default.
+	Tenant string
+	// BaseURL is the url of a self-hosted gitlab.
+	BaseURL string
+	// RevocationURL is the RFC 7009 endpoint to revoke the tokens, google, apple and gitlab have defaults.
+	RevocationURL string
+
+	// The apple client secret is generated with these, if ClientSecret is empty.
+	AppleTeamID     string
+	AppleKeyID      string
+	ApplePrivateKey string
+}
+
+// Registry holds the configured providers.
+// It refreshes and revokes the provider tokens of the linked accounts.
+type Registry struct {
+	providers map[string]goth.Provider
+	revokers  map[string]revoker
+	client    *http.Client
+}
+
+// revoker revokes the tokens of a provider.
+type revoker struct {
+	url      string
+	clientID string
+	// clientSecret returns the current secret, the generated apple secrets are signed again before they expire.
+	clientSecret func() (string, error)
+}
+
+// NewRegistry builds the providers of the configs.
+// The oidc providers fetch their discovery documents, so it may take a while.
+func NewRegistry(configs ...Config) (*Registry, error) {
+	r := &Registry{
+		providers: make(map[string]goth.Provider, len(configs)),
+		revokers:  make(map[string]revoker),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, c := range configs {
+		if c.Name == "" {
+			c.Name = c.Type
+		}
+		if _, ok := r.providers[c.Name]; ok {
+			return nil, fmt.Errorf("provider %q is configured twice", c.Name)
+		}
+
+		var (
+			p      goth.Provider
+			secret = func() (string, error) { return c.ClientSecret, nil }
+			err    error
+		)
+		if c.Type == TypeApple && c.ClientSecret == "" {
+			var ap *appleProvider
+			ap, err = newAppleProvider(c, r.client)
+			p, secret = ap, ap.secret
+		} else {
+			p, err = newProvider(c, r.client)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", c.Name, err)
+		}
+		p.SetName(c.Name)
+		r.providers[c.Name] = p
+
+		if u := revocationURL(c); u != "" {
+			r.revokers[c.Name] = revoker{url: u, clientID: c.ClientID, clientSecret: secret}
+		}
+	}
+	return r, nil
+}
+
+// HasProvider returns true if the provider is configured.
+func (r *Registry) HasProvider(name string) bool {
+	_, ok := r.providers[name]
+	return ok
+}
+
+// Names returns the names of the configured providers, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Use replaces the providers of goth with the registry, so that gothic uses them.
+func (r *Registry) Use() {
+	goth.ClearProviders()
+	for _, p := range r.providers {
+		goth.UseProviders(p)
+	}
+}
+
+// RefreshToken exchanges the refresh token of an account for new tokens.
+//
+// The request isn't cancelled with ctx, since a provider which rotates the refresh tokens invalidates
+// the old one once it's used, and the new one would be lost. It's bounded by the timeout of the registry's
+// http client instead, which the providers use.
+func (r *Registry) RefreshToken(ctx context.Context, name, refreshToken string) (*auth.ProviderTokens, error) {
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not configured", name)
+	}
+	if !p.RefreshTokenAvailable() {
+		return nil, fmt.Errorf("provider %q doesn't support refresh tokens", name)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		t   *oauth2.Token
+		err error
+	)
+	if op, ok := p.(*openidConnect.Provider); ok {
+		// goth refreshes the oidc tokens with the default http client, which has no timeout.
+		t, err = r.refreshOIDCToken(op, refreshToken)
+	} else {
+		t, err = p.RefreshToken(refreshToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &auth.ProviderTokens{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry,
+	}, nil
+}
+
+// refreshOIDCToken refreshes the tokens at the token endpoint of the oidc provider with the registry's http client.
+func (r *Registry) refreshOIDCToken(p *openidConnect.Provider, refreshToken string) (*oauth2.Token, error) {
+	c := oauth2.Config{
+		ClientID:     p.ClientKey,
+		ClientSecret: p.Secret,
+		Endpoint:     oauth2.Endpoint{TokenURL: p.OpenIDConfig.TokenEndpoint},
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, r.client)
+	return c.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
+// RevokeToken revokes the tokens of an account, the refresh token if there is one,
+// which revokes its access tokens too. It does nothing if the provider has no revocation endpoint.
+func (r *Registry) RevokeToken(ctx context.Context, name string, tokens auth.ProviderTokens) error {
+	rv, ok := r.revokers[name]
+	if !ok {
+		return nil
+	}
+
+	secret, err := rv.clientSecret()
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	form := url.Values{"client_id": {rv.clientID}, "client_secret": {secret}}
+	if tokens.RefreshToken != "" {
+		form.Set("token", tokens.RefreshToken)
+		form.Set("token_type_hint", "refresh_token")
+	} else {
+		form.Set("token", tokens.AccessToken)
+		form.Set("token_type_hint", "access_token")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rv.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoke token: unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// newProvider builds the provider of the config, the providers which refresh the tokens use the client.
+func newProvider(c Config, client *http.Client) (goth.Provider, error) {
+	if c.ClientID == "" {
+		return nil, fmt.Errorf("client id is required")
+	}
+
+	switch c.Type {
+	case TypeOIDC:
+		if c.DiscoveryURL == "" {
+			return nil, fmt.Errorf("discovery url is required")
+		}
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "profile", "email"}
+		}
+		p, err := openidConnect.New(c.ClientID, c.ClientSecret, c.CallbackURL, c.DiscoveryURL, scopes...)
+		if err != nil {
+			return nil, err
+		}
+		p.HTTPClient = client
+		return p, nil
+
+	case TypeGoogle:
+		p := google.New(c.ClientID, c.ClientSecret, c.CallbackURL, c.Scopes...)
+		p.HTTPClient = client
+		return p, nil
+
+	case TypeTwitter:
+		return twitter.New(c.ClientID, c.ClientSecret, c.CallbackURL), nil
+
+	case TypeGitHub:
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"read:user", "user:email"}
+		}
+		return github.New(c.ClientID, c.ClientSecret, c.CallbackURL, scopes...), nil
+
+	case TypeMicrosoft:
+		opts := azureadv2.ProviderOptions{Tenant: azureadv2.TenantType(c.Tenant)}
+		for _, s := range c.Scopes {
+			opts.Scopes = append(opts.Scopes, azureadv2.ScopeType(s))
+		}
+		p := azureadv2.New(c.ClientID, c.ClientSecret, c.CallbackURL, opts)
+		p.HTTPClient = client
+		return p, nil
+
+	case TypeApple:
+		if c.ClientSecret == "" {
+			return nil, fmt.Errorf("client secret or team id, key id and private key are required")
+		}
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{apple.ScopeName, apple.ScopeEmail}
+		}
+		return apple.New(c.ClientID, c.ClientSecret, c.CallbackURL, client, scopes...), nil
+
+	case TypeGitLab:
+		p := gitlab.New(c.ClientID, c.ClientSecret, c.CallbackURL, c.Scopes...)
+		if c.BaseURL != "" {
+			base := strings.TrimSuffix(c.BaseURL, "/")
+			p = gitlab.NewCustomisedURL(c.ClientID, c.ClientSecret, c.CallbackURL,
+				base+"/oauth/authorize", base+"/oauth/token", base+"/api/v4/user", c.Scopes...)
+		}
+		p.HTTPClient = client
+		return p, nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider type %q, must be one of %s", c.Type, strings.Join(Types, ", "))
+	}
+}
+
+// revocationURL returns the revocation endpoint of the provider, if it has a known one.
+func revocationURL(c Config) string {
+	if c.RevocationURL != "" {
+		return c.RevocationURL
+	}
+
+	switch c.Type {
+	case TypeGoogle:
+		return "https://oauth2.googleapis.com/revoke"
+	case TypeApple:
+		return "https://appleid.apple.com/auth/revoke"
+	case TypeGitLab:
+		if c.BaseURL == "" {
+			return "https://gitlab.com/oauth/revoke"
+		}
+		return strings.TrimSuffix(c.BaseURL, "/") + "/oauth/revoke"
+	default:
+		return ""
+	}
+}
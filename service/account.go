@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 
@@ -14,10 +15,18 @@ import (
 //
 
 type dbAccount struct {
-	UserID         int       `db:"user_id"`
-	ProviderName   string    `db:"provider_name"`
-	ProviderUserID string    `db:"provider_user_id"`
-	Created        time.Time `db:"created"`
+	UserID         int             `db:"user_id"`
+	ProviderName   string          `db:"provider_name"`
+	ProviderUserID string          `db:"provider_user_id"`
+	Email          auth.NullString `db:"email"`
+	Name           auth.NullString `db:"name"`
+	AvatarURL      auth.NullString `db:"avatar_url"`
+	Locale         auth.NullString `db:"locale"`
+	RawClaims      []byte          `db:"raw_claims"`
+	// EncryptedTokens are the provider tokens, encrypted with the ProviderTokensConfig keys.
+	EncryptedTokens []byte    `db:"encrypted_tokens"`
+	Created         time.Time `db:"created"`
+	Updated         time.Time `db:"updated"`
 }
 
 func getAccountsByUser(ctx context.Context, dbx DBTX, id int) ([]dbAccount, error) {
@@ -26,7 +35,14 @@ func getAccountsByUser(ctx context.Context, dbx DBTX, id int) ([]dbAccount, erro
 		user_id, 
 		provider_name, 
 		provider_user_id, 
-		created 
+		email,
+		name,
+		avatar_url,
+		locale,
+		raw_claims,
+		encrypted_tokens,
+		created,
+		updated
 	FROM  user_account 
 	WHERE user_id = $1
 	`
@@ -37,10 +53,45 @@ func getAccountsByUser(ctx context.Context, dbx DBTX, id int) ([]dbAccount, erro
 	return a, err
 }
 
+// getAccountForUpdate locks the account of the user, so that its tokens are refreshed once.
+func getAccountForUpdate(ctx context.Context, dbx DBTX, uid int, providerName string) (*dbAccount, error) {
+	query := `
+	SELECT
+		user_id,
+		provider_name,
+		provider_user_id,
+		email,
+		name,
+		avatar_url,
+		locale,
+		raw_claims,
+		encrypted_tokens,
+		created,
+		updated
+	FROM  user_account
+	WHERE user_id = $1 AND provider_name = $2
+	FOR UPDATE
+	`
+
+	a := dbAccount{}
+
+	err := dbx.GetContext(ctx, &a, query, uid, providerName)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching account found"}
+		default:
+			return nil, err
+		}
+	}
+	return &a, nil
+}
+
 type dbAccountInsert struct {
 	UserID         int
 	ProviderName   string
 	ProviderUserID string
+	Profile        auth.AccountProfile
 }
 
 func insertAccount(ctx context.Context, dbx DBTX, in dbAccountInsert) error {
@@ -49,15 +100,25 @@ func insertAccount(ctx context.Context, dbx DBTX, in dbAccountInsert) error {
 	(
 		user_id,
 		provider_name,
-		provider_user_id
+		provider_user_id,
+		email,
+		name,
+		avatar_url,
+		locale,
+		raw_claims
 	)
-	VALUES (:user_id, :provider_name, :provider_user_id)
+	VALUES (:user_id, :provider_name, :provider_user_id, :email, :name, :avatar_url, :locale, :raw_claims)
 	`
 
 	a := dbAccount{
 		UserID:         in.UserID,
 		ProviderName:   in.ProviderName,
 		ProviderUserID: in.ProviderUserID,
+		Email:          in.Profile.Email,
+		Name:           in.Profile.Name,
+		AvatarURL:      in.Profile.AvatarURL,
+		Locale:         in.Profile.Locale,
+		RawClaims:      rawClaims(in.Profile.RawClaims),
 	}
 
 	_, err := dbx.NamedExecContext(ctx, query, a)
@@ -73,6 +134,61 @@ func insertAccount(ctx context.Context, dbx DBTX, in dbAccountInsert) error {
 	return nil
 }
 
+type dbAccountProfileUpdate struct {
+	ProviderName   string
+	ProviderUserID string
+	Profile        auth.AccountProfile
+}
+
+func updateAccountProfile(ctx context.Context, dbx DBTX, up dbAccountProfileUpdate) error {
+	query := `
+	UPDATE user_account
+	SET
+		email      = :email,
+		name       = :name,
+		avatar_url = :avatar_url,
+		locale     = :locale,
+		raw_claims = :raw_claims
+	WHERE
+		provider_name = :provider_name AND provider_user_id = :provider_user_id
+	`
+
+	a := dbAccount{
+		ProviderName:   up.ProviderName,
+		ProviderUserID: up.ProviderUserID,
+		Email:          up.Profile.Email,
+		Name:           up.Profile.Name,
+		AvatarURL:      up.Profile.AvatarURL,
+		Locale:         up.Profile.Locale,
+		RawClaims:      rawClaims(up.Profile.RawClaims),
+	}
+
+	_, err := dbx.NamedExecContext(ctx, query, a)
+	return err
+}
+
+func updateAccountTokens(ctx context.Context, dbx DBTX, uid int, providerName string, encryptedTokens []byte) error {
+	query := `UPDATE user_account SET encrypted_tokens = $3 WHERE user_id = $1 AND provider_name = $2`
+
+	_, err := dbx.ExecContext(ctx, query, uid, providerName, encryptedTokens)
+	return err
+}
+
+func deleteAccount(ctx context.Context, dbx DBTX, uid int, providerName string) error {
+	query := `DELETE FROM user_account WHERE user_id = $1 AND provider_name = $2`
+
+	res, err := dbx.ExecContext(ctx, query, uid, providerName)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &auth.Error{Code: auth.ENOTFOUND, Message: "no matching account found"}
+	}
+	return nil
+}
+
 //
 // conversion
 //
@@ -82,7 +198,15 @@ func toAuthAccount(e *dbAccount) *auth.Account {
 		UserID:         e.UserID,
 		ProviderName:   e.ProviderName,
 		ProviderUserID: e.ProviderUserID,
-		Created:        e.Created,
+		Profile: auth.AccountProfile{
+			Email:     e.Email,
+			Name:      e.Name,
+			AvatarURL: e.AvatarURL,
+			Locale:    e.Locale,
+			RawClaims: rawClaims(e.RawClaims),
+		},
+		Created: e.Created,
+		Updated: e.Updated,
 	}
 }
 
@@ -93,3 +217,15 @@ func toAuthAccounts(ss []dbAccount) []auth.Account {
 	}
 	return rr
 }
+
+//
+// Helpers
+//
+
+// rawClaims defaults the raw claims to an empty json object.
+func rawClaims(b []byte) []byte {
+	if len(b) == 0 || string(b) == "null" {
+		return []byte("{}")
+	}
+	return b
+}
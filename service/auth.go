@@ -2,6 +2,10 @@ package service
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/aemdemir/auth"
 	"github.com/rs/zerolog"
@@ -10,21 +14,111 @@ import (
 type authService struct {
 	db     *DB
 	logger zerolog.Logger
-	mailer Mailer
+	config Config
 }
 
-func NewService(db *DB, logger zerolog.Logger, mailer Mailer) auth.Service {
+// Config configures the optional features of the service.
+type Config struct {
+	OIDC OIDCConfig
+	// Providers validates the provider names of the linked accounts.
+	// Any name is accepted if it's nil.
+	Providers auth.ProviderRegistry
+	// TrustedEmailProviders are the providers whose email_verified claim is trusted.
+	// Their accounts are linked to the user with the same verified primary email right away,
+	// the accounts of the other providers are pending until the user confirms them.
+	TrustedEmailProviders []string
+	// ProviderTokens keeps the OAuth tokens of the linked accounts, to call the provider APIs.
+	ProviderTokens ProviderTokensConfig
+	// TOTPIssuer names the service in the authenticator apps, it's "auth" by default.
+	TOTPIssuer string
+	// TOTPKeys are the 32 byte AES-256 keys which encrypt the authenticator secrets at rest,
+	// the authenticators can't be set up if it's empty. The first key encrypts, all of them decrypt.
+	TOTPKeys [][]byte
+	// EmailCanonicalizer strips the alias parts of the emails, the canonical forms are stored with the emails.
+	// It's auth.DefaultCanonicalizer by default.
+	EmailCanonicalizer auth.EmailCanonicalizer
+	// RejectEmailAliases rejects the emails of Signup and AddEmail whose canonical form belongs to another user,
+	// e.g. "name+1@gmail.com" if "name@gmail.com" is registered.
+	RejectEmailAliases bool
+	// DisposableDomains rejects the new emails of the listed domains, unless an admin allows the domain.
+	// auth.DisposableDomains() is the bundled list, the check is disabled if it's nil.
+	DisposableDomains auth.DomainList
+	// EmailDomainAllowListOnly only accepts the new emails of the domains allowed by an admin.
+	EmailDomainAllowListOnly bool
+	// MinPasswordScore rejects the new passwords scored below it, the scores are from 0 to 4.
+	// It's auth.DefaultMinPasswordScore if it's nil, and 0 accepts any password.
+	MinPasswordScore *int
+	// BreachedPasswords rejects the new passwords which appeared in a data breach, e.g. an auth.BreachFile
+	// or an auth.BloomFilter built by cmd/breachfilter. The check is disabled if it's nil.
+	BreachedPasswords auth.BreachedPasswords
+	// PasswordHistory rejects the new passwords which are one of the last PasswordHistory passwords of the user,
+	// the current one included. ResetPassword and UpdatePassword keep the history, it's disabled if it's 0 or negative.
+	// Each password is a bcrypt comparison of about 250ms at cost 12, so it's capped at MaxPasswordHistory.
+	PasswordHistory int
+	// Policy is the rules of the usernames and passwords, the limits it doesn't set are the ones of auth.DefaultPolicy.
+	Policy auth.Policy
+}
+
+// OIDCConfig configures the OpenID Connect provider.
+type OIDCConfig struct {
+	// Issuer is the public base url of the provider, the endpoints are relative to it.
+	Issuer string
+	// SigningKey signs the ID tokens, its public key is served with KeyID at the jwks endpoint.
+	SigningKey *rsa.PrivateKey
+	KeyID      string
+	IDTokenTTL time.Duration
+}
+
+// signinCodePayload is stored with the signin codes.
+type signinCodePayload struct {
+	Action        string `json:"action"`
+	CodeChallenge string `json:"code_challenge,omitempty"`
+}
+
+// linkPayload is stored with the tokens of a pending account link.
+type linkPayload struct {
+	ProviderName   string              `json:"provider_name"`
+	ProviderUserID string              `json:"provider_user_id"`
+	Profile        auth.AccountProfile `json:"profile"`
+}
+
+// NewService returns the auth service.
+// Emails are written to the outbox, and they are sent by the OutboxWorker.
+func NewService(db *DB, logger zerolog.Logger, config Config) auth.Service {
+	if config.OIDC.IDTokenTTL == 0 {
+		config.OIDC.IDTokenTTL = time.Hour
+	}
+	if config.TOTPIssuer == "" {
+		config.TOTPIssuer = "auth"
+	}
+	if config.EmailCanonicalizer == nil {
+		config.EmailCanonicalizer = auth.DefaultCanonicalizer
+	}
+	config.Policy = config.Policy.WithDefaults()
+	if config.PasswordHistory < 0 {
+		config.PasswordHistory = 0
+	}
+	if config.PasswordHistory > MaxPasswordHistory {
+		logger.Warn().Int("password_history", config.PasswordHistory).Msgf("password history is capped at %d", MaxPasswordHistory)
+		config.PasswordHistory = MaxPasswordHistory
+	}
+	if config.MinPasswordScore == nil {
+		score := auth.DefaultMinPasswordScore
+		config.MinPasswordScore = &score
+	}
 	return &authService{
 		db:     db,
 		logger: logger,
-		mailer: mailer,
+		config: config,
 	}
 }
 
 func (s *authService) Signup(ctx context.Context, signup auth.SignupInput) error {
-	v := auth.NewValidator()
+	signup = signup.Normalize()
+
+	v := s.config.Policy.NewValidator()
 	if signup.Validate(v); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -33,6 +127,20 @@ func (s *authService) Signup(ctx context.Context, signup auth.SignupInput) error
 	}
 	defer tx.Rollback()
 
+	err = s.checkEmailDomain(ctx, tx, signup.Email)
+	if err != nil {
+		return err
+	}
+	canonical := s.config.EmailCanonicalizer.Canonicalize(signup.Email)
+	err = s.checkEmailAlias(ctx, tx, signup.Email, canonical, 0)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkPassword(signup.Password, signup.Username, signup.Name, signup.Email)
+	if err != nil {
+		return err
+	}
 	ph, err := signup.HashPassword()
 	if err != nil {
 		return err
@@ -41,20 +149,27 @@ func (s *authService) Signup(ctx context.Context, signup auth.SignupInput) error
 		Username:     signup.Username,
 		Name:         auth.NewNullString(signup.Name),
 		PasswordHash: ph,
+		Locale:       auth.NewNullString(signup.Locale),
 	})
 	if err != nil {
 		return err
 	}
 
 	err = insertEmail(ctx, tx, dbEmailInsert{
-		UserID:  uid,
-		Address: signup.Email,
-		Primary: signup.IsPrimaryEmail(),
+		UserID:    uid,
+		Address:   signup.Email,
+		Primary:   signup.IsPrimaryEmail(),
+		Canonical: canonical,
 	})
 	if err != nil {
 		return err
 	}
 
+	err = enqueueUserEvent(ctx, tx, auth.EventUserCreated, uid, auth.WebhookEventData{Email: &signup.Email})
+	if err != nil {
+		return err
+	}
+
 	tkn, err := auth.TokenEmailVerification.New(uid, signup.Email)
 	if err != nil {
 		return err
@@ -70,33 +185,21 @@ func (s *authService) Signup(ctx context.Context, signup auth.SignupInput) error
 		return err
 	}
 
-	background(s.logger, func() {
-		err := s.mailer.SendVerificationEmail(signup.Email, tkn.Text)
-		if err != nil {
-			s.logger.
-				Err(err).
-				Int("user_id", uid).
-				Str("recipient", signup.Email).
-				Msg("failed to send verification email")
-		}
-	})
+	err = enqueueEmail(ctx, tx, emailVerification, signup.Email, outboxData{Token: tkn.Text, Name: signup.Name, Locale: signup.Locale})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
 func (s *authService) Signin(ctx context.Context, signin auth.SigninInput) (*auth.UserSignin, error) {
-	v := auth.NewValidator()
+	v := s.config.Policy.NewValidator()
 	if signin.Validate(v); !v.Valid() {
-		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
-	du, err := getUserByPrimaryEmail(ctx, s.db, signin.Email)
-	if err != nil {
-		if auth.ErrorCode(err) != auth.ENOTFOUND {
-			return nil, err
-		}
-		return nil, &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid authentication credentials"}
-	}
-	de, err := getEmail(ctx, s.db, signin.Email)
+	du, de, err := getUserBySignin(ctx, s.db, signin)
 	if err != nil {
 		if auth.ErrorCode(err) != auth.ENOTFOUND {
 			return nil, err
@@ -117,7 +220,7 @@ func (s *authService) Signin(ctx context.Context, signin auth.SigninInput) (*aut
 	if !user.Active {
 		return nil, &auth.Error{Code: auth.EFORBIDDEN, Message: "this user is deactivated"}
 	}
-	if !de.Verified {
+	if de != nil && !de.Verified {
 		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "this email address has not been verified yet"}
 	}
 
@@ -136,19 +239,104 @@ func (s *authService) Signin(ctx context.Context, signin auth.SigninInput) (*aut
 		return nil, err
 	}
 
-	return &auth.UserSignin{
+	signed := &auth.UserSignin{
 		UserEmail: auth.UserEmail{
-			User:  *user,
-			Email: *toAuthEmail(de),
+			User: *user,
 		},
 		Token: tkn.Text,
-	}, nil
+	}
+	if de != nil {
+		signed.Email = *toAuthEmail(de)
+	}
+	return signed, nil
 }
 
 func (s *authService) SigninSocial(ctx context.Context, signin auth.SigninSocialInput) (*auth.UserSigninSocial, error) {
-	v := auth.NewValidator()
-	if signin.Validate(v); !v.Valid() {
-		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+	signin = signin.Normalize()
+
+	v := s.config.Policy.NewValidator()
+	signin.Validate(v)
+	if auth.ValidateProvider(v, s.config.Providers, signin.Account.ProviderName); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, link, err := s.signinSocial(ctx, tx, signin)
+	if err != nil {
+		return nil, err
+	}
+	if link != "" {
+		return nil, pendingLinkError(link, tx.Commit())
+	}
+
+	tkn, err := auth.TokenAuth.New(user.ID, "")
+	if err != nil {
+		return nil, err
+	}
+	err = insertToken(ctx, tx, dbTokenInsert{
+		UserID:  tkn.UserID,
+		Hash:    tkn.HashToken(),
+		Scope:   tkn.Scope,
+		Expiry:  tkn.Expiry,
+		Payload: tkn.Payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.UserSigninSocial{
+		User:  *user,
+		Token: tkn.Text,
+	}, tx.Commit()
+}
+
+// SigninSocialCode signs in the user like SigninSocial, but it returns a short lived, single use code
+// instead of the auth token, so that the token never appears in a redirect url.
+// If codeChallenge is given, the code can only be exchanged with its S256 verifier.
+func (s *authService) SigninSocialCode(ctx context.Context, signin auth.SigninSocialInput, codeChallenge string) (string, error) {
+	signin = signin.Normalize()
+
+	v := s.config.Policy.NewValidator()
+	signin.Validate(v)
+	if auth.ValidateProvider(v, s.config.Providers, signin.Account.ProviderName); !v.Valid() {
+		return "", &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	user, link, err := s.signinSocial(ctx, tx, signin)
+	if err != nil {
+		return "", err
+	}
+	if link != "" {
+		return "", pendingLinkError(link, tx.Commit())
+	}
+
+	code, err := issueSigninCode(ctx, tx, user.ID, auth.CodeActionSignin, codeChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	return code, tx.Commit()
+}
+
+// ExchangeSigninCode exchanges a code of SigninSocialCode, PasswordSetupCode or ProviderConfirmationCode
+// for the token of its action.
+func (s *authService) ExchangeSigninCode(ctx context.Context, exchange auth.SigninCodeInput) (*auth.SigninCodeExchange, error) {
+	meta := auth.TokenSigninCode
+
+	v := s.config.Policy.NewValidator()
+	if exchange.Validate(v, meta); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -157,36 +345,156 @@ func (s *authService) SigninSocial(ctx context.Context, signin auth.SigninSocial
 	}
 	defer tx.Rollback()
 
-	var user *auth.User
-	if du, err := getUserByAccount(ctx, tx, signin.Account.ProviderName, signin.Account.ProviderUserID); err != nil {
+	// codes are single use, they are consumed even if the verifier doesn't match.
+	dt, err := consumeToken(ctx, s.db, exchange.Code.HashToken(), meta.Scope)
+	if err != nil {
 		if auth.ErrorCode(err) != auth.ENOTFOUND {
 			return nil, err
 		}
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	if dt.Revoked || dt.Expiry.Before(time.Now()) {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	p := signinCodePayload{}
+	if err := json.Unmarshal([]byte(dt.Payload.String), &p); err != nil {
+		return nil, err
+	}
+	if p.CodeChallenge != "" && !verifyCodeChallenge(p.CodeChallenge, exchange.CodeVerifier) {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code verifier"}
+	}
 
-		du, err := getUserByPrimaryEmail(ctx, tx, signin.Email.String)
-		if err != nil {
-			if auth.ErrorCode(err) != auth.ENOTFOUND {
-				return nil, err
-			}
+	du, err := getUser(ctx, tx, dt.UserID)
+	if err != nil {
+		return nil, err
+	}
+	user := toAuthUser(du)
+	if !user.Active {
+		return nil, &auth.Error{Code: auth.EFORBIDDEN, Message: "this user is deactivated"}
+	}
 
-			id, err := createOAuthUser(ctx, tx, signin)
-			if err != nil {
-				return nil, err
-			}
-			du, err := getUser(ctx, tx, id)
-			if err != nil {
-				return nil, err
-			}
-			user = toAuthUser(du)
-		} else {
-			err := linkUserAccount(ctx, tx, du, signin.Account)
-			if err != nil {
-				return nil, err
-			}
-			user = toAuthUser(du)
+	var tkn string
+	switch p.Action {
+	case auth.CodeActionSignin:
+		t, err := auth.TokenAuth.New(user.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		err = insertToken(ctx, tx, dbTokenInsert{
+			UserID:  t.UserID,
+			Hash:    t.HashToken(),
+			Scope:   t.Scope,
+			Expiry:  t.Expiry,
+			Payload: t.Payload,
+		})
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		user = toAuthUser(du)
+		tkn = t.Text
+	case auth.CodeActionPassword:
+		if len(du.PasswordHash) > 0 {
+			return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "password has already been set"}
+		}
+		tkn, err = s.newPasswordSetupToken(ctx, tx, du.ID)
+		if err != nil {
+			return nil, err
+		}
+	case auth.CodeActionConfirm:
+		tkn, err = newConfirmationToken(ctx, tx, du.ID)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown signin code action %q", p.Action)
+	}
+
+	return &auth.SigninCodeExchange{
+		Action: p.Action,
+		User:   *user,
+		Token:  tkn,
+	}, tx.Commit()
+}
+
+func (s *authService) LinkUserAccount(ctx context.Context, link auth.LinkUserAccountInput) error {
+	meta := auth.TokenConfirmation
+
+	v := s.config.Policy.NewValidator()
+	link.Validate(v, meta)
+	if auth.ValidateProvider(v, s.config.Providers, link.Account.ProviderName); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUserByConfirmation(ctx, tx, link.Token, link.UserID)
+	if err != nil {
+		return err
+	}
+	err = deleteToken(ctx, tx, link.Token.HashToken())
+	if err != nil {
+		return err
+	}
+
+	err = linkUserAccount(ctx, tx, du, link.Account)
+	if err != nil {
+		return err
+	}
+	err = s.saveProviderTokens(ctx, tx, du.ID, link.Account)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConfirmAccountLink links the account of a pending link, after the user proves its ownership with the password.
+// The user is signed in like SigninSocial.
+func (s *authService) ConfirmAccountLink(ctx context.Context, confirm auth.ConfirmAccountLinkInput) (*auth.UserSigninSocial, error) {
+	meta := auth.TokenAccountLink
+
+	v := s.config.Policy.NewValidator()
+	if confirm.Validate(v, meta); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	du, account, err := getPendingLink(ctx, tx, confirm.Token.HashToken(), meta.Scope)
+	if err != nil {
+		return nil, err
+	}
+	user := toAuthUser(du)
+	ok, err := user.MatchPassword(confirm.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid authentication credentials"}
+	}
+	if !user.Active {
+		return nil, &auth.Error{Code: auth.EFORBIDDEN, Message: "this user is deactivated"}
+	}
+	// the password of a user with an unverified email may belong to someone
+	// who signed up with the email of another person, only the email proves the ownership then.
+	de, err := getPrimaryEmail(ctx, tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !de.Verified {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "this email address has not been verified yet"}
+	}
+
+	err = completePendingLink(ctx, tx, du, account)
+	if err != nil {
+		return nil, err
 	}
 
 	tkn, err := auth.TokenAuth.New(user.ID, "")
@@ -210,12 +518,14 @@ func (s *authService) SigninSocial(ctx context.Context, signin auth.SigninSocial
 	}, tx.Commit()
 }
 
-func (s *authService) LinkUserAccount(ctx context.Context, link auth.LinkUserAccountInput) error {
-	meta := auth.TokenConfirmation
+// VerifyAccountLink links the account of a pending link with the code emailed to the user.
+// The user isn't signed in, since the code may be opened in another browser.
+func (s *authService) VerifyAccountLink(ctx context.Context, token auth.TokenInput) error {
+	meta := auth.TokenAccountLinkVerification
 
-	v := auth.NewValidator()
-	if link.Validate(v, meta); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+	v := s.config.Policy.NewValidator()
+	if token.Validate(v, meta); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -224,20 +534,48 @@ func (s *authService) LinkUserAccount(ctx context.Context, link auth.LinkUserAcc
 	}
 	defer tx.Rollback()
 
-	hash := link.Token.HashToken()
-	du, err := getUserByValidToken(ctx, tx, hash, meta.Scope)
+	du, account, err := getPendingLink(ctx, tx, token.HashToken(), meta.Scope)
 	if err != nil {
-		if auth.ErrorCode(err) != auth.ENOTFOUND {
-			return err
-		}
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+		return err
 	}
-	err = deleteToken(ctx, tx, hash)
+
+	// the code proves the ownership of the primary email. if it's unverified, the password and
+	// the sessions may belong to someone who signed up with the email of another person,
+	// and they must not get the linked account. so verify the email, and drop them.
+	de, err := getPrimaryEmail(ctx, tx, du.ID)
 	if err != nil {
 		return err
 	}
+	if !de.Verified {
+		err = updateEmail(ctx, tx, dbEmailUpdate{
+			Address:  de.Address,
+			Primary:  de.Primary,
+			Verified: true,
+		})
+		if err != nil {
+			return err
+		}
+		err = enqueueUserEvent(ctx, tx, auth.EventUserEmailVerified, du.ID, auth.WebhookEventData{Email: &de.Address})
+		if err != nil {
+			return err
+		}
+		err = updateUser(ctx, tx, dbUserUpdate{
+			ID:           du.ID,
+			Username:     du.Username,
+			Version:      du.Version,
+			PasswordHash: nil,
+			Locale:       du.Locale,
+		})
+		if err != nil {
+			return err
+		}
+		err = deleteTokensByUser(ctx, tx, du.ID)
+		if err != nil {
+			return err
+		}
+	}
 
-	err = linkUserAccount(ctx, tx, du, link.Account)
+	err = completePendingLink(ctx, tx, du, account)
 	if err != nil {
 		return err
 	}
@@ -246,9 +584,11 @@ func (s *authService) LinkUserAccount(ctx context.Context, link auth.LinkUserAcc
 }
 
 func (s *authService) SendVerificationEmail(ctx context.Context, address string) error {
-	v := auth.NewValidator()
+	address = auth.NormalizeEmail(address)
+
+	v := s.config.Policy.NewValidator()
 	if auth.ValidateEmail(v, address); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	de, err := getEmail(ctx, s.db, address)
@@ -259,11 +599,22 @@ func (s *authService) SendVerificationEmail(ctx context.Context, address string)
 		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "email has already been verified"}
 	}
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUser(ctx, tx, de.UserID)
+	if err != nil {
+		return err
+	}
+
 	tkn, err := auth.TokenEmailVerification.New(de.UserID, de.Address)
 	if err != nil {
 		return err
 	}
-	err = insertToken(ctx, s.db, dbTokenInsert{
+	err = insertToken(ctx, tx, dbTokenInsert{
 		UserID:  tkn.UserID,
 		Hash:    tkn.HashToken(),
 		Scope:   tkn.Scope,
@@ -274,26 +625,20 @@ func (s *authService) SendVerificationEmail(ctx context.Context, address string)
 		return err
 	}
 
-	background(s.logger, func() {
-		err := s.mailer.SendVerificationEmail(de.Address, tkn.Text)
-		if err != nil {
-			s.logger.
-				Err(err).
-				Int("user_id", de.UserID).
-				Str("recipient", de.Address).
-				Msg("failed to send verification email")
-		}
-	})
+	err = enqueueEmail(ctx, tx, emailVerification, de.Address, outboxData{Token: tkn.Text, Name: du.Name.String, Locale: du.Locale.String})
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (s *authService) VerifyEmail(ctx context.Context, token auth.TokenInput) error {
 	meta := auth.TokenEmailVerification
 
-	v := auth.NewValidator()
+	v := s.config.Policy.NewValidator()
 	if token.Validate(v, meta); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -319,6 +664,11 @@ func (s *authService) VerifyEmail(ctx context.Context, token auth.TokenInput) er
 		return err
 	}
 
+	err = enqueueUserEvent(ctx, tx, auth.EventUserEmailVerified, de.UserID, auth.WebhookEventData{Email: &de.Address})
+	if err != nil {
+		return err
+	}
+
 	err = deleteTokensByUserAndScope(ctx, tx, de.UserID, meta.Scope)
 	if err != nil {
 		return err
@@ -328,9 +678,11 @@ func (s *authService) VerifyEmail(ctx context.Context, token auth.TokenInput) er
 }
 
 func (s *authService) SendPasswordResetEmail(ctx context.Context, address string) error {
-	v := auth.NewValidator()
+	address = auth.NormalizeEmail(address)
+
+	v := s.config.Policy.NewValidator()
 	if auth.ValidateEmail(v, address); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	de, err := getEmail(ctx, s.db, address)
@@ -344,12 +696,23 @@ func (s *authService) SendPasswordResetEmail(ctx context.Context, address string
 		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "email address has not been verified yet"}
 	}
 
-	tkn, err := auth.TokenPasswordReset.New(de.UserID, "")
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	err = insertToken(ctx, s.db, dbTokenInsert{
-		UserID:  tkn.UserID,
+	defer tx.Rollback()
+
+	du, err := getUser(ctx, tx, de.UserID)
+	if err != nil {
+		return err
+	}
+
+	tkn, err := auth.TokenPasswordReset.New(de.UserID, "")
+	if err != nil {
+		return err
+	}
+	err = insertToken(ctx, tx, dbTokenInsert{
+		UserID:  tkn.UserID,
 		Hash:    tkn.HashToken(),
 		Scope:   tkn.Scope,
 		Expiry:  tkn.Expiry,
@@ -359,26 +722,20 @@ func (s *authService) SendPasswordResetEmail(ctx context.Context, address string
 		return err
 	}
 
-	background(s.logger, func() {
-		err := s.mailer.SendPasswordResetEmail(de.Address, tkn.Text)
-		if err != nil {
-			s.logger.
-				Err(err).
-				Int("user_id", de.UserID).
-				Str("recipient", de.Address).
-				Msg("failed to send password reset email")
-		}
-	})
+	err = enqueueEmail(ctx, tx, emailPasswordReset, de.Address, outboxData{Token: tkn.Text, Name: du.Name.String, Locale: du.Locale.String})
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (s *authService) ResetPassword(ctx context.Context, reset auth.ResetPasswordInput) error {
 	meta := auth.TokenPasswordReset
 
-	v := auth.NewValidator()
+	v := s.config.Policy.NewValidator()
 	if reset.Validate(v, meta); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -395,6 +752,10 @@ func (s *authService) ResetPassword(ctx context.Context, reset auth.ResetPasswor
 		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
 	}
 
+	err = s.checkUserPassword(ctx, tx, du, reset.Password)
+	if err != nil {
+		return err
+	}
 	ph, err := reset.HashPassword()
 	if err != nil {
 		return err
@@ -404,77 +765,356 @@ func (s *authService) ResetPassword(ctx context.Context, reset auth.ResetPasswor
 		Username:     du.Username,
 		Version:      du.Version,
 		PasswordHash: ph,
+		Locale:       du.Locale,
 	})
 	if err != nil {
 		return err
 	}
+	err = s.savePasswordHistory(ctx, tx, du)
+	if err != nil {
+		return err
+	}
 
 	err = deleteTokensByUser(ctx, tx, du.ID)
 	if err != nil {
 		return err
 	}
+
+	err = enqueueUserEvent(ctx, tx, auth.EventUserPasswordChanged, du.ID, auth.WebhookEventData{})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SendPasswordSetupEmail emails a password setup code to the verified primary email of a user without a password.
+func (s *authService) SendPasswordSetupEmail(ctx context.Context, uid int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUser(ctx, tx, uid)
+	if err != nil {
+		return err
+	}
+	if len(du.PasswordHash) > 0 {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "password has already been set"}
+	}
+
+	de, err := getPrimaryEmail(ctx, tx, uid)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return err
+		}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "no primary email found"}
+	}
+	if !de.Verified {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "email address has not been verified yet"}
+	}
+
+	tkn, err := s.newPasswordSetupToken(ctx, tx, du.ID)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEmail(ctx, tx, emailPasswordSetup, de.Address, outboxData{Token: tkn, Name: du.Name.String, Locale: du.Locale.String})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PasswordSetupCode returns a single use code for the user of the account, after the user re-authenticates
+// with the provider. ExchangeSigninCode exchanges it for a password setup code,
+// so that the setup code never appears in a redirect url.
+// If codeChallenge is given, the code can only be exchanged with its S256 verifier.
+func (s *authService) PasswordSetupCode(ctx context.Context, account auth.AccountInput, codeChallenge string) (string, error) {
+	v := s.config.Policy.NewValidator()
+	account.Validate(v)
+	if auth.ValidateProvider(v, s.config.Providers, account.ProviderName); !v.Valid() {
+		return "", &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	du, err := getUserByAccount(ctx, tx, account.ProviderName, account.ProviderUserID)
+	if err != nil {
+		return "", err
+	}
+	if len(du.PasswordHash) > 0 {
+		return "", &auth.Error{Code: auth.EUNPROCESSABLE, Message: "password has already been set"}
+	}
+
+	code, err := issueSigninCode(ctx, tx, du.ID, auth.CodeActionPassword, codeChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	return code, tx.Commit()
+}
+
+// SetPassword sets the initial password of the user with a password setup code.
+// The other sessions are kept, since the user doesn't replace a possibly leaked password.
+func (s *authService) SetPassword(ctx context.Context, set auth.SetPasswordInput) error {
+	meta := auth.TokenPasswordSetup
+
+	v := s.config.Policy.NewValidator()
+	if set.Validate(v, meta); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUserByValidToken(ctx, tx, set.Token.HashToken(), meta.Scope)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return err
+		}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	if du.ID != set.UserID {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	if len(du.PasswordHash) > 0 {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "password has already been set"}
+	}
+
+	err = s.checkUserPassword(ctx, tx, du, set.Password)
+	if err != nil {
+		return err
+	}
+	ph, err := set.HashPassword()
+	if err != nil {
+		return err
+	}
+	err = updateUser(ctx, tx, dbUserUpdate{
+		ID:           du.ID,
+		Username:     du.Username,
+		Version:      du.Version,
+		PasswordHash: ph,
+		Locale:       du.Locale,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = deleteTokensByUserAndScope(ctx, tx, du.ID, meta.Scope)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueUserEvent(ctx, tx, auth.EventUserPasswordChanged, du.ID, auth.WebhookEventData{})
+	if err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
 func (s *authService) UserConfirmation(ctx context.Context, uid int, password string) (string, error) {
-	v := auth.NewValidator()
-	if auth.ValidatePassword(v, password); !v.Valid() {
-		return "", &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+	return s.ConfirmUser(ctx, auth.ConfirmationInput{UserID: uid, Method: auth.ConfirmPassword, Password: password})
+}
+
+// ConfirmUser returns a confirmation token after the user confirms with the password,
+// a code emailed by SendConfirmationEmail, or a code of the enabled authenticator.
+func (s *authService) ConfirmUser(ctx context.Context, confirm auth.ConfirmationInput) (string, error) {
+	v := s.config.Policy.NewValidator()
+	if confirm.Validate(v); !v.Valid() {
+		return "", &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
-	du, err := getUser(ctx, s.db, uid)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
-	user := toAuthUser(du)
-	ok, err := user.MatchPassword(password)
+	defer tx.Rollback()
+
+	du, err := getUser(ctx, tx, confirm.UserID)
 	if err != nil {
 		return "", err
 	}
-	if !ok {
-		return "", &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid authentication credentials"}
+
+	switch confirm.Method {
+	case auth.ConfirmPassword:
+		ok, err := toAuthUser(du).MatchPassword(confirm.Password)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid authentication credentials"}
+		}
+
+	case auth.ConfirmEmail:
+		dt, err := consumeToken(ctx, tx, auth.TokenInput{Text: confirm.Code}.HashToken(), auth.TokenConfirmationCode.Scope)
+		if err != nil {
+			if auth.ErrorCode(err) != auth.ENOTFOUND {
+				return "", err
+			}
+			return "", &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid code"}
+		}
+		if dt.UserID != du.ID || dt.Revoked || dt.Expiry.Before(time.Now()) {
+			return "", &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid code"}
+		}
+
+	case auth.ConfirmTOTP:
+		err = s.matchTOTP(ctx, du.ID, confirm.Code)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	tkn, err := auth.TokenConfirmation.New(user.ID, "")
+	tkn, err := newConfirmationToken(ctx, tx, du.ID)
 	if err != nil {
 		return "", err
 	}
-	err = insertToken(ctx, s.db, dbTokenInsert{
+	return tkn, tx.Commit()
+}
+
+// SendConfirmationEmail emails a confirmation code to the verified primary email of the user,
+// e.g. for the users without a password.
+func (s *authService) SendConfirmationEmail(ctx context.Context, uid int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUser(ctx, tx, uid)
+	if err != nil {
+		return err
+	}
+
+	de, err := getPrimaryEmail(ctx, tx, uid)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return err
+		}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "no primary email found"}
+	}
+	if !de.Verified {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "email address has not been verified yet"}
+	}
+
+	err = deleteTokensByUserAndScope(ctx, tx, uid, auth.TokenConfirmationCode.Scope)
+	if err != nil {
+		return err
+	}
+	tkn, err := auth.TokenConfirmationCode.New(uid, "")
+	if err != nil {
+		return err
+	}
+	err = insertToken(ctx, tx, dbTokenInsert{
 		UserID:  tkn.UserID,
 		Hash:    tkn.HashToken(),
 		Scope:   tkn.Scope,
 		Expiry:  tkn.Expiry,
 		Payload: tkn.Payload,
 	})
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEmail(ctx, tx, emailConfirmation, de.Address, outboxData{Token: tkn.Text, Name: du.Name.String, Locale: du.Locale.String})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ProviderConfirmationCode returns a single use code for the user of the account, after the user re-authenticates
+// with the provider. ExchangeSigninCode exchanges it for a confirmation token,
+// so that the confirmation token never appears in a redirect url.
+// If codeChallenge is given, the code can only be exchanged with its S256 verifier.
+func (s *authService) ProviderConfirmationCode(ctx context.Context, account auth.AccountInput, codeChallenge string) (string, error) {
+	v := s.config.Policy.NewValidator()
+	account.Validate(v)
+	if auth.ValidateProvider(v, s.config.Providers, account.ProviderName); !v.Valid() {
+		return "", &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
+	defer tx.Rollback()
 
-	return tkn.Text, nil
+	du, err := getUserByAccount(ctx, tx, account.ProviderName, account.ProviderUserID)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := issueSigninCode(ctx, tx, du.ID, auth.CodeActionConfirm, codeChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	return code, tx.Commit()
 }
 
 func (s *authService) AddEmail(ctx context.Context, uid int, address string) error {
-	v := auth.NewValidator()
+	address = auth.NormalizeEmail(address)
+
+	v := s.config.Policy.NewValidator()
 	if auth.ValidateEmail(v, address); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = s.checkEmailDomain(ctx, tx, address)
+	if err != nil {
+		return err
+	}
+	canonical := s.config.EmailCanonicalizer.Canonicalize(address)
+	err = s.checkEmailAlias(ctx, tx, address, canonical, uid)
+	if err != nil {
+		return err
 	}
 
-	err := insertEmail(ctx, s.db, dbEmailInsert{
-		UserID:  uid,
-		Address: address,
-		Primary: false,
+	err = insertEmail(ctx, tx, dbEmailInsert{
+		UserID:    uid,
+		Address:   address,
+		Primary:   false,
+		Canonical: canonical,
 	})
 	if err != nil {
 		return err
 	}
 
+	err = enqueueUserEvent(ctx, tx, auth.EventUserEmailAdded, uid, auth.WebhookEventData{Email: &address})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
 	return s.SendVerificationEmail(ctx, address)
 }
 
 func (s *authService) UpdatePrimaryEmail(ctx context.Context, uid int, address string) error {
-	v := auth.NewValidator()
+	address = auth.NormalizeEmail(address)
+
+	v := s.config.Policy.NewValidator()
 	if auth.ValidateEmail(v, address); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -508,6 +1148,11 @@ func (s *authService) UpdatePrimaryEmail(ctx context.Context, uid int, address s
 		return err
 	}
 
+	err = enqueueUserEvent(ctx, tx, auth.EventUserPrimaryEmailChanged, uid, auth.WebhookEventData{Email: &de.Address})
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -528,58 +1173,218 @@ func (s *authService) GetUserSettings(ctx context.Context, uid int) (*auth.UserS
 	}
 
 	return &auth.UserSettings{
-		User:     *toAuthUser(du),
-		Emails:   toAuthEmails(dee),
-		Accounts: toAuthAccounts(daa),
+		User:        *toAuthUser(du),
+		HasPassword: len(du.PasswordHash) > 0,
+		Emails:      toAuthEmails(dee),
+		Accounts:    toAuthAccounts(daa),
 	}, nil
 }
 
-func (s *authService) UpdateUsername(ctx context.Context, uid int, username string) error {
-	v := auth.NewValidator()
-	if auth.ValidateUsername(v, username); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+// UnlinkUserAccount deletes the linked account of the provider, and revokes its tokens.
+// The last account of a user without a password can't be unlinked, the user couldn't sign in otherwise.
+func (s *authService) UnlinkUserAccount(ctx context.Context, uid int, provider string) error {
+	v := s.config.Policy.NewValidator()
+	if auth.ValidateProvider(v, s.config.Providers, provider); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
-	du, err := getUser(ctx, s.db, uid)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	err = updateUser(ctx, s.db, dbUserUpdate{
-		ID:           du.ID,
-		Username:     username,
-		Version:      du.Version,
-		PasswordHash: du.PasswordHash,
-	})
-	return err
-}
-
-func (s *authService) UpdatePassword(ctx context.Context, password auth.UpdatePasswordInput) error {
-	v := auth.NewValidator()
-	if password.Validate(v); !v.Valid() {
-		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+	du, err := getUser(ctx, tx, uid)
+	if err != nil {
+		return err
 	}
-
-	du, err := getUser(ctx, s.db, password.UserID)
+	da, err := getAccountForUpdate(ctx, tx, uid, provider)
 	if err != nil {
 		return err
 	}
-	user := toAuthUser(du)
-	ok, err := user.MatchPassword(password.OldPassword)
+	daa, err := getAccountsByUser(ctx, tx, uid)
 	if err != nil {
 		return err
 	}
-	if !ok {
-		return &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid authentication credentials"}
+	if len(du.PasswordHash) == 0 && len(daa) == 1 {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "the only account of a user without a password can't be unlinked"}
 	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	err = deleteAccount(ctx, tx, uid, provider)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
-
-	ph, err := password.HashPassword()
+	err = enqueueUserEvent(ctx, tx, auth.EventUserAccountUnlinked, uid, auth.WebhookEventData{
+		Account: toAuthAccount(da),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	s.revokeProviderTokens(ctx, []dbAccount{*da})
+	return nil
+}
+
+// DeleteUser deletes the user with its emails, accounts and tokens, after the user confirms it.
+// The provider tokens of the accounts are revoked.
+func (s *authService) DeleteUser(ctx context.Context, del auth.DeleteUserInput) error {
+	meta := auth.TokenConfirmation
+
+	v := s.config.Policy.NewValidator()
+	if del.Validate(v, meta); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUserByValidToken(ctx, tx, del.Token.HashToken(), meta.Scope)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return err
+		}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	if du.ID != del.UserID {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+
+	daa, err := getAccountsByUser(ctx, tx, du.ID)
+	if err != nil {
+		return err
+	}
+	// the event is queued before the user is deleted, since it carries the user.
+	err = enqueueUserEvent(ctx, tx, auth.EventUserDeleted, du.ID, auth.WebhookEventData{})
+	if err != nil {
+		return err
+	}
+	err = deleteUser(ctx, tx, du.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	s.revokeProviderTokens(ctx, daa)
+	return nil
+}
+
+func (s *authService) UpdateUsername(ctx context.Context, uid int, username string) error {
+	username = auth.NormalizeUsername(username)
+
+	v := s.config.Policy.NewValidator()
+	if auth.ValidateUsername(v, username); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUser(ctx, tx, uid)
+	if err != nil {
+		return err
+	}
+
+	err = updateUser(ctx, tx, dbUserUpdate{
+		ID:           du.ID,
+		Username:     username,
+		Version:      du.Version,
+		PasswordHash: du.PasswordHash,
+		Locale:       du.Locale,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = enqueueUserEvent(ctx, tx, auth.EventUserUsernameChanged, uid, auth.WebhookEventData{})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *authService) UpdateLocale(ctx context.Context, uid int, locale string) error {
+	v := s.config.Policy.NewValidator()
+	if auth.ValidateLocale(v, locale); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	du, err := getUser(ctx, s.db, uid)
+	if err != nil {
+		return err
+	}
+
+	err = updateUser(ctx, s.db, dbUserUpdate{
+		ID:           du.ID,
+		Username:     du.Username,
+		Version:      du.Version,
+		PasswordHash: du.PasswordHash,
+		Locale:       auth.NewNullString(locale),
+	})
+	return err
+}
+
+// PasswordStrength estimates the strength of a password for the password meters, it's not stored anywhere.
+func (s *authService) PasswordStrength(ctx context.Context, password auth.PasswordStrengthInput) (*auth.PasswordStrength, error) {
+	v := s.config.Policy.NewValidator()
+	if password.Validate(v); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	strength := auth.EstimatePasswordStrength(password.Password, password.Username, password.Name, password.Email)
+	strength.MinScore = *s.config.MinPasswordScore
+	if s.config.BreachedPasswords != nil {
+		breached, err := s.config.BreachedPasswords.Breached(password.Password)
+		if err != nil {
+			return nil, err
+		}
+		strength.Breached = breached
+	}
+	return &strength, nil
+}
+
+func (s *authService) UpdatePassword(ctx context.Context, password auth.UpdatePasswordInput) error {
+	v := s.config.Policy.NewValidator()
+	if password.Validate(v); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	du, err := getUser(ctx, s.db, password.UserID)
+	if err != nil {
+		return err
+	}
+	user := toAuthUser(du)
+	ok, err := user.MatchPassword(password.OldPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid authentication credentials"}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = s.checkUserPassword(ctx, tx, du, password.NewPassword)
+	if err != nil {
+		return err
+	}
+	ph, err := password.HashPassword()
 	if err != nil {
 		return err
 	}
@@ -588,24 +1393,34 @@ func (s *authService) UpdatePassword(ctx context.Context, password auth.UpdatePa
 		Username:     du.Username,
 		Version:      du.Version,
 		PasswordHash: ph,
+		Locale:       du.Locale,
 	})
 	if err != nil {
 		return err
 	}
+	err = s.savePasswordHistory(ctx, tx, du)
+	if err != nil {
+		return err
+	}
 
 	err = deleteTokensByUser(ctx, tx, du.ID)
 	if err != nil {
 		return err
 	}
+
+	err = enqueueUserEvent(ctx, tx, auth.EventUserPasswordChanged, du.ID, auth.WebhookEventData{})
+	if err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
 func (s *authService) GetUser(ctx context.Context, token auth.TokenInput) (*auth.User, error) {
 	meta := auth.TokenAuth
 
-	v := auth.NewValidator()
+	v := s.config.Policy.NewValidator()
 	if token.Validate(v, meta); !v.Valid() {
-		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors}
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
 	}
 
 	du, err := getUserByValidToken(ctx, s.db, token.HashToken(), meta.Scope)
@@ -622,11 +1437,221 @@ func (s *authService) GetUser(ctx context.Context, token auth.TokenInput) (*auth
 // db
 //
 
-func createOAuthUser(ctx context.Context, tx *Tx, signin auth.SigninSocialInput) (int, error) {
+// signinSocial returns the user of the account, or a new user if there is none.
+// If there is a user with the same primary email, the account is linked to it only if
+// the provider is trusted and both emails are verified. Otherwise, a pending link is created,
+// and its token is returned instead of the user. The provider tokens are stored with the account,
+// except for the pending links.
+func (s *authService) signinSocial(ctx context.Context, tx *Tx, signin auth.SigninSocialInput) (*auth.User, string, error) {
+	du, err := getUserByAccount(ctx, tx, signin.Account.ProviderName, signin.Account.ProviderUserID)
+	if err == nil {
+		err := updateAccountProfile(ctx, tx, dbAccountProfileUpdate{
+			ProviderName:   signin.Account.ProviderName,
+			ProviderUserID: signin.Account.ProviderUserID,
+			Profile:        signin.Account.Profile,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		err = s.verifyProviderEmail(ctx, tx, du.ID, signin)
+		if err != nil {
+			return nil, "", err
+		}
+		err = s.saveProviderTokens(ctx, tx, du.ID, signin.Account)
+		if err != nil {
+			return nil, "", err
+		}
+		return toAuthUser(du), "", nil
+	}
+	if auth.ErrorCode(err) != auth.ENOTFOUND {
+		return nil, "", err
+	}
+
+	du, err = getUserByPrimaryEmail(ctx, tx, signin.Email.String)
+	if err == nil {
+		de, err := getEmail(ctx, tx, signin.Email.String)
+		if err != nil {
+			return nil, "", err
+		}
+		if !s.trustsEmail(signin) || !de.Verified {
+			link, err := createPendingLink(ctx, tx, du, de, signin.Account)
+			return nil, link, err
+		}
+
+		err = linkUserAccount(ctx, tx, du, signin.Account)
+		if err != nil {
+			return nil, "", err
+		}
+		err = s.verifyProviderEmail(ctx, tx, du.ID, signin)
+		if err != nil {
+			return nil, "", err
+		}
+		err = s.saveProviderTokens(ctx, tx, du.ID, signin.Account)
+		if err != nil {
+			return nil, "", err
+		}
+		return toAuthUser(du), "", nil
+	}
+	if auth.ErrorCode(err) != auth.ENOTFOUND {
+		return nil, "", err
+	}
+
+	if signin.Email.Valid {
+		err = s.checkEmailDomain(ctx, tx, signin.Email.String)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	id, err := s.createOAuthUser(ctx, tx, signin)
+	if err != nil {
+		return nil, "", err
+	}
+	err = enqueueUserEvent(ctx, tx, auth.EventUserCreated, id, auth.WebhookEventData{
+		Email:   nullStringPtr(signin.Email),
+		Account: &auth.Account{UserID: id, ProviderName: signin.Account.ProviderName, ProviderUserID: signin.Account.ProviderUserID},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	err = s.verifyProviderEmail(ctx, tx, id, signin)
+	if err != nil {
+		return nil, "", err
+	}
+	err = s.saveProviderTokens(ctx, tx, id, signin.Account)
+	if err != nil {
+		return nil, "", err
+	}
+	du, err = getUser(ctx, tx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	return toAuthUser(du), "", nil
+}
+
+// verifyProviderEmail marks the email of the user verified, if a trusted provider asserts that it's verified.
+// The claims of the other providers aren't trusted, otherwise they could verify any address and
+// get the accounts of its owner linked to their user.
+func (s *authService) verifyProviderEmail(ctx context.Context, tx *Tx, uid int, signin auth.SigninSocialInput) error {
+	if !s.trustsEmail(signin) || !signin.Email.Valid {
+		return nil
+	}
+
+	de, err := getEmail(ctx, tx, signin.Email.String)
+	if err != nil {
+		if auth.ErrorCode(err) == auth.ENOTFOUND {
+			return nil
+		}
+		return err
+	}
+	if de.UserID != uid || de.Verified {
+		return nil
+	}
+
+	err = updateEmail(ctx, tx, dbEmailUpdate{
+		Address:  de.Address,
+		Primary:  de.Primary,
+		Verified: true,
+	})
+	if err != nil {
+		return err
+	}
+	return enqueueUserEvent(ctx, tx, auth.EventUserEmailVerified, uid, auth.WebhookEventData{Email: &de.Address})
+}
+
+// createPendingLink stores the account until the user confirms it, either with the returned token and
+// the password, or with the code emailed to the primary email.
+func createPendingLink(ctx context.Context, tx *Tx, user *dbUser, email *dbEmail, account auth.AccountInput) (string, error) {
+	payload, err := json.Marshal(linkPayload{
+		ProviderName:   account.ProviderName,
+		ProviderUserID: account.ProviderUserID,
+		Profile:        account.Profile,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	tkn, err := auth.TokenAccountLink.New(user.ID, string(payload))
+	if err != nil {
+		return "", err
+	}
+	vtkn, err := auth.TokenAccountLinkVerification.New(user.ID, string(payload))
+	if err != nil {
+		return "", err
+	}
+	for _, t := range []*auth.Token{tkn, vtkn} {
+		err = insertToken(ctx, tx, dbTokenInsert{
+			UserID:  t.UserID,
+			Hash:    t.HashToken(),
+			Scope:   t.Scope,
+			Expiry:  t.Expiry,
+			Payload: t.Payload,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = enqueueEmail(ctx, tx, emailAccountLink, email.Address, outboxData{
+		Token:    vtkn.Text,
+		Name:     user.Name.String,
+		Locale:   user.Locale.String,
+		Provider: account.ProviderName,
+	})
+	if err != nil {
+		return "", err
+	}
+	return tkn.Text, nil
+}
+
+// getPendingLink returns the user and the account of a valid pending link token.
+func getPendingLink(ctx context.Context, tx *Tx, hash []byte, scope string) (*dbUser, auth.AccountInput, error) {
+	dt, err := getToken(ctx, tx, hash, scope)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, auth.AccountInput{}, err
+		}
+		return nil, auth.AccountInput{}, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	if dt.Revoked || dt.Expiry.Before(time.Now()) {
+		return nil, auth.AccountInput{}, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+
+	p := linkPayload{}
+	if err := json.Unmarshal([]byte(dt.Payload.String), &p); err != nil {
+		return nil, auth.AccountInput{}, err
+	}
+	du, err := getUser(ctx, tx, dt.UserID)
+	if err != nil {
+		return nil, auth.AccountInput{}, err
+	}
+	return du, auth.AccountInput{ProviderName: p.ProviderName, ProviderUserID: p.ProviderUserID, Profile: p.Profile}, nil
+}
+
+// completePendingLink links the account, and deletes the pending links of the user.
+func completePendingLink(ctx context.Context, tx *Tx, user *dbUser, account auth.AccountInput) error {
+	err := linkUserAccount(ctx, tx, user, account)
+	if err != nil {
+		return err
+	}
+	for _, scope := range []string{auth.TokenAccountLink.Scope, auth.TokenAccountLinkVerification.Scope} {
+		err = deleteTokensByUserAndScope(ctx, tx, user.ID, scope)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *authService) createOAuthUser(ctx context.Context, tx *Tx, signin auth.SigninSocialInput) (int, error) {
+	username := signin.Username
+	if username == "" {
+		username = auth.RandomUsername()
+	}
 	uid, err := insertUser(ctx, tx, dbUserInsert{
-		Username:     signin.Username,
+		Username:     username,
 		Name:         signin.Name,
 		PasswordHash: signin.PasswordHash(),
+		Locale:       auth.NewNullString(signin.Locale),
 	})
 	if err != nil {
 		return -1, err
@@ -637,9 +1662,10 @@ func createOAuthUser(ctx context.Context, tx *Tx, signin auth.SigninSocialInput)
 		// but that breaks the transaction. therefore use a savepoint.
 		err := tx.WithSavepoint(ctx, func(tx *Tx) error {
 			return insertEmail(ctx, tx, dbEmailInsert{
-				UserID:  uid,
-				Address: signin.Email.String,
-				Primary: signin.IsPrimaryEmail(true),
+				UserID:    uid,
+				Address:   signin.Email.String,
+				Primary:   signin.IsPrimaryEmail(true),
+				Canonical: s.config.EmailCanonicalizer.Canonicalize(signin.Email.String),
 			})
 		})
 		if err != nil && auth.ErrorCode(err) != auth.EUNPROCESSABLE { // EUNPROCESSABLE maps to duplicate
@@ -651,6 +1677,7 @@ func createOAuthUser(ctx context.Context, tx *Tx, signin auth.SigninSocialInput)
 		UserID:         uid,
 		ProviderName:   signin.Account.ProviderName,
 		ProviderUserID: signin.Account.ProviderUserID,
+		Profile:        signin.Account.Profile,
 	})
 	if err != nil {
 		return -1, err
@@ -660,37 +1687,227 @@ func createOAuthUser(ctx context.Context, tx *Tx, signin auth.SigninSocialInput)
 }
 
 func linkUserAccount(ctx context.Context, tx *Tx, user *dbUser, account auth.AccountInput) error {
-	// A malicious person could sign up with an email address of someone else.
-	// But, he/she is not be able to verify it.
-	// And also, we link accounts to the users based on the email address.
-	// So, this can lead giving access to the malicious person since he/she knows the password.
-	// To avoid that, we should reset the password for an unverified email when linking accounts.
-	if de, err := getEmailByUser(ctx, tx, user.ID); err == nil && de.Primary {
-		if !de.Verified {
-			if err := updateUser(ctx, tx, dbUserUpdate{
-				ID:           user.ID,
-				Username:     user.Username,
-				Version:      user.Version,
-				PasswordHash: nil,
-			}); err != nil {
-				return err
-			}
-		}
-	}
-
 	err := insertAccount(ctx, tx, dbAccountInsert{
 		UserID:         user.ID,
 		ProviderName:   account.ProviderName,
 		ProviderUserID: account.ProviderUserID,
+		Profile:        account.Profile,
+	})
+	if err != nil {
+		return err
+	}
+
+	return enqueueUserEvent(ctx, tx, auth.EventUserAccountLinked, user.ID, auth.WebhookEventData{
+		Account: &auth.Account{UserID: user.ID, ProviderName: account.ProviderName, ProviderUserID: account.ProviderUserID},
 	})
-	return err
 }
 
 //
 // mailer
 //
 
+// Mailer sends the emails in the recipient's locale.
+// An empty locale means the default locale.
 type Mailer interface {
-	SendVerificationEmail(recipient, token string) error
-	SendPasswordResetEmail(recipient, token string) error
+	SendVerificationEmail(to auth.Recipient, token string) error
+	SendPasswordResetEmail(to auth.Recipient, token string) error
+	SendAccountLinkEmail(to auth.Recipient, token, provider string) error
+	SendPasswordSetupEmail(to auth.Recipient, token string) error
+	SendConfirmationEmail(to auth.Recipient, token string) error
+}
+
+//
+// Helpers
+//
+
+// trustsEmail returns true if the provider of the account is trusted, and it asserts that the email is verified.
+func (s *authService) trustsEmail(signin auth.SigninSocialInput) bool {
+	return signin.EmailVerified && contains(s.config.TrustedEmailProviders, signin.Account.ProviderName)
+}
+
+// checkEmailAlias rejects the email if its canonical form belongs to another user, and the service rejects the aliases.
+// An address which is already stored isn't an alias, it's rejected as a duplicate by insertEmail.
+func (s *authService) checkEmailAlias(ctx context.Context, dbx DBTX, address, canonical string, uid int) error {
+	if !s.config.RejectEmailAliases {
+		return nil
+	}
+
+	_, err := getEmail(ctx, dbx, address)
+	if err == nil {
+		return nil
+	}
+	if auth.ErrorCode(err) != auth.ENOTFOUND {
+		return err
+	}
+
+	exists, err := existsCanonicalEmail(ctx, dbx, canonical, uid)
+	if err != nil {
+		return err
+	}
+	if exists {
+		v := s.config.Policy.NewValidator()
+		v.AddError("email", auth.NewMessage(auth.MsgEmailAlias))
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+	return nil
+}
+
+// checkPassword rejects a new password which appeared in a data breach, or which scores below the minimum score.
+// The user inputs like the username, name and email are guessed first by the score.
+func (s *authService) checkPassword(password string, userInputs ...string) error {
+	v := s.config.Policy.NewValidator()
+	if s.config.BreachedPasswords != nil {
+		breached, err := s.config.BreachedPasswords.Breached(password)
+		if err != nil {
+			return err
+		}
+		auth.ValidatePasswordBreach(v, breached)
+	}
+	if auth.ValidatePasswordStrength(v, auth.EstimatePasswordStrength(password, userInputs...), *s.config.MinPasswordScore); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+	return nil
+}
+
+// checkUserPassword checks the new password of an existing user against the user's username, name, emails
+// and previous passwords.
+func (s *authService) checkUserPassword(ctx context.Context, dbx DBTX, du *dbUser, password string) error {
+	dee, err := getEmailsByUser(ctx, dbx, du.ID)
+	if err != nil {
+		return err
+	}
+	inputs := []string{du.Username, du.Name.String}
+	for _, de := range dee {
+		inputs = append(inputs, de.Address)
+	}
+	err = s.checkPassword(password, inputs...)
+	if err != nil {
+		return err
+	}
+	return s.checkPasswordHistory(ctx, dbx, du, password)
+}
+
+// issueSigninCode stores a new single use code of the action for the user.
+func issueSigninCode(ctx context.Context, tx *Tx, uid int, action, codeChallenge string) (string, error) {
+	payload, err := json.Marshal(signinCodePayload{Action: action, CodeChallenge: codeChallenge})
+	if err != nil {
+		return "", err
+	}
+
+	tkn, err := auth.TokenSigninCode.New(uid, string(payload))
+	if err != nil {
+		return "", err
+	}
+	err = insertToken(ctx, tx, dbTokenInsert{
+		UserID:  tkn.UserID,
+		Hash:    tkn.HashToken(),
+		Scope:   tkn.Scope,
+		Expiry:  tkn.Expiry,
+		Payload: tkn.Payload,
+	})
+	if err != nil {
+		return "", err
+	}
+	return tkn.Text, nil
+}
+
+// newPasswordSetupToken replaces the password setup codes of the user with a new one.
+func (s *authService) newPasswordSetupToken(ctx context.Context, tx *Tx, uid int) (string, error) {
+	err := deleteTokensByUserAndScope(ctx, tx, uid, auth.TokenPasswordSetup.Scope)
+	if err != nil {
+		return "", err
+	}
+
+	tkn, err := auth.TokenPasswordSetup.New(uid, "")
+	if err != nil {
+		return "", err
+	}
+	err = insertToken(ctx, tx, dbTokenInsert{
+		UserID:  tkn.UserID,
+		Hash:    tkn.HashToken(),
+		Scope:   tkn.Scope,
+		Expiry:  tkn.Expiry,
+		Payload: tkn.Payload,
+	})
+	if err != nil {
+		return "", err
+	}
+	return tkn.Text, nil
+}
+
+// getUserBySignin returns the user of the signin identifier, and the email to verify:
+// the email itself, or the primary email of the username. The users of a provider
+// without an email may not have one, then the email is nil.
+func getUserBySignin(ctx context.Context, dbx DBTX, signin auth.SigninInput) (*dbUser, *dbEmail, error) {
+	if signin.IsEmail() {
+		du, err := getUserByEmail(ctx, dbx, signin.ID())
+		if err != nil {
+			return nil, nil, err
+		}
+		de, err := getEmail(ctx, dbx, signin.ID())
+		if err != nil {
+			return nil, nil, err
+		}
+		return du, de, nil
+	}
+
+	du, err := getUserByUsername(ctx, dbx, signin.ID())
+	if err != nil {
+		return nil, nil, err
+	}
+	de, err := getPrimaryEmail(ctx, dbx, du.ID)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, nil, err
+		}
+		return du, nil, nil
+	}
+	return du, de, nil
+}
+
+// newConfirmationToken inserts a confirmation token for the user.
+func newConfirmationToken(ctx context.Context, dbx DBTX, uid int) (string, error) {
+	tkn, err := auth.TokenConfirmation.New(uid, "")
+	if err != nil {
+		return "", err
+	}
+	err = insertToken(ctx, dbx, dbTokenInsert{
+		UserID:  tkn.UserID,
+		Hash:    tkn.HashToken(),
+		Scope:   tkn.Scope,
+		Expiry:  tkn.Expiry,
+		Payload: tkn.Payload,
+	})
+	if err != nil {
+		return "", err
+	}
+	return tkn.Text, nil
+}
+
+// getUserByConfirmation returns the user of the confirmation token, which must be the given user.
+func getUserByConfirmation(ctx context.Context, dbx DBTX, token auth.TokenInput, uid int) (*dbUser, error) {
+	du, err := getUserByValidToken(ctx, dbx, token.HashToken(), auth.TokenConfirmation.Scope)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	if du.ID != uid {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid code"}
+	}
+	return du, nil
+}
+
+// pendingLinkError tells the client to confirm the pending link with its token,
+// unless the pending link couldn't be committed.
+func pendingLinkError(token string, err error) error {
+	if err != nil {
+		return err
+	}
+	return &auth.Error{
+		Code:    auth.ECONFLICT,
+		Message: "an account with this email already exists, the link must be confirmed",
+		Detail:  map[string]string{"link_token": token},
+	}
 }
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+
+	"github.com/aemdemir/auth"
+)
+
+// canonicalizeBatchSize is the number of the emails read at a time by CanonicalizeEmails.
+const canonicalizeBatchSize = 1000
+
+// CanonicalizeEmails stores the canonical forms of the emails which have none, or whose canonical form
+// differs from the one of the canonicalizer, e.g. the emails added before the canonical forms were stored,
+// or before the rules changed. It's run at startup with the canonicalizer of Config.EmailCanonicalizer,
+// auth.DefaultCanonicalizer if it's nil, and it returns the number of the updated emails.
+func CanonicalizeEmails(ctx context.Context, db *DB, c auth.EmailCanonicalizer) (int, error) {
+	if c == nil {
+		c = auth.DefaultCanonicalizer
+	}
+
+	n := 0
+	after := ""
+	for {
+		dee, err := getEmailsAfter(ctx, db, after, canonicalizeBatchSize)
+		if err != nil {
+			return n, err
+		}
+		if len(dee) == 0 {
+			return n, nil
+		}
+
+		for _, de := range dee {
+			canonical := c.Canonicalize(de.Address)
+			if de.Canonical.Valid && de.Canonical.String == canonical {
+				continue
+			}
+			err = updateEmailCanonical(ctx, db, de.Address, canonical)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		after = dee[len(dee)-1].Address
+	}
+}
+
+//
+// db
+//
+
+// getEmailsAfter returns the emails ordered by their address, which come after the given address.
+func getEmailsAfter(ctx context.Context, dbx DBTX, after string, limit int) ([]dbEmail, error) {
+	query := `
+	SELECT
+		user_id,
+		address,
+		canonical_address,
+		is_primary,
+		verified,
+		created,
+		updated
+	FROM     user_email
+	WHERE    address > $1
+	ORDER BY address
+	LIMIT    $2
+	`
+
+	e := []dbEmail{}
+
+	err := dbx.SelectContext(ctx, &e, query, after, limit)
+	return e, err
+}
+
+func updateEmailCanonical(ctx context.Context, dbx DBTX, address, canonical string) error {
+	query := `UPDATE user_email SET canonical_address = $1 WHERE address = $2`
+
+	_, err := dbx.ExecContext(ctx, query, canonical, address)
+	return err
+}
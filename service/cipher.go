@@ -0,0 +1,59 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var errDecryption = errors.New("can't be decrypted with any of the keys")
+
+// seal encrypts the plaintext with the first key, the nonce is prepended to the ciphertext.
+// The additional data binds the ciphertext to its row, it must be the same when it's opened.
+func seal(keys [][]byte, plain, data []byte) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key configured")
+	}
+
+	gcm, err := newGCM(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, data), nil
+}
+
+// open decrypts the ciphertext of seal with the first matching key, so that the keys can be rotated.
+func open(keys [][]byte, enc, data []byte) ([]byte, error) {
+	for _, key := range keys {
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(enc) < gcm.NonceSize() {
+			return nil, errDecryption
+		}
+		plain, err := gcm.Open(nil, enc[:gcm.NonceSize()], enc[gcm.NonceSize():], data)
+		if err != nil {
+			continue
+		}
+		return plain, nil
+	}
+	return nil, errDecryption
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
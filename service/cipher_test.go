@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/aemdemir/auth"
+)
+
+func TestSealOpen(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	plain := []byte("secret")
+
+	enc, err := seal([][]byte{oldKey}, plain, []byte("user_totp:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keys    [][]byte
+		data    []byte
+		wantErr bool
+	}{
+		{"same key", [][]byte{oldKey}, []byte("user_totp:1"), false},
+		{"rotated keys", [][]byte{newKey, oldKey}, []byte("user_totp:1"), false},
+		{"removed key", [][]byte{newKey}, []byte("user_totp:1"), true},
+		{"other data", [][]byte{oldKey}, []byte("user_totp:2"), true},
+		{"no keys", nil, []byte("user_totp:1"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := open(tt.keys, enc, tt.data)
+			if tt.wantErr {
+				if !errors.Is(err, errDecryption) {
+					t.Errorf("open() error = %v, want errDecryption", err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("open() = %q, %v", got, err)
+			}
+		})
+	}
+
+	if _, err := seal(nil, plain, nil); err == nil {
+		t.Errorf("seal() without keys succeeded")
+	}
+	if _, err := seal([][]byte{[]byte("short")}, plain, nil); err == nil {
+		t.Errorf("seal() with a short key succeeded")
+	}
+}
+
+func TestProviderTokensBoundToAccount(t *testing.T) {
+	s := &authService{config: Config{ProviderTokens: ProviderTokensConfig{Keys: [][]byte{bytes.Repeat([]byte{1}, 32)}}}}
+	tokens := auth.ProviderTokens{AccessToken: "access", RefreshToken: "refresh"}
+
+	enc, err := s.encryptTokens(tokens, 1, "google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.decryptTokens(enc, 1, "google")
+	if err != nil || got.AccessToken != "access" || got.RefreshToken != "refresh" {
+		t.Fatalf("decryptTokens() = %+v, %v", got, err)
+	}
+
+	// the tokens copied to another account's row aren't decrypted.
+	for _, other := range []struct {
+		uid      int
+		provider string
+	}{{2, "google"}, {1, "github"}} {
+		if _, err := s.decryptTokens(enc, other.uid, other.provider); !errors.Is(err, errTokenDecryption) {
+			t.Errorf("decryptTokens(%d, %s) error = %v, want errTokenDecryption", other.uid, other.provider, err)
+		}
+	}
+}
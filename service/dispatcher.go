@@ -0,0 +1,205 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/rs/zerolog"
+)
+
+type WebhookDispatcherConfig struct {
+	// PollInterval is the wait time between queue checks when the queue is empty.
+	PollInterval time.Duration
+	// BatchSize is the max number of deliveries claimed at once.
+	BatchSize int
+	// MaxAttempts is the number of tries before a delivery is marked as failed.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential backoff between tries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout is the http request timeout for a single try.
+	Timeout time.Duration
+}
+
+var DefaultWebhookDispatcherConfig = WebhookDispatcherConfig{
+	PollInterval: 5 * time.Second,
+	BatchSize:    10,
+	MaxAttempts:  8,
+	MinBackoff:   30 * time.Second,
+	MaxBackoff:   6 * time.Hour,
+	Timeout:      10 * time.Second,
+}
+
+// WebhookDispatcher delivers the queued webhook events.
+type WebhookDispatcher struct {
+	db     *DB
+	logger zerolog.Logger
+	client *http.Client
+	config WebhookDispatcherConfig
+}
+
+func NewWebhookDispatcher(db *DB, logger zerolog.Logger, config WebhookDispatcherConfig) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:     db,
+		logger: logger,
+		client: &http.Client{Timeout: config.Timeout},
+		config: config,
+	}
+}
+
+// Run delivers the queued events until ctx is done.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	for {
+		n, err := d.dispatch(ctx)
+		if err != nil {
+			d.logger.Err(err).Msg("failed to dispatch webhook deliveries")
+		}
+		if n == d.config.BatchSize {
+			// there may be more due deliveries, don't wait.
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.config.PollInterval):
+		}
+	}
+}
+
+// dispatch claims and delivers a batch of due deliveries,
+// and returns the number of claimed deliveries.
+//
+// The deliveries are claimed in a short transaction which leases them by moving their next attempt forward,
+// so the http calls don't hold a connection or row locks, and a failed result doesn't roll back the others.
+// A claimed delivery is tried again after the lease if the dispatcher stops before recording its result.
+func (d *WebhookDispatcher) dispatch(ctx context.Context) (int, error) {
+	lease := time.Duration(d.config.BatchSize)*d.config.Timeout + time.Minute
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	ddd, err := claimWebhookDeliveries(ctx, tx, d.config.BatchSize, time.Now().Add(lease))
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// a failure of a single delivery is only logged, the others of the batch are still delivered.
+	for _, dd := range ddd {
+		dw, err := getWebhook(ctx, d.db, dd.WebhookID)
+		if err != nil {
+			if auth.ErrorCode(err) == auth.ENOTFOUND {
+				// the webhook is deleted, the delivery can't be delivered anymore.
+				err = updateWebhookDelivery(ctx, d.db, dbWebhookDeliveryUpdate{
+					ID:          dd.ID,
+					Status:      auth.DeliveryFailed,
+					Attempts:    dd.Attempts,
+					NextAttempt: dd.NextAttempt,
+				})
+			}
+			if err != nil {
+				d.logger.Err(err).Int("delivery_id", dd.ID).Msg("failed to get the webhook of the delivery")
+			}
+			continue
+		}
+
+		start := time.Now()
+		code, err := d.deliver(ctx, dw, &dd)
+		duration := int(time.Since(start).Milliseconds())
+
+		attempt := dbWebhookAttemptInsert{
+			DeliveryID: dd.ID,
+			StatusCode: code,
+			Duration:   duration,
+		}
+		if err != nil {
+			attempt.Error = auth.NewNullString(err.Error())
+			d.logger.Warn().
+				Err(err).
+				Int("delivery_id", dd.ID).
+				Int("webhook_id", dd.WebhookID).
+				Int("attempts", dd.Attempts+1).
+				Msg("webhook delivery failed")
+		}
+		if err := d.record(ctx, attempt, d.nextState(&dd, err)); err != nil {
+			d.logger.Err(err).Int("delivery_id", dd.ID).Msg("failed to record the webhook delivery")
+		}
+	}
+
+	return len(ddd), nil
+}
+
+// record saves the result of a single try in its own transaction.
+func (d *WebhookDispatcher) record(ctx context.Context, attempt dbWebhookAttemptInsert, up dbWebhookDeliveryUpdate) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertWebhookAttempt(ctx, tx, attempt); err != nil {
+		return err
+	}
+	if err := updateWebhookDelivery(ctx, tx, up); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// nextState returns the delivery after a try: succeeded, pending with a backoff, or failed after MaxAttempts.
+func (d *WebhookDispatcher) nextState(dd *dbWebhookDelivery, err error) dbWebhookDeliveryUpdate {
+	up := dbWebhookDeliveryUpdate{
+		ID:          dd.ID,
+		Status:      auth.DeliverySucceeded,
+		Attempts:    dd.Attempts + 1,
+		NextAttempt: dd.NextAttempt,
+	}
+	if err == nil {
+		return up
+	}
+	if up.Attempts >= d.config.MaxAttempts {
+		up.Status = auth.DeliveryFailed
+	} else {
+		up.Status = auth.DeliveryPending
+		up.NextAttempt = time.Now().Add(backoff(up.Attempts, d.config.MinBackoff, d.config.MaxBackoff))
+	}
+	return up
+}
+
+// deliver posts the signed payload to the webhook url.
+// Any response other than 2xx is considered as failure.
+func (d *WebhookDispatcher) deliver(ctx context.Context, w *dbWebhook, dd *dbWebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(dd.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "auth-webhook/1.0")
+	req.Header.Set(auth.WebhookHeaderEvent, dd.Event)
+	req.Header.Set(auth.WebhookHeaderDelivery, strconv.Itoa(dd.ID))
+	req.Header.Set(auth.WebhookHeaderSignature, auth.SignWebhook(w.Secret, time.Now(), dd.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/rs/zerolog"
+)
+
+func TestWebhookDispatcherDeliver(t *testing.T) {
+	var status int
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	d := NewWebhookDispatcher(nil, zerolog.Nop(), DefaultWebhookDispatcherConfig)
+	w := &dbWebhook{ID: 1, URL: srv.URL, Secret: "secret"}
+	dd := &dbWebhookDelivery{ID: 7, WebhookID: 1, Event: auth.EventUserCreated, Payload: []byte(`{"id":1}`)}
+
+	t.Run("success", func(t *testing.T) {
+		status = http.StatusNoContent
+		code, err := d.deliver(context.Background(), w, dd)
+		if err != nil || code != http.StatusNoContent {
+			t.Fatalf("deliver() = %d, %v", code, err)
+		}
+		if got.Header.Get(auth.WebhookHeaderEvent) != auth.EventUserCreated {
+			t.Errorf("event header = %q", got.Header.Get(auth.WebhookHeaderEvent))
+		}
+		if got.Header.Get(auth.WebhookHeaderDelivery) != strconv.Itoa(dd.ID) {
+			t.Errorf("delivery header = %q", got.Header.Get(auth.WebhookHeaderDelivery))
+		}
+		if !auth.VerifyWebhook(w.Secret, got.Header.Get(auth.WebhookHeaderSignature), gotBody, time.Minute) {
+			t.Errorf("signature is not verified")
+		}
+	})
+
+	t.Run("failure status", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		code, err := d.deliver(context.Background(), w, dd)
+		if err == nil || code != http.StatusInternalServerError {
+			t.Fatalf("deliver() = %d, %v", code, err)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		_, err := d.deliver(context.Background(), &dbWebhook{URL: down.URL}, dd)
+		if err == nil {
+			t.Fatal("deliver() = nil error")
+		}
+	})
+}
+
+func TestWebhookDispatcherNextState(t *testing.T) {
+	config := DefaultWebhookDispatcherConfig
+	config.MaxAttempts = 3
+	d := NewWebhookDispatcher(nil, zerolog.Nop(), config)
+	next := time.Now()
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		status   string
+	}{
+		{"success", 0, nil, auth.DeliverySucceeded},
+		{"first failure", 0, failure, auth.DeliveryPending},
+		{"retry failure", 1, failure, auth.DeliveryPending},
+		{"last failure", 2, failure, auth.DeliveryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := d.nextState(&dbWebhookDelivery{ID: 1, Attempts: tt.attempts, NextAttempt: next}, tt.err)
+			if up.Status != tt.status {
+				t.Errorf("status = %q, want %q", up.Status, tt.status)
+			}
+			if up.Attempts != tt.attempts+1 {
+				t.Errorf("attempts = %d, want %d", up.Attempts, tt.attempts+1)
+			}
+			if tt.status == auth.DeliveryPending {
+				if up.NextAttempt.Before(time.Now().Add(config.MinBackoff - time.Second)) {
+					t.Errorf("next attempt = %v, it's not delayed", up.NextAttempt)
+				}
+			} else if !up.NextAttempt.Equal(next) {
+				t.Errorf("next attempt = %v, want %v", up.NextAttempt, next)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	min, max := 10*time.Second, time.Minute
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{10, time.Minute},
+	}
+	for _, tt := range tests {
+		got := backoff(tt.attempts, min, max)
+		// the jitter adds up to a fifth of the wait.
+		if got < tt.base || got > tt.base+tt.base/5 {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", tt.attempts, got, tt.base, tt.base+tt.base/5)
+		}
+	}
+}
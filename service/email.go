@@ -15,12 +15,13 @@ import (
 //
 
 type dbEmail struct {
-	UserID   int       `db:"user_id"`
-	Address  string    `db:"address"`
-	Primary  bool      `db:"is_primary"`
-	Verified bool      `db:"verified"`
-	Created  time.Time `db:"created"`
-	Updated  time.Time `db:"updated"`
+	UserID    int             `db:"user_id"`
+	Address   string          `db:"address"`
+	Canonical auth.NullString `db:"canonical_address"`
+	Primary   bool            `db:"is_primary"`
+	Verified  bool            `db:"verified"`
+	Created   time.Time       `db:"created"`
+	Updated   time.Time       `db:"updated"`
 }
 
 func getEmail(ctx context.Context, dbx DBTX, address string) (*dbEmail, error) {
@@ -33,7 +34,7 @@ func getEmail(ctx context.Context, dbx DBTX, address string) (*dbEmail, error) {
 		created, 
 		updated
 	FROM  user_email
-	WHERE address = $1
+	WHERE lower(address) = lower($1)
 	`
 
 	e := dbEmail{}
@@ -77,6 +78,33 @@ func getEmailByUser(ctx context.Context, dbx DBTX, id int) (*dbEmail, error) {
 	return &e, nil
 }
 
+func getPrimaryEmail(ctx context.Context, dbx DBTX, id int) (*dbEmail, error) {
+	query := `
+	SELECT
+		user_id,
+		address,
+		is_primary,
+		verified,
+		created,
+		updated
+	FROM  user_email
+	WHERE user_id = $1 AND is_primary = true
+	`
+
+	e := dbEmail{}
+
+	err := dbx.GetContext(ctx, &e, query, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching email found"}
+		default:
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
 func getEmailByValidToken(ctx context.Context, dbx DBTX, hash []byte, scope string) (*dbEmail, error) {
 	query := `
 	SELECT 
@@ -87,11 +115,11 @@ func getEmailByValidToken(ctx context.Context, dbx DBTX, hash []byte, scope stri
 		created, 
 		updated
 	FROM  user_email
-	WHERE address = (
+	WHERE lower(address) = lower((
 		SELECT payload 
 		FROM   token 
 		WHERE  hash = $1 AND scope = $2 AND revoked = false AND expiry > $3
-	)
+	))
 	`
 
 	e := dbEmail{}
@@ -131,6 +159,8 @@ type dbEmailInsert struct {
 	UserID  int
 	Address string
 	Primary bool
+	// Canonical is the address without its alias parts, see auth.EmailCanonicalizer.
+	Canonical string
 }
 
 func insertEmail(ctx context.Context, dbx DBTX, in dbEmailInsert) error {
@@ -139,15 +169,17 @@ func insertEmail(ctx context.Context, dbx DBTX, in dbEmailInsert) error {
 	(
 		user_id,
 		address,
+		canonical_address,
 		is_primary
 	)
-	VALUES (:user_id, :address, :is_primary)
+	VALUES (:user_id, :address, :canonical_address, :is_primary)
 	`
 
 	e := dbEmail{
-		UserID:  in.UserID,
-		Address: in.Address,
-		Primary: in.Primary,
+		UserID:    in.UserID,
+		Address:   in.Address,
+		Canonical: auth.NewNullString(in.Canonical),
+		Primary:   in.Primary,
 	}
 
 	_, err := dbx.NamedExecContext(ctx, query, e)
@@ -163,6 +195,16 @@ func insertEmail(ctx context.Context, dbx DBTX, in dbEmailInsert) error {
 	return nil
 }
 
+// existsCanonicalEmail returns true if another user has an email with the canonical address.
+func existsCanonicalEmail(ctx context.Context, dbx DBTX, canonical string, uid int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM user_email WHERE canonical_address = $1 AND user_id <> $2)`
+
+	var exists bool
+
+	err := dbx.GetContext(ctx, &exists, query, canonical, uid)
+	return exists, err
+}
+
 type dbEmailUpdate struct {
 	Address  string
 	Primary  bool
@@ -176,7 +218,7 @@ func updateEmail(ctx context.Context, dbx DBTX, up dbEmailUpdate) error {
 		is_primary = :is_primary,
 		verified   = :verified
 	WHERE
-		address = :address
+		lower(address) = lower(:address)
 	`
 
 	e := dbEmail{
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/jackc/pgtype"
+)
+
+// CreateEmailDomainRule allows or denies the emails of a domain, the kind of an existing rule is replaced.
+func (s *authService) CreateEmailDomainRule(ctx context.Context, rule auth.EmailDomainRuleInput) (*auth.EmailDomainRule, error) {
+	rule = rule.Normalize()
+
+	v := s.config.Policy.NewValidator()
+	if rule.Validate(v); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	dr, err := upsertEmailDomainRule(ctx, s.db, rule.Domain, rule.Kind)
+	if err != nil {
+		return nil, err
+	}
+	return toAuthEmailDomainRule(dr), nil
+}
+
+func (s *authService) ListEmailDomainRules(ctx context.Context) ([]auth.EmailDomainRule, error) {
+	drr, err := getEmailDomainRules(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	return toAuthEmailDomainRules(drr), nil
+}
+
+func (s *authService) DeleteEmailDomainRule(ctx context.Context, domain string) error {
+	return deleteEmailDomainRule(ctx, s.db, auth.EmailDomainRuleInput{Domain: domain}.Normalize().Domain)
+}
+
+// checkEmailDomain rejects a new email by the domain rules and the disposable domains.
+// The rule of the most specific domain wins, and an allowed domain skips the disposable domains.
+func (s *authService) checkEmailDomain(ctx context.Context, dbx DBTX, address string) error {
+	domains := auth.DomainHierarchy(auth.EmailDomain(address))
+
+	drr, err := getEmailDomainRulesByDomains(ctx, dbx, domains)
+	if err != nil {
+		return err
+	}
+	kinds := map[string]string{}
+	for _, dr := range drr {
+		kinds[dr.Domain] = dr.Kind
+	}
+
+	msg := s.emailDomainMessage(domains, kinds)
+	if msg == "" {
+		return nil
+	}
+
+	v := s.config.Policy.NewValidator()
+	v.AddError("email", auth.NewMessage(msg))
+	return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+}
+
+// emailDomainMessage returns the message of a rejected domain, or an empty string if it's accepted.
+// The domains are the hierarchy of the email domain, and the kinds are their rules.
+func (s *authService) emailDomainMessage(domains []string, kinds map[string]string) string {
+	for _, d := range domains {
+		if kind, ok := kinds[d]; ok {
+			if kind == auth.DomainAllow {
+				return ""
+			}
+			return auth.MsgEmailDomainDenied
+		}
+	}
+	if s.config.EmailDomainAllowListOnly {
+		return auth.MsgEmailDomainDenied
+	}
+	if s.config.DisposableDomains.Contains(domains[0]) {
+		return auth.MsgDisposableEmail
+	}
+	return ""
+}
+
+//
+// db
+//
+
+type dbEmailDomainRule struct {
+	Domain  string    `db:"domain"`
+	Kind    string    `db:"kind"`
+	Created time.Time `db:"created"`
+}
+
+func getEmailDomainRules(ctx context.Context, dbx DBTX) ([]dbEmailDomainRule, error) {
+	query := `
+	SELECT
+		domain,
+		kind,
+		created
+	FROM     email_domain_rule
+	ORDER BY domain
+	`
+
+	r := []dbEmailDomainRule{}
+
+	err := dbx.SelectContext(ctx, &r, query)
+	return r, err
+}
+
+func getEmailDomainRulesByDomains(ctx context.Context, dbx DBTX, domains []string) ([]dbEmailDomainRule, error) {
+	query := `
+	SELECT
+		domain,
+		kind,
+		created
+	FROM  email_domain_rule
+	WHERE domain = ANY($1)
+	`
+
+	dd := pgtype.TextArray{}
+	if err := dd.Set(domains); err != nil {
+		return nil, err
+	}
+
+	r := []dbEmailDomainRule{}
+
+	err := dbx.SelectContext(ctx, &r, query, dd)
+	return r, err
+}
+
+func upsertEmailDomainRule(ctx context.Context, dbx DBTX, domain, kind string) (*dbEmailDomainRule, error) {
+	query := `
+	INSERT INTO email_domain_rule
+	(
+		domain,
+		kind
+	)
+	VALUES ($1, $2)
+	ON CONFLICT (domain) DO UPDATE SET kind = EXCLUDED.kind
+	RETURNING domain, kind, created
+	`
+
+	r := dbEmailDomainRule{}
+
+	err := dbx.GetContext(ctx, &r, query, domain, kind)
+	return &r, err
+}
+
+func deleteEmailDomainRule(ctx context.Context, dbx DBTX, domain string) error {
+	query := `DELETE FROM email_domain_rule WHERE domain = $1`
+
+	res, err := dbx.ExecContext(ctx, query, domain)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &auth.Error{Code: auth.ENOTFOUND, Message: "no matching domain rule found"}
+	}
+	return nil
+}
+
+//
+// Helpers
+//
+
+func toAuthEmailDomainRule(dr *dbEmailDomainRule) *auth.EmailDomainRule {
+	return &auth.EmailDomainRule{
+		Domain:  dr.Domain,
+		Kind:    dr.Kind,
+		Created: dr.Created,
+	}
+}
+
+func toAuthEmailDomainRules(drr []dbEmailDomainRule) []auth.EmailDomainRule {
+	rr := make([]auth.EmailDomainRule, len(drr))
+	for i, e := range drr {
+		rr[i] = *toAuthEmailDomainRule(&e)
+	}
+	return rr
+}
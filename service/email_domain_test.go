@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/aemdemir/auth"
+)
+
+func TestEmailDomainMessage(t *testing.T) {
+	disposable := auth.DomainList{"mailinator.com": {}}
+
+	tests := []struct {
+		name          string
+		domain        string
+		kinds         map[string]string
+		allowListOnly bool
+		want          string
+	}{
+		{"no rule", "example.com", nil, false, ""},
+		{"denied", "example.com", map[string]string{"example.com": auth.DomainDeny}, false, auth.MsgEmailDomainDenied},
+		{"denied parent", "a.example.com", map[string]string{"example.com": auth.DomainDeny}, false, auth.MsgEmailDomainDenied},
+		{"allowed subdomain of denied", "a.example.com", map[string]string{"example.com": auth.DomainDeny, "a.example.com": auth.DomainAllow}, false, ""},
+		{"denied subdomain of allowed", "a.example.com", map[string]string{"example.com": auth.DomainAllow, "a.example.com": auth.DomainDeny}, false, auth.MsgEmailDomainDenied},
+		{"allow list only", "example.com", nil, true, auth.MsgEmailDomainDenied},
+		{"allow list only allowed", "a.example.com", map[string]string{"example.com": auth.DomainAllow}, true, ""},
+		{"disposable", "mailinator.com", nil, false, auth.MsgDisposableEmail},
+		{"disposable subdomain", "a.mailinator.com", nil, false, auth.MsgDisposableEmail},
+		{"allowed disposable", "mailinator.com", map[string]string{"mailinator.com": auth.DomainAllow}, false, ""},
+		{"denied disposable", "mailinator.com", map[string]string{"mailinator.com": auth.DomainDeny}, false, auth.MsgEmailDomainDenied},
+		// the allow list is checked before the disposable domains.
+		{"allow list only disposable", "mailinator.com", nil, true, auth.MsgEmailDomainDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &authService{config: Config{
+				EmailDomainAllowListOnly: tt.allowListOnly,
+				DisposableDomains:        disposable,
+			}}
+			got := s.emailDomainMessage(auth.DomainHierarchy(tt.domain), tt.kinds)
+			if got != tt.want {
+				t.Errorf("emailDomainMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
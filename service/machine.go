@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/jackc/pgtype"
+)
+
+func (s *authService) CreateMachineClient(ctx context.Context, client auth.MachineClientInput) (*auth.MachineClient, error) {
+	v := s.config.Policy.NewValidator()
+	if client.Validate(v); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(secret))
+
+	err = insertMachineClient(ctx, s.db, dbMachineClientInsert{
+		ID:         id,
+		Name:       client.Name,
+		SecretHash: h[:],
+		Scopes:     client.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := getMachineClient(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	c := toAuthMachineClient(dc)
+	c.Secret = secret
+	return c, nil
+}
+
+func (s *authService) ListMachineClients(ctx context.Context) ([]auth.MachineClient, error) {
+	dcc, err := getMachineClients(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	return toAuthMachineClients(dcc), nil
+}
+
+// DeleteMachineClient deletes the client with its tokens.
+func (s *authService) DeleteMachineClient(ctx context.Context, id string) error {
+	return deleteMachineClient(ctx, s.db, id)
+}
+
+// GetMachine returns the principal of a client credentials token.
+func (s *authService) GetMachine(ctx context.Context, token auth.TokenInput) (*auth.Machine, error) {
+	meta := auth.TokenMachineAccess
+
+	v := s.config.Policy.NewValidator()
+	if token.Validate(v, meta); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	dm, err := getMachineByValidToken(ctx, s.db, token.HashToken())
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		return nil, &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid token"}
+	}
+	return toAuthMachine(dm), nil
+}
+
+// clientCredentials issues an access token to a machine client.
+// The client gets all of its scopes, unless it requests a subset of them.
+func (s *authService) clientCredentials(ctx context.Context, exchange auth.TokenExchangeInput) (*auth.OAuthTokens, error) {
+	if exchange.ClientID == "" {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidClient, Description: "client authentication failed"}
+	}
+
+	dc, err := getMachineClient(ctx, s.db, exchange.ClientID)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidClient, Description: "client authentication failed"}
+	}
+	h := sha256.Sum256([]byte(exchange.ClientSecret))
+	if subtle.ConstantTimeCompare(h[:], dc.SecretHash) != 1 {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidClient, Description: "client authentication failed"}
+	}
+	if !dc.Active {
+		return nil, &auth.OAuthError{Code: auth.OAuthUnauthorizedClient, Description: "this client is deactivated"}
+	}
+
+	client := toAuthMachineClient(dc)
+	scopes := client.Scopes
+	if ss := strings.Fields(exchange.Scope); len(ss) > 0 {
+		if !subset(ss, client.Scopes) {
+			return nil, &auth.OAuthError{Code: auth.OAuthInvalidScope, Description: "the client is not allowed to request the scope"}
+		}
+		scopes = ss
+	}
+
+	tkn, err := auth.TokenMachineAccess.New(0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = deleteExpiredMachineTokens(ctx, tx, client.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = insertMachineToken(ctx, tx, dbMachineTokenInsert{
+		Hash:     tkn.HashToken(),
+		ClientID: client.ID,
+		Scopes:   scopes,
+		Expiry:   tkn.Expiry,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.OAuthTokens{
+		AccessToken: tkn.Text,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(auth.TokenMachineAccess.TTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, tx.Commit()
+}
+
+//
+// db
+//
+
+type dbMachineClient struct {
+	ID         string           `db:"id"`
+	Name       string           `db:"name"`
+	SecretHash []byte           `db:"secret_hash"`
+	Scopes     pgtype.TextArray `db:"scopes"`
+	Active     bool             `db:"active"`
+	Created    time.Time        `db:"created"`
+	Updated    time.Time        `db:"updated"`
+}
+
+func getMachineClient(ctx context.Context, dbx DBTX, id string) (*dbMachineClient, error) {
+	query := `
+	SELECT
+		id,
+		name,
+		secret_hash,
+		scopes,
+		active,
+		created,
+		updated
+	FROM  machine_client
+	WHERE id = $1
+	`
+
+	c := dbMachineClient{}
+
+	err := dbx.GetContext(ctx, &c, query, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching client found"}
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+func getMachineClients(ctx context.Context, dbx DBTX) ([]dbMachineClient, error) {
+	query := `
+	SELECT
+		id,
+		name,
+		secret_hash,
+		scopes,
+		active,
+		created,
+		updated
+	FROM     machine_client
+	ORDER BY created
+	`
+
+	c := []dbMachineClient{}
+
+	err := dbx.SelectContext(ctx, &c, query)
+	return c, err
+}
+
+type dbMachineClientInsert struct {
+	ID         string
+	Name       string
+	SecretHash []byte
+	Scopes     []string
+}
+
+func insertMachineClient(ctx context.Context, dbx DBTX, in dbMachineClientInsert) error {
+	query := `
+	INSERT INTO machine_client
+	(
+		id,
+		name,
+		secret_hash,
+		scopes
+	)
+	VALUES ($1, $2, $3, $4)
+	`
+
+	scopes := pgtype.TextArray{}
+	if err := scopes.Set(in.Scopes); err != nil {
+		return err
+	}
+
+	_, err := dbx.ExecContext(ctx, query, in.ID, in.Name, in.SecretHash, scopes)
+	return err
+}
+
+func deleteMachineClient(ctx context.Context, dbx DBTX, id string) error {
+	query := `DELETE FROM machine_client WHERE id = $1`
+
+	res, err := dbx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &auth.Error{Code: auth.ENOTFOUND, Message: "no matching client found"}
+	}
+	return nil
+}
+
+type dbMachine struct {
+	ClientID string           `db:"client_id"`
+	Name     string           `db:"name"`
+	Scopes   pgtype.TextArray `db:"scopes"`
+}
+
+func getMachineByValidToken(ctx context.Context, dbx DBTX, hash []byte) (*dbMachine, error) {
+	query := `
+	SELECT
+		c.id AS client_id,
+		c.name,
+		t.scopes
+	FROM  machine_client AS c
+	JOIN  machine_token  AS t ON c.id = t.client_id
+	WHERE t.hash = $1 AND t.expiry > $2 AND c.active = true
+	`
+
+	m := dbMachine{}
+
+	err := dbx.GetContext(ctx, &m, query, hash, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching client found"}
+		default:
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+type dbMachineTokenInsert struct {
+	Hash     []byte
+	ClientID string
+	Scopes   []string
+	Expiry   time.Time
+}
+
+func insertMachineToken(ctx context.Context, dbx DBTX, in dbMachineTokenInsert) error {
+	query := `
+	INSERT INTO machine_token
+	(
+		hash,
+		client_id,
+		scopes,
+		expiry
+	)
+	VALUES ($1, $2, $3, $4)
+	`
+
+	scopes := pgtype.TextArray{}
+	if err := scopes.Set(in.Scopes); err != nil {
+		return err
+	}
+
+	_, err := dbx.ExecContext(ctx, query, in.Hash, in.ClientID, scopes, in.Expiry)
+	return err
+}
+
+func deleteExpiredMachineTokens(ctx context.Context, dbx DBTX, clientID string) error {
+	query := `DELETE FROM machine_token WHERE client_id = $1 AND expiry <= $2`
+
+	_, err := dbx.ExecContext(ctx, query, clientID, time.Now())
+	return err
+}
+
+//
+// conversion
+//
+
+func toAuthMachineClient(e *dbMachineClient) *auth.MachineClient {
+	scopes := []string{}
+	_ = e.Scopes.AssignTo(&scopes)
+
+	return &auth.MachineClient{
+		ID:      e.ID,
+		Name:    e.Name,
+		Scopes:  scopes,
+		Active:  e.Active,
+		Created: e.Created,
+		Updated: e.Updated,
+	}
+}
+
+func toAuthMachineClients(ss []dbMachineClient) []auth.MachineClient {
+	rr := make([]auth.MachineClient, len(ss))
+	for i, e := range ss {
+		rr[i] = *toAuthMachineClient(&e)
+	}
+	return rr
+}
+
+func toAuthMachine(e *dbMachine) *auth.Machine {
+	scopes := []string{}
+	_ = e.Scopes.AssignTo(&scopes)
+
+	return &auth.Machine{
+		ClientID: e.ClientID,
+		Name:     e.Name,
+		Scopes:   scopes,
+	}
+}
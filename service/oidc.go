@@ -0,0 +1,761 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/jackc/pgtype"
+)
+
+// codePayload is stored with the authorization code,
+// to check the token request against the authorization request.
+type codePayload struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	Nonce         string `json:"nonce,omitempty"`
+	CodeChallenge string `json:"code_challenge,omitempty"`
+}
+
+// match checks that the code is exchanged by the client it's issued to, with the same redirect uri,
+// and with the verifier of its challenge if it has one.
+func (p codePayload) match(clientID, redirectURI, verifier string) error {
+	if p.ClientID != clientID || p.RedirectURI != redirectURI {
+		return &auth.OAuthError{Code: auth.OAuthInvalidGrant, Description: "invalid code"}
+	}
+	if p.CodeChallenge != "" && !verifyCodeChallenge(p.CodeChallenge, verifier) {
+		return &auth.OAuthError{Code: auth.OAuthInvalidGrant, Description: "invalid code verifier"}
+	}
+	return nil
+}
+
+// accessPayload is stored with the access token.
+type accessPayload struct {
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+func (s *authService) CreateOIDCClient(ctx context.Context, client auth.OIDCClientInput) (*auth.OIDCClient, error) {
+	v := s.config.Policy.NewValidator()
+	if client.Validate(v); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	var secret string
+	var secretHash []byte
+	if !client.Public {
+		secret, err = randomHex(32)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.Sum256([]byte(secret))
+		secretHash = h[:]
+	}
+
+	err = insertOIDCClient(ctx, s.db, dbOIDCClientInsert{
+		ID:           id,
+		Name:         client.Name,
+		SecretHash:   secretHash,
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := getOIDCClient(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	c := toAuthOIDCClient(dc)
+	c.Secret = secret
+	return c, nil
+}
+
+func (s *authService) ListOIDCClients(ctx context.Context) ([]auth.OIDCClient, error) {
+	dcc, err := getOIDCClients(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	return toAuthOIDCClients(dcc), nil
+}
+
+// DeleteOIDCClient deletes the client and revokes its codes and access tokens.
+func (s *authService) DeleteOIDCClient(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteOIDCClient(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := deleteOIDCTokensByClient(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ValidateAuthorization checks the client and the redirect uri of the request.
+// They must be valid before redirecting the user agent anywhere,
+// so they are reported as errors, the rest as error redirects.
+func (s *authService) ValidateAuthorization(ctx context.Context, authz auth.AuthorizeInput) (*auth.Authorization, error) {
+	v := s.config.Policy.NewValidator()
+	if authz.Validate(v); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	dc, err := getOIDCClient(ctx, s.db, authz.ClientID)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "unknown client"}
+	}
+	client := toAuthOIDCClient(dc)
+	if !contains(client.RedirectURIs, authz.RedirectURI) {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid redirect uri"}
+	}
+
+	a := &auth.Authorization{
+		Client: *client,
+		Scopes: authz.Scopes(),
+	}
+	switch {
+	case authz.ResponseType != "code":
+		a.RedirectTo = errorRedirect(authz, auth.OAuthUnsupportedResponse, "only the code response type is supported")
+	case !subset(a.Scopes, client.Scopes):
+		a.RedirectTo = errorRedirect(authz, auth.OAuthInvalidScope, "the client is not allowed to request the scope")
+	case client.Public && authz.CodeChallenge == "":
+		a.RedirectTo = errorRedirect(authz, auth.OAuthInvalidRequest, "public clients must use pkce")
+	}
+	return a, nil
+}
+
+// Authorize completes the authorization request of the signed in user,
+// if the user has already consented to the requested scopes.
+func (s *authService) Authorize(ctx context.Context, uid int, authz auth.AuthorizeInput) (*auth.Authorization, error) {
+	a, err := s.ValidateAuthorization(ctx, authz)
+	if err != nil || a.RedirectTo != "" {
+		return a, err
+	}
+
+	du, err := getUser(ctx, s.db, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !du.Active {
+		return nil, &auth.Error{Code: auth.EFORBIDDEN, Message: "this user is deactivated"}
+	}
+
+	consented := false
+	if authz.Prompt != "consent" {
+		dc, err := getOIDCConsent(ctx, s.db, uid, authz.ClientID)
+		if err != nil && auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		if err == nil {
+			scopes := []string{}
+			_ = dc.Scopes.AssignTo(&scopes)
+			consented = subset(a.Scopes, scopes)
+		}
+	}
+	if !consented {
+		if authz.Prompt == "none" {
+			a.RedirectTo = errorRedirect(authz, auth.OAuthConsentRequired, "the user must consent to the requested scopes")
+			return a, nil
+		}
+		a.ConsentRequired = true
+		return a, nil
+	}
+
+	a.RedirectTo, err = s.issueCode(ctx, s.db, uid, authz)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GrantConsent records the consent of the user and completes the authorization request.
+// If the user denies it, the request completes with the access_denied error.
+func (s *authService) GrantConsent(ctx context.Context, uid int, consent auth.ConsentInput) (*auth.Authorization, error) {
+	authz := consent.AuthorizeInput
+
+	a, err := s.ValidateAuthorization(ctx, authz)
+	if err != nil || a.RedirectTo != "" {
+		return a, err
+	}
+	if !consent.Approve {
+		a.RedirectTo = errorRedirect(authz, auth.OAuthAccessDenied, "the user denied the request")
+		return a, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	scopes := a.Scopes
+	if dc, err := getOIDCConsent(ctx, tx, uid, authz.ClientID); err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+	} else {
+		granted := []string{}
+		_ = dc.Scopes.AssignTo(&granted)
+		for _, sc := range granted {
+			if !contains(scopes, sc) {
+				scopes = append(scopes, sc)
+			}
+		}
+	}
+	err = upsertOIDCConsent(ctx, tx, uid, authz.ClientID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	a.RedirectTo, err = s.issueCode(ctx, tx, uid, authz)
+	if err != nil {
+		return nil, err
+	}
+	return a, tx.Commit()
+}
+
+// ExchangeToken issues tokens for the authorization code and the client credentials grants.
+// Errors are reported as *auth.OAuthError, as required by the token endpoint.
+func (s *authService) ExchangeToken(ctx context.Context, exchange auth.TokenExchangeInput) (*auth.OAuthTokens, error) {
+	switch exchange.GrantType {
+	case "authorization_code":
+		return s.authorizationCode(ctx, exchange)
+	case "client_credentials":
+		return s.clientCredentials(ctx, exchange)
+	default:
+		return nil, &auth.OAuthError{Code: auth.OAuthUnsupportedGrantType, Description: "unsupported grant type"}
+	}
+}
+
+// authorizationCode exchanges an authorization code for an access token and an ID token.
+func (s *authService) authorizationCode(ctx context.Context, exchange auth.TokenExchangeInput) (*auth.OAuthTokens, error) {
+	if s.config.OIDC.SigningKey == nil {
+		return nil, errors.New("oidc signing key is not configured")
+	}
+
+	client, err := s.authenticateClient(ctx, exchange.ClientID, exchange.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if exchange.Code == "" {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidRequest, Description: "code is required"}
+	}
+
+	// codes are single use, they are consumed even if the request is invalid.
+	hash := auth.TokenInput{Text: exchange.Code}.HashToken()
+	dt, err := consumeToken(ctx, s.db, hash, auth.TokenOIDCCode.Scope)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidGrant, Description: "invalid code"}
+	}
+	if dt.Revoked || dt.Expiry.Before(time.Now()) {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidGrant, Description: "invalid code"}
+	}
+
+	p := codePayload{}
+	if err := json.Unmarshal([]byte(dt.Payload.String), &p); err != nil {
+		return nil, err
+	}
+	if err := p.match(client.ID, exchange.RedirectURI, exchange.CodeVerifier); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	du, err := getUser(ctx, tx, dt.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !du.Active {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidGrant, Description: "this user is deactivated"}
+	}
+
+	ap, err := json.Marshal(accessPayload{ClientID: client.ID, Scope: p.Scope})
+	if err != nil {
+		return nil, err
+	}
+	tkn, err := auth.TokenOIDCAccess.New(du.ID, string(ap))
+	if err != nil {
+		return nil, err
+	}
+	err = insertToken(ctx, tx, dbTokenInsert{
+		UserID:  tkn.UserID,
+		Hash:    tkn.HashToken(),
+		Scope:   tkn.Scope,
+		Expiry:  tkn.Expiry,
+		Payload: tkn.Payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := userInfo(ctx, tx, du, strings.Fields(p.Scope))
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := s.signIDToken(*info, client.ID, p.Nonce, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.OAuthTokens{
+		AccessToken: tkn.Text,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(auth.TokenOIDCAccess.TTL.Seconds()),
+		Scope:       p.Scope,
+		IDToken:     idToken,
+	}, tx.Commit()
+}
+
+// GetUserInfo returns the claims of the user of the access token, based on its scopes.
+func (s *authService) GetUserInfo(ctx context.Context, token auth.TokenInput) (*auth.UserInfo, error) {
+	meta := auth.TokenOIDCAccess
+
+	v := s.config.Policy.NewValidator()
+	if token.Validate(v, meta); !v.Valid() {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidToken, Description: "invalid token"}
+	}
+
+	dt, err := getToken(ctx, s.db, token.HashToken(), meta.Scope)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidToken, Description: "invalid token"}
+	}
+	if dt.Revoked || dt.Expiry.Before(time.Now()) {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidToken, Description: "invalid token"}
+	}
+
+	p := accessPayload{}
+	if err := json.Unmarshal([]byte(dt.Payload.String), &p); err != nil {
+		return nil, err
+	}
+
+	du, err := getUser(ctx, s.db, dt.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !du.Active {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidToken, Description: "this user is deactivated"}
+	}
+	return userInfo(ctx, s.db, du, strings.Fields(p.Scope))
+}
+
+func (s *authService) OpenIDConfiguration() auth.OpenIDConfiguration {
+	issuer := strings.TrimSuffix(s.config.OIDC.Issuer, "/")
+	return auth.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth2/authorize",
+		TokenEndpoint:                     issuer + "/oauth2/token",
+		UserInfoEndpoint:                  issuer + "/oauth2/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		ScopesSupported:                   auth.OIDCScopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "name", "preferred_username", "locale", "email", "email_verified"},
+	}
+}
+
+func (s *authService) JWKS() auth.JWKS {
+	if s.config.OIDC.SigningKey == nil {
+		return auth.JWKS{Keys: []auth.JWK{}}
+	}
+	return auth.JWKS{Keys: []auth.JWK{auth.NewRSAJWK(&s.config.OIDC.SigningKey.PublicKey, s.config.OIDC.KeyID)}}
+}
+
+// signIDToken returns the ID token of the user for the client, it expires after IDTokenTTL.
+func (s *authService) signIDToken(info auth.UserInfo, clientID, nonce string, now time.Time) (string, error) {
+	return auth.SignJWT(s.config.OIDC.SigningKey, s.config.OIDC.KeyID, auth.IDTokenClaims{
+		UserInfo: info,
+		Issuer:   s.config.OIDC.Issuer,
+		Audience: clientID,
+		Expiry:   now.Add(s.config.OIDC.IDTokenTTL).Unix(),
+		IssuedAt: now.Unix(),
+		Nonce:    nonce,
+	})
+}
+
+// issueCode stores a new authorization code and returns the redirect uri with it.
+func (s *authService) issueCode(ctx context.Context, dbx DBTX, uid int, authz auth.AuthorizeInput) (string, error) {
+	payload, err := json.Marshal(codePayload{
+		ClientID:      authz.ClientID,
+		RedirectURI:   authz.RedirectURI,
+		Scope:         strings.Join(authz.Scopes(), " "),
+		Nonce:         authz.Nonce,
+		CodeChallenge: authz.CodeChallenge,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	tkn, err := auth.TokenOIDCCode.New(uid, string(payload))
+	if err != nil {
+		return "", err
+	}
+	err = insertToken(ctx, dbx, dbTokenInsert{
+		UserID:  tkn.UserID,
+		Hash:    tkn.HashToken(),
+		Scope:   tkn.Scope,
+		Expiry:  tkn.Expiry,
+		Payload: tkn.Payload,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("code", tkn.Text)
+	if authz.State != "" {
+		params.Set("state", authz.State)
+	}
+	return redirectWith(authz.RedirectURI, params), nil
+}
+
+// authenticateClient checks the credentials of a confidential client.
+// Public clients are authenticated by their id only, they prove the possession of the code with pkce.
+func (s *authService) authenticateClient(ctx context.Context, id, secret string) (*auth.OIDCClient, error) {
+	if id == "" {
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidClient, Description: "client authentication failed"}
+	}
+
+	dc, err := getOIDCClient(ctx, s.db, id)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return nil, err
+		}
+		return nil, &auth.OAuthError{Code: auth.OAuthInvalidClient, Description: "client authentication failed"}
+	}
+
+	if dc.SecretHash == nil {
+		if secret != "" {
+			return nil, &auth.OAuthError{Code: auth.OAuthInvalidClient, Description: "client authentication failed"}
+		}
+	} else {
+		h := sha256.Sum256([]byte(secret))
+		if subtle.ConstantTimeCompare(h[:], dc.SecretHash) != 1 {
+			return nil, &auth.OAuthError{Code: auth.OAuthInvalidClient, Description: "client authentication failed"}
+		}
+	}
+	return toAuthOIDCClient(dc), nil
+}
+
+// userInfo returns the claims of the user allowed by the scopes.
+func userInfo(ctx context.Context, dbx DBTX, du *dbUser, scopes []string) (*auth.UserInfo, error) {
+	info := &auth.UserInfo{Subject: strconv.Itoa(du.ID)}
+	if contains(scopes, auth.ScopeProfile) {
+		info.Name = du.Name.String
+		info.PreferredUsername = du.Username
+		info.Locale = du.Locale.String
+	}
+	if contains(scopes, auth.ScopeEmail) {
+		dee, err := getEmailsByUser(ctx, dbx, du.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, de := range dee {
+			if de.Primary {
+				verified := de.Verified
+				info.Email = de.Address
+				info.EmailVerified = &verified
+			}
+		}
+	}
+	return info, nil
+}
+
+//
+// db
+//
+
+type dbOIDCClient struct {
+	ID           string           `db:"id"`
+	Name         string           `db:"name"`
+	SecretHash   []byte           `db:"secret_hash"`
+	RedirectURIs pgtype.TextArray `db:"redirect_uris"`
+	Scopes       pgtype.TextArray `db:"scopes"`
+	Created      time.Time        `db:"created"`
+	Updated      time.Time        `db:"updated"`
+}
+
+func getOIDCClient(ctx context.Context, dbx DBTX, id string) (*dbOIDCClient, error) {
+	query := `
+	SELECT
+		id,
+		name,
+		secret_hash,
+		redirect_uris,
+		scopes,
+		created,
+		updated
+	FROM  oidc_client
+	WHERE id = $1
+	`
+
+	c := dbOIDCClient{}
+
+	err := dbx.GetContext(ctx, &c, query, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching client found"}
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+func getOIDCClients(ctx context.Context, dbx DBTX) ([]dbOIDCClient, error) {
+	query := `
+	SELECT
+		id,
+		name,
+		secret_hash,
+		redirect_uris,
+		scopes,
+		created,
+		updated
+	FROM     oidc_client
+	ORDER BY created
+	`
+
+	c := []dbOIDCClient{}
+
+	err := dbx.SelectContext(ctx, &c, query)
+	return c, err
+}
+
+type dbOIDCClientInsert struct {
+	ID           string
+	Name         string
+	SecretHash   []byte
+	RedirectURIs []string
+	Scopes       []string
+}
+
+func insertOIDCClient(ctx context.Context, dbx DBTX, in dbOIDCClientInsert) error {
+	query := `
+	INSERT INTO oidc_client
+	(
+		id,
+		name,
+		secret_hash,
+		redirect_uris,
+		scopes
+	)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+
+	uris := pgtype.TextArray{}
+	if err := uris.Set(in.RedirectURIs); err != nil {
+		return err
+	}
+	scopes := pgtype.TextArray{}
+	if err := scopes.Set(in.Scopes); err != nil {
+		return err
+	}
+
+	_, err := dbx.ExecContext(ctx, query, in.ID, in.Name, in.SecretHash, uris, scopes)
+	return err
+}
+
+func deleteOIDCClient(ctx context.Context, dbx DBTX, id string) error {
+	query := `DELETE FROM oidc_client WHERE id = $1`
+
+	res, err := dbx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &auth.Error{Code: auth.ENOTFOUND, Message: "no matching client found"}
+	}
+	return nil
+}
+
+// deleteOIDCTokensByClient deletes the codes and the access tokens issued to the client.
+func deleteOIDCTokensByClient(ctx context.Context, dbx DBTX, clientID string) error {
+	// the payloads of the other scopes may not be json, so they must not be cast.
+	query := `
+	DELETE FROM token
+	WHERE  CASE WHEN scope IN ($1, $2) THEN payload::jsonb ->> 'client_id' = $3 ELSE false END
+	`
+
+	_, err := dbx.ExecContext(ctx, query, auth.TokenOIDCCode.Scope, auth.TokenOIDCAccess.Scope, clientID)
+	return err
+}
+
+type dbOIDCConsent struct {
+	UserID   int              `db:"user_id"`
+	ClientID string           `db:"client_id"`
+	Scopes   pgtype.TextArray `db:"scopes"`
+	Created  time.Time        `db:"created"`
+	Updated  time.Time        `db:"updated"`
+}
+
+func getOIDCConsent(ctx context.Context, dbx DBTX, userID int, clientID string) (*dbOIDCConsent, error) {
+	query := `
+	SELECT
+		user_id,
+		client_id,
+		scopes,
+		created,
+		updated
+	FROM  oidc_consent
+	WHERE user_id = $1 AND client_id = $2
+	`
+
+	c := dbOIDCConsent{}
+
+	err := dbx.GetContext(ctx, &c, query, userID, clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching consent found"}
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+func upsertOIDCConsent(ctx context.Context, dbx DBTX, userID int, clientID string, scopes []string) error {
+	query := `
+	INSERT INTO oidc_consent
+	(
+		user_id,
+		client_id,
+		scopes
+	)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes
+	`
+
+	ss := pgtype.TextArray{}
+	if err := ss.Set(scopes); err != nil {
+		return err
+	}
+
+	_, err := dbx.ExecContext(ctx, query, userID, clientID, ss)
+	return err
+}
+
+//
+// conversion
+//
+
+func toAuthOIDCClient(e *dbOIDCClient) *auth.OIDCClient {
+	uris := []string{}
+	_ = e.RedirectURIs.AssignTo(&uris)
+	scopes := []string{}
+	_ = e.Scopes.AssignTo(&scopes)
+
+	return &auth.OIDCClient{
+		ID:           e.ID,
+		Name:         e.Name,
+		RedirectURIs: uris,
+		Scopes:       scopes,
+		Public:       e.SecretHash == nil,
+		Created:      e.Created,
+	}
+}
+
+func toAuthOIDCClients(ss []dbOIDCClient) []auth.OIDCClient {
+	rr := make([]auth.OIDCClient, len(ss))
+	for i, e := range ss {
+		rr[i] = *toAuthOIDCClient(&e)
+	}
+	return rr
+}
+
+//
+// Helpers
+//
+
+// verifyCodeChallenge checks the pkce verifier against the S256 challenge.
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+	h := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(h[:])), []byte(challenge)) == 1
+}
+
+// errorRedirect returns the redirect uri of the request with the oauth error.
+func errorRedirect(authz auth.AuthorizeInput, code, description string) string {
+	params := url.Values{}
+	params.Set("error", code)
+	params.Set("error_description", description)
+	if authz.State != "" {
+		params.Set("state", authz.State)
+	}
+	return redirectWith(authz.RedirectURI, params)
+}
+
+// redirectWith adds the params to the query of the uri.
+func redirectWith(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	for k, vv := range params {
+		q[k] = vv
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// subset returns true if all elements of ss are in list.
+func subset(ss, list []string) bool {
+	for _, s := range ss {
+		if !contains(list, s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/aemdemir/auth"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// RFC 7636, appendix B.
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{"match", challenge, verifier, true},
+		{"wrong verifier", challenge, verifier + "x", false},
+		{"empty verifier", challenge, "", false},
+		{"verifier as challenge", verifier, verifier, false},
+		{"padded challenge", challenge + "=", verifier, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.challenge, tt.verifier); got != tt.want {
+				t.Errorf("verifyCodeChallenge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodePayloadMatch(t *testing.T) {
+	p := codePayload{
+		ClientID:      "app",
+		RedirectURI:   "https://app.example.com/callback",
+		CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+	}
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	tests := []struct {
+		name        string
+		payload     codePayload
+		clientID    string
+		redirectURI string
+		verifier    string
+		wantErr     bool
+	}{
+		{"match", p, p.ClientID, p.RedirectURI, verifier, false},
+		{"other client", p, "other", p.RedirectURI, verifier, true},
+		{"other redirect uri", p, p.ClientID, "https://evil.example.com/callback", verifier, true},
+		{"missing verifier", p, p.ClientID, p.RedirectURI, "", true},
+		{"no challenge", codePayload{ClientID: "app", RedirectURI: p.RedirectURI}, p.ClientID, p.RedirectURI, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payload.match(tt.clientID, tt.redirectURI, tt.verifier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("match() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if oerr, ok := err.(*auth.OAuthError); !ok || oerr.Code != auth.OAuthInvalidGrant {
+					t.Errorf("match() = %v, want %s", err, auth.OAuthInvalidGrant)
+				}
+			}
+		})
+	}
+}
+
+func TestSignIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &authService{config: Config{OIDC: OIDCConfig{
+		Issuer:     "https://auth.example.com",
+		SigningKey: key,
+		KeyID:      "key-1",
+		IDTokenTTL: time.Hour,
+	}}}
+
+	verified := true
+	info := auth.UserInfo{Subject: "42", Email: "jane@example.com", EmailVerified: &verified}
+	now := time.Unix(1700000000, 0)
+
+	token, err := s.signIDToken(info, "app", "nonce-1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims auth.IDTokenClaims
+	h, err := auth.ParseJWT(token, func(h auth.JWTHeader) (crypto.PublicKey, error) {
+		return &key.PublicKey, nil
+	}, &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Alg != "RS256" || h.Kid != "key-1" {
+		t.Errorf("header = %+v", h)
+	}
+	if claims.Issuer != "https://auth.example.com" {
+		t.Errorf("iss = %q", claims.Issuer)
+	}
+	if claims.Audience != "app" {
+		t.Errorf("aud = %q", claims.Audience)
+	}
+	if claims.Nonce != "nonce-1" {
+		t.Errorf("nonce = %q", claims.Nonce)
+	}
+	if claims.IssuedAt != now.Unix() || claims.Expiry != now.Add(time.Hour).Unix() {
+		t.Errorf("iat = %d, exp = %d", claims.IssuedAt, claims.Expiry)
+	}
+	if claims.Subject != "42" || claims.Email != "jane@example.com" || claims.EmailVerified == nil || !*claims.EmailVerified {
+		t.Errorf("user info = %+v", claims.UserInfo)
+	}
+
+	// a token of another key isn't verified.
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = auth.ParseJWT(token, func(h auth.JWTHeader) (crypto.PublicKey, error) {
+		return &other.PublicKey, nil
+	}, &claims)
+	if err != auth.ErrJWTSignature {
+		t.Errorf("ParseJWT() with another key = %v, want %v", err, auth.ErrJWTSignature)
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aemdemir/auth"
+)
+
+// email kinds.
+const (
+	emailVerification  = "email_verification"
+	emailPasswordReset = "password_reset"
+	emailAccountLink   = "account_link"
+	emailPasswordSetup = "password_setup"
+	emailConfirmation  = "confirmation"
+)
+
+// outbox statuses.
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+)
+
+// outboxData is the data needed to render an email.
+type outboxData struct {
+	Token  string `json:"token"`
+	Name   string `json:"name,omitempty"`
+	Locale string `json:"locale,omitempty"`
+	// Provider is the provider of the account to link.
+	Provider string `json:"provider,omitempty"`
+}
+
+// enqueueEmail writes the email to the outbox.
+// Pass the same transaction as the change that triggers the email,
+// so that the email is sent if and only if the change is committed.
+func enqueueEmail(ctx context.Context, dbx DBTX, kind, recipient string, data outboxData) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return insertOutboxEmail(ctx, dbx, dbOutboxEmailInsert{
+		Kind:      kind,
+		Recipient: recipient,
+		Data:      b,
+	})
+}
+
+// sendEmail sends the outbox email with the mailer based on its kind.
+func sendEmail(m Mailer, e *dbOutboxEmail) error {
+	var data outboxData
+	if err := json.Unmarshal(e.Data, &data); err != nil {
+		return err
+	}
+
+	to := auth.Recipient{
+		Address: e.Recipient,
+		Name:    data.Name,
+		Locale:  data.Locale,
+	}
+
+	switch e.Kind {
+	case emailVerification:
+		return m.SendVerificationEmail(to, data.Token)
+	case emailPasswordReset:
+		return m.SendPasswordResetEmail(to, data.Token)
+	case emailAccountLink:
+		return m.SendAccountLinkEmail(to, data.Token, data.Provider)
+	case emailPasswordSetup:
+		return m.SendPasswordSetupEmail(to, data.Token)
+	case emailConfirmation:
+		return m.SendConfirmationEmail(to, data.Token)
+	default:
+		return fmt.Errorf("unknown email kind %q", e.Kind)
+	}
+}
+
+//
+// db
+//
+
+type dbOutboxEmail struct {
+	ID          int             `db:"id"`
+	Kind        string          `db:"kind"`
+	Recipient   string          `db:"recipient"`
+	Data        []byte          `db:"data"`
+	Status      string          `db:"status"`
+	Attempts    int             `db:"attempts"`
+	NextAttempt time.Time       `db:"next_attempt"`
+	LastError   auth.NullString `db:"last_error"`
+	Created     time.Time       `db:"created"`
+	Updated     time.Time       `db:"updated"`
+}
+
+// claimOutboxEmail leases a due email until the given time, so that other workers skip it.
+func claimOutboxEmail(ctx context.Context, tx *Tx, leaseUntil time.Time) (*dbOutboxEmail, error) {
+	query := `
+	UPDATE email_outbox
+	SET    next_attempt = $2
+	WHERE  id IN (
+		SELECT   id
+		FROM     email_outbox
+		WHERE    status = 'pending' AND next_attempt <= $1
+		ORDER BY next_attempt
+		LIMIT    1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING
+		id,
+		kind,
+		recipient,
+		data,
+		status,
+		attempts,
+		next_attempt,
+		last_error,
+		created,
+		updated
+	`
+
+	e := []dbOutboxEmail{}
+
+	err := tx.SelectContext(ctx, &e, query, time.Now(), leaseUntil)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 {
+		return nil, nil
+	}
+	return &e[0], nil
+}
+
+type dbOutboxEmailInsert struct {
+	Kind      string
+	Recipient string
+	Data      []byte
+}
+
+func insertOutboxEmail(ctx context.Context, dbx DBTX, in dbOutboxEmailInsert) error {
+	query := `
+	INSERT INTO email_outbox
+	(
+		kind,
+		recipient,
+		data
+	)
+	VALUES (:kind, :recipient, :data)
+	`
+
+	e := dbOutboxEmail{
+		Kind:      in.Kind,
+		Recipient: in.Recipient,
+		Data:      in.Data,
+	}
+
+	_, err := dbx.NamedExecContext(ctx, query, e)
+	return err
+}
+
+type dbOutboxEmailUpdate struct {
+	ID          int
+	Status      string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   auth.NullString
+}
+
+func updateOutboxEmail(ctx context.Context, dbx DBTX, up dbOutboxEmailUpdate) error {
+	// the data contains the plain token, which is needed to render the email while it's retried.
+	// so clear it once the email is sent or dead-lettered, a dead-lettered email can't be sent again.
+	query := `
+	UPDATE email_outbox
+	SET
+		status       = :status,
+		attempts     = :attempts,
+		next_attempt = :next_attempt,
+		last_error   = :last_error,
+		data         = CASE WHEN :status IN ('sent', 'dead') THEN '{}'::jsonb ELSE data END
+	WHERE
+		id = :id
+	`
+
+	e := dbOutboxEmail{
+		ID:          up.ID,
+		Status:      up.Status,
+		Attempts:    up.Attempts,
+		NextAttempt: up.NextAttempt,
+		LastError:   up.LastError,
+	}
+
+	_, err := dbx.NamedExecContext(ctx, query, e)
+	return err
+}
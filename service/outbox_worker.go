@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/rs/zerolog"
+)
+
+type OutboxWorkerConfig struct {
+	// Workers is the number of emails sent concurrently.
+	Workers int
+	// PollInterval is the wait time between outbox checks when the outbox is empty.
+	PollInterval time.Duration
+	// MaxAttempts is the number of tries before an email is dead-lettered.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential backoff between tries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease is how long a claimed email is skipped by the other workers while it's sent.
+	Lease time.Duration
+	// DrainTimeout is how long the due emails are still sent after shutdown is requested.
+	DrainTimeout time.Duration
+}
+
+var DefaultOutboxWorkerConfig = OutboxWorkerConfig{
+	Workers:      4,
+	PollInterval: 2 * time.Second,
+	MaxAttempts:  10,
+	MinBackoff:   10 * time.Second,
+	MaxBackoff:   1 * time.Hour,
+	Lease:        5 * time.Minute,
+	DrainTimeout: 10 * time.Second,
+}
+
+// outboxRecordTimeout bounds the update of the result of a sent email.
+const outboxRecordTimeout = 5 * time.Second
+
+// OutboxWorker sends the emails written to the outbox.
+type OutboxWorker struct {
+	db     *DB
+	logger zerolog.Logger
+	mailer Mailer
+	config OutboxWorkerConfig
+}
+
+func NewOutboxWorker(db *DB, logger zerolog.Logger, mailer Mailer, config OutboxWorkerConfig) *OutboxWorker {
+	return &OutboxWorker{
+		db:     db,
+		logger: logger,
+		mailer: mailer,
+		config: config,
+	}
+}
+
+// Run sends the outbox emails until ctx is done.
+// Then, it drains the due emails for at most DrainTimeout and returns
+// once all the workers are stopped.
+func (o *OutboxWorker) Run(ctx context.Context) {
+	// in-flight emails must not be interrupted by the shutdown,
+	// so the workers use their own context which is cancelled after draining.
+	wctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < o.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.work(ctx, wctx)
+		}()
+	}
+
+	<-ctx.Done()
+	o.logger.Info().Msg("draining email outbox")
+
+	timer := time.AfterFunc(o.config.DrainTimeout, cancel)
+	defer timer.Stop()
+
+	wg.Wait()
+}
+
+// work sends the emails one by one, waiting for new ones while ctx is not done.
+// After ctx is done, it keeps sending until the outbox is drained or wctx is done.
+func (o *OutboxWorker) work(ctx, wctx context.Context) {
+	for {
+		if wctx.Err() != nil {
+			return
+		}
+
+		ok, err := o.safeProcess(wctx)
+		if err != nil {
+			o.logger.Err(err).Msg("failed to process email outbox")
+		}
+		if ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			// drained.
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(o.config.PollInterval):
+		}
+	}
+}
+
+// safeProcess calls process, and recovers from a panic outside of sending,
+// so that it can't stop the worker.
+func (o *OutboxWorker) safeProcess(ctx context.Context) (ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			o.logger.Error().
+				Err(fmt.Errorf("%s", r)).
+				Str("trace", string(debug.Stack())).
+				Msg("panic recovered from email outbox worker")
+			ok, err = false, nil
+		}
+	}()
+	return o.process(ctx)
+}
+
+// process claims and sends a single email,
+// and reports whether there was an email to send.
+//
+// The email is claimed in a short transaction which leases it by moving its next attempt forward,
+// so a slow mail server doesn't hold a connection or a row lock while it's sent.
+// A claimed email is tried again after the lease if the worker stops before recording its result.
+func (o *OutboxWorker) process(ctx context.Context) (bool, error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	de, err := claimOutboxEmail(ctx, tx, time.Now().Add(o.config.Lease))
+	if err != nil {
+		return false, err
+	}
+	if de == nil {
+		return false, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	up := dbOutboxEmailUpdate{
+		ID:          de.ID,
+		Status:      outboxSent,
+		Attempts:    de.Attempts + 1,
+		NextAttempt: de.NextAttempt,
+	}
+	if err := o.send(de); err != nil {
+		up.LastError = auth.NewNullString(err.Error())
+		if up.Attempts >= o.config.MaxAttempts {
+			up.Status = outboxDead
+			o.logger.Error().
+				Err(err).
+				Int("outbox_id", de.ID).
+				Str("kind", de.Kind).
+				Str("recipient", de.Recipient).
+				Msg("email is dead-lettered")
+		} else {
+			up.Status = outboxPending
+			up.NextAttempt = time.Now().Add(backoff(up.Attempts, o.config.MinBackoff, o.config.MaxBackoff))
+			o.logger.Warn().
+				Err(err).
+				Int("outbox_id", de.ID).
+				Str("kind", de.Kind).
+				Int("attempts", up.Attempts).
+				Msg("failed to send email")
+		}
+	}
+
+	// the email may already be sent, so its result is recorded even if the drain timeout cancels ctx.
+	rctx, cancel := context.WithTimeout(context.Background(), outboxRecordTimeout)
+	defer cancel()
+	if err := updateOutboxEmail(rctx, o.db, up); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// send sends the email, a panic of a template or transport is returned as an error,
+// so that it's counted as a failed attempt and the email is dead-lettered after MaxAttempts.
+func (o *OutboxWorker) send(de *dbOutboxEmail) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			o.logger.Error().
+				Err(fmt.Errorf("%s", r)).
+				Int("outbox_id", de.ID).
+				Str("trace", string(debug.Stack())).
+				Msg("panic recovered while sending email")
+			err = fmt.Errorf("panic: %s", r)
+		}
+	}()
+	return sendEmail(o.mailer, de)
+}
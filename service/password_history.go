@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+
+	"github.com/aemdemir/auth"
+)
+
+// MaxPasswordHistory is the deepest password history, the check of a new password takes up to 2.5 seconds then.
+const MaxPasswordHistory = 10
+
+// checkPasswordHistory rejects a new password which is one of the last passwords of the user, the current one included.
+func (s *authService) checkPasswordHistory(ctx context.Context, dbx DBTX, du *dbUser, password string) error {
+	depth := s.config.PasswordHistory
+	if depth == 0 {
+		return nil
+	}
+
+	hashes, err := getPasswordHistory(ctx, dbx, du.ID, depth-1)
+	if err != nil {
+		return err
+	}
+	if len(du.PasswordHash) > 0 {
+		hashes = append([][]byte{du.PasswordHash}, hashes...)
+	}
+
+	v := s.config.Policy.NewValidator()
+	for _, h := range hashes {
+		ok, err := auth.MatchPasswordHash(h, password)
+		if err != nil {
+			return err
+		}
+		if ok {
+			auth.ValidatePasswordReuse(v, true, depth)
+			return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+		}
+	}
+	return nil
+}
+
+// savePasswordHistory keeps the replaced password of the user, and prunes the history to the configured depth.
+func (s *authService) savePasswordHistory(ctx context.Context, tx *Tx, du *dbUser) error {
+	depth := s.config.PasswordHistory
+	if depth == 0 {
+		return nil
+	}
+
+	if len(du.PasswordHash) > 0 {
+		err := insertPasswordHistory(ctx, tx, du.ID, du.PasswordHash)
+		if err != nil {
+			return err
+		}
+	}
+	// the current password is the last one, so the history keeps one less.
+	return prunePasswordHistory(ctx, tx, du.ID, depth-1)
+}
+
+//
+// db
+//
+
+func getPasswordHistory(ctx context.Context, dbx DBTX, uid, limit int) ([][]byte, error) {
+	query := `
+	SELECT   password_hash
+	FROM     user_password_history
+	WHERE    user_id = $1
+	ORDER BY id DESC
+	LIMIT    $2
+	`
+
+	hashes := [][]byte{}
+
+	err := dbx.SelectContext(ctx, &hashes, query, uid, limit)
+	return hashes, err
+}
+
+func insertPasswordHistory(ctx context.Context, dbx DBTX, uid int, hash []byte) error {
+	query := `
+	INSERT INTO user_password_history
+	(
+		user_id,
+		password_hash
+	)
+	VALUES ($1, $2)
+	`
+
+	_, err := dbx.ExecContext(ctx, query, uid, hash)
+	return err
+}
+
+func prunePasswordHistory(ctx context.Context, dbx DBTX, uid, keep int) error {
+	query := `
+	DELETE FROM user_password_history
+	WHERE user_id = $1 AND id NOT IN (
+		SELECT   id
+		FROM     user_password_history
+		WHERE    user_id = $1
+		ORDER BY id DESC
+		LIMIT    $2
+	)
+	`
+
+	_, err := dbx.ExecContext(ctx, query, uid, keep)
+	return err
+}
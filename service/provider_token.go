@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aemdemir/auth"
+)
+
+// ProviderTokensConfig configures the storage of the OAuth tokens of the linked accounts.
+type ProviderTokensConfig struct {
+	// Keys are the 32 byte AES-256 keys which encrypt the tokens at rest, the tokens aren't stored if it's empty.
+	// The first key encrypts, all of them decrypt, so that the keys can be rotated.
+	Keys [][]byte
+	// Source refreshes and revokes the tokens, e.g. the provider.Registry.
+	Source auth.ProviderTokenSource
+}
+
+// providerRefreshTimeout bounds the transaction which holds the lock of the account while its token is refreshed.
+// It's longer than the timeout of the refresh request, so that a refreshed token is always stored.
+const providerRefreshTimeout = 30 * time.Second
+
+var errTokenDecryption = errors.New("provider tokens can't be decrypted with any of the keys")
+
+// tokensPayload is the encrypted content of user_account.encrypted_tokens.
+type tokensPayload struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// GetProviderToken returns a valid access token of the user's account, it's refreshed if it's expired.
+func (s *authService) GetProviderToken(ctx context.Context, uid int, provider string) (*auth.ProviderTokens, error) {
+	v := s.config.Policy.NewValidator()
+	if auth.ValidateProvider(v, s.config.Providers, provider); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	// the account is locked while the token is refreshed, since some providers invalidate the refresh token
+	// once it's used. The concurrent requests of the same account wait for the lock.
+	// The transaction isn't cancelled with the request, otherwise a rotated refresh token could be lost
+	// after the refresh, it's bounded by providerRefreshTimeout instead.
+	tctx, cancel := context.WithTimeout(context.Background(), providerRefreshTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(tctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	da, err := getAccountForUpdate(tctx, tx, uid, provider)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.decryptTokens(da.EncryptedTokens, da.UserID, da.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+	if tokens == nil {
+		return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no provider token found"}
+	}
+	if tokens.Valid() {
+		return tokens, nil
+	}
+
+	if tokens.RefreshToken == "" || s.config.ProviderTokens.Source == nil {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "provider token is expired, the user must sign in again"}
+	}
+	refreshed, err := s.config.ProviderTokens.Source.RefreshToken(ctx, provider, tokens.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("refresh provider token: %w", err)
+	}
+	// the refresh token is kept if the provider doesn't rotate it.
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = tokens.RefreshToken
+	}
+
+	enc, err := s.encryptTokens(*refreshed, da.UserID, da.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+	err = updateAccountTokens(tctx, tx, uid, provider, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return refreshed, tx.Commit()
+}
+
+// saveProviderTokens stores the tokens of the account, if the service keeps the tokens.
+// Providers return the refresh token only on the first consent, so the stored one is kept if there is none.
+func (s *authService) saveProviderTokens(ctx context.Context, tx *Tx, uid int, account auth.AccountInput) error {
+	if len(s.config.ProviderTokens.Keys) == 0 || account.Tokens.AccessToken == "" {
+		return nil
+	}
+
+	tokens := account.Tokens
+	if tokens.RefreshToken == "" {
+		da, err := getAccountForUpdate(ctx, tx, uid, account.ProviderName)
+		if err != nil {
+			return err
+		}
+		// the old tokens may be encrypted with a removed key, they are replaced then.
+		if old, err := s.decryptTokens(da.EncryptedTokens, uid, account.ProviderName); err == nil && old != nil {
+			tokens.RefreshToken = old.RefreshToken
+		}
+	}
+
+	enc, err := s.encryptTokens(tokens, uid, account.ProviderName)
+	if err != nil {
+		return err
+	}
+	return updateAccountTokens(ctx, tx, uid, account.ProviderName, enc)
+}
+
+// revokeProviderTokens revokes the tokens of the deleted accounts at the providers.
+// The accounts are already deleted, so the failures are only logged.
+func (s *authService) revokeProviderTokens(ctx context.Context, daa []dbAccount) {
+	if s.config.ProviderTokens.Source == nil {
+		return
+	}
+
+	for _, da := range daa {
+		tokens, err := s.decryptTokens(da.EncryptedTokens, da.UserID, da.ProviderName)
+		if err != nil {
+			s.logger.Err(err).Int("user_id", da.UserID).Str("provider", da.ProviderName).Msg("failed to decrypt provider tokens")
+			continue
+		}
+		if tokens == nil {
+			continue
+		}
+		err = s.config.ProviderTokens.Source.RevokeToken(ctx, da.ProviderName, *tokens)
+		if err != nil {
+			s.logger.Err(err).Int("user_id", da.UserID).Str("provider", da.ProviderName).Msg("failed to revoke provider tokens")
+		}
+	}
+}
+
+//
+// Helpers
+//
+
+// encryptTokens seals the tokens of the account with the first key.
+func (s *authService) encryptTokens(tokens auth.ProviderTokens, uid int, provider string) ([]byte, error) {
+	keys := s.config.ProviderTokens.Keys
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	plain, err := json.Marshal(tokensPayload{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		Expiry:       tokens.Expiry,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seal(keys, plain, tokensData(uid, provider))
+}
+
+// decryptTokens opens the tokens with the first matching key, it returns nil if there are no tokens.
+func (s *authService) decryptTokens(enc []byte, uid int, provider string) (*auth.ProviderTokens, error) {
+	if len(enc) == 0 {
+		return nil, nil
+	}
+
+	plain, err := open(s.config.ProviderTokens.Keys, enc, tokensData(uid, provider))
+	if err != nil {
+		if errors.Is(err, errDecryption) {
+			return nil, errTokenDecryption
+		}
+		return nil, err
+	}
+
+	p := tokensPayload{}
+	if err := json.Unmarshal(plain, &p); err != nil {
+		return nil, err
+	}
+	return &auth.ProviderTokens{
+		AccessToken:  p.AccessToken,
+		RefreshToken: p.RefreshToken,
+		Expiry:       p.Expiry,
+	}, nil
+}
+
+// tokensData binds the encrypted tokens to the account, so that they can't be copied to another account's row.
+func tokensData(uid int, provider string) []byte {
+	return []byte(fmt.Sprintf("user_account:%d:%s", uid, provider))
+}
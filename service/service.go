@@ -1,23 +1,19 @@
 package service
 
 import (
-	"fmt"
-	"runtime/debug"
-
-	"github.com/rs/zerolog"
+	"math/rand"
+	"time"
 )
 
-// background executes fn in a goroutine.
-func background(logger zerolog.Logger, fn func()) {
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				logger.Error().
-					Err(fmt.Errorf("%s", err)).
-					Str("trace", string(debug.Stack())).
-					Msg("panic recovered from background goroutine")
-			}
-		}()
-		fn()
-	}()
+// backoff returns the wait time before the next try,
+// doubling on each attempt with a random jitter of up to 20%.
+func backoff(attempts int, min, max time.Duration) time.Duration {
+	wait := min
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
 }
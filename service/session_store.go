@@ -0,0 +1,369 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/rs/zerolog"
+)
+
+// SessionStoreConfig configures the SessionStore.
+type SessionStoreConfig struct {
+	// KeyPairs are the authentication and encryption keys of the cookies, as in securecookie.CodecsFromPairs.
+	// The first pair encodes the new cookies, the others still decode the old ones while the keys are rotated.
+	KeyPairs [][]byte
+	// MaxAge is the lifetime of the sessions.
+	MaxAge time.Duration
+	// Secure sets the secure flag of the cookies.
+	Secure bool
+	// CleanupInterval is the wait time between the deletions of the expired sessions.
+	CleanupInterval time.Duration
+}
+
+var DefaultSessionStoreConfig = SessionStoreConfig{
+	MaxAge:          5 * time.Minute,
+	CleanupInterval: 10 * time.Minute,
+}
+
+// SessionStore is a sessions.Store which keeps the session values on the server, the cookie only holds the session id.
+// It keeps the state of the social sign in, e.g. as the gothic.Store, so that the callback works on any instance
+// behind a load balancer and after a restart, as long as the instances share the keys.
+type SessionStore struct {
+	Options *sessions.Options
+	codecs  []securecookie.Codec
+	backend sessionBackend
+	logger  zerolog.Logger
+	config  SessionStoreConfig
+}
+
+// NewSessionStore returns a store which keeps the sessions in the oauth_session table.
+func NewSessionStore(db *DB, logger zerolog.Logger, config SessionStoreConfig) *SessionStore {
+	return newSessionStore(&dbSessionBackend{db: db}, logger, config)
+}
+
+// NewMemorySessionStore returns a store which keeps the sessions in memory, e.g. for tests.
+func NewMemorySessionStore(config SessionStoreConfig) *SessionStore {
+	return newSessionStore(&memorySessionBackend{sessions: map[string]memorySession{}}, zerolog.Nop(), config)
+}
+
+func newSessionStore(backend sessionBackend, logger zerolog.Logger, config SessionStoreConfig) *SessionStore {
+	codecs := securecookie.CodecsFromPairs(config.KeyPairs...)
+	for _, c := range codecs {
+		if sc, ok := c.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(int(config.MaxAge.Seconds()))
+			// the values are stored in the backend, only the id is limited by the cookie size.
+			sc.MaxLength(0)
+		}
+	}
+
+	return &SessionStore{
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   int(config.MaxAge.Seconds()),
+			Secure:   config.Secure,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		codecs:  codecs,
+		backend: backend,
+		logger:  logger,
+		config:  config,
+	}
+}
+
+// Get returns the session of the request, it's cached in the request's registry.
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session of the cookie. A new session is returned if the cookie is missing,
+// it can't be decoded or the session is expired.
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	data, err := s.backend.load(r.Context(), id)
+	if err != nil {
+		if auth.ErrorCode(err) == auth.ENOTFOUND {
+			return session, nil
+		}
+		return session, err
+	}
+	if err := securecookie.DecodeMulti(name, data, &session.Values, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session and sets its cookie, the session is deleted if its MaxAge is negative.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.delete(r.Context(), session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := randomHex(32)
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+	ttl := s.config.MaxAge
+	if session.Options.MaxAge > 0 {
+		ttl = time.Duration(session.Options.MaxAge) * time.Second
+	}
+	err = s.backend.save(r.Context(), session.ID, data, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// SaveState keeps the session cookies of a social sign in started with mode=json until the sessions expire,
+// it implements auth.OAuthStateStore.
+func (s *SessionStore) SaveState(ctx context.Context, state, cookies string) error {
+	return s.backend.save(ctx, stateID(state), cookies, time.Now().Add(s.config.MaxAge))
+}
+
+// TakeState returns and deletes the session cookies of the state, so that it's used once.
+func (s *SessionStore) TakeState(ctx context.Context, state string) (string, error) {
+	return s.backend.take(ctx, stateID(state))
+}
+
+// Run deletes the expired sessions every CleanupInterval until ctx is done.
+func (s *SessionStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.backend.deleteExpired(ctx)
+			if err != nil {
+				s.logger.Err(err).Msg("failed to delete expired sessions")
+				continue
+			}
+			if n > 0 {
+				s.logger.Debug().Int64("count", n).Msg("expired sessions are deleted")
+			}
+		}
+	}
+}
+
+// sessionBackend keeps the encoded session values.
+type sessionBackend interface {
+	load(ctx context.Context, id string) (string, error)
+	// take loads and deletes the session at once.
+	take(ctx context.Context, id string) (string, error)
+	save(ctx context.Context, id, data string, expiry time.Time) error
+	delete(ctx context.Context, id string) error
+	deleteExpired(ctx context.Context) (int64, error)
+}
+
+type dbSessionBackend struct {
+	db *DB
+}
+
+func (b *dbSessionBackend) load(ctx context.Context, id string) (string, error) {
+	return getOAuthSession(ctx, b.db, id)
+}
+
+func (b *dbSessionBackend) take(ctx context.Context, id string) (string, error) {
+	return takeOAuthSession(ctx, b.db, id)
+}
+
+func (b *dbSessionBackend) save(ctx context.Context, id, data string, expiry time.Time) error {
+	return upsertOAuthSession(ctx, b.db, id, data, expiry)
+}
+
+func (b *dbSessionBackend) delete(ctx context.Context, id string) error {
+	return deleteOAuthSession(ctx, b.db, id)
+}
+
+func (b *dbSessionBackend) deleteExpired(ctx context.Context) (int64, error) {
+	return deleteExpiredOAuthSessions(ctx, b.db)
+}
+
+type memorySession struct {
+	data   string
+	expiry time.Time
+}
+
+type memorySessionBackend struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+func (b *memorySessionBackend) load(ctx context.Context, id string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ms, ok := b.sessions[id]
+	if !ok || !ms.expiry.After(time.Now()) {
+		return "", &auth.Error{Code: auth.ENOTFOUND, Message: "no matching session found"}
+	}
+	return ms.data, nil
+}
+
+func (b *memorySessionBackend) take(ctx context.Context, id string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ms, ok := b.sessions[id]
+	delete(b.sessions, id)
+	if !ok || !ms.expiry.After(time.Now()) {
+		return "", &auth.Error{Code: auth.ENOTFOUND, Message: "no matching session found"}
+	}
+	return ms.data, nil
+}
+
+func (b *memorySessionBackend) save(ctx context.Context, id, data string, expiry time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sessions[id] = memorySession{data: data, expiry: expiry}
+	return nil
+}
+
+func (b *memorySessionBackend) delete(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.sessions, id)
+	return nil
+}
+
+func (b *memorySessionBackend) deleteExpired(ctx context.Context) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var n int64
+	now := time.Now()
+	for id, ms := range b.sessions {
+		if !ms.expiry.After(now) {
+			delete(b.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+//
+// db
+//
+
+func getOAuthSession(ctx context.Context, dbx DBTX, id string) (string, error) {
+	query := `SELECT data FROM oauth_session WHERE id = $1 AND expiry > $2`
+
+	var data string
+
+	err := dbx.GetContext(ctx, &data, query, id, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", &auth.Error{Code: auth.ENOTFOUND, Message: "no matching session found"}
+		default:
+			return "", err
+		}
+	}
+	return data, nil
+}
+
+func takeOAuthSession(ctx context.Context, dbx DBTX, id string) (string, error) {
+	query := `DELETE FROM oauth_session WHERE id = $1 AND expiry > $2 RETURNING data`
+
+	var data string
+
+	err := dbx.GetContext(ctx, &data, query, id, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", &auth.Error{Code: auth.ENOTFOUND, Message: "no matching session found"}
+		default:
+			return "", err
+		}
+	}
+	return data, nil
+}
+
+func upsertOAuthSession(ctx context.Context, dbx DBTX, id, data string, expiry time.Time) error {
+	query := `
+	INSERT INTO oauth_session
+	(
+		id,
+		data,
+		expiry
+	)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry
+	`
+
+	_, err := dbx.ExecContext(ctx, query, id, data, expiry)
+	return err
+}
+
+func deleteOAuthSession(ctx context.Context, dbx DBTX, id string) error {
+	query := `DELETE FROM oauth_session WHERE id = $1`
+
+	_, err := dbx.ExecContext(ctx, query, id)
+	return err
+}
+
+func deleteExpiredOAuthSessions(ctx context.Context, dbx DBTX) (int64, error) {
+	query := `DELETE FROM oauth_session WHERE expiry <= $1`
+
+	res, err := dbx.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+//
+// Helpers
+//
+
+// stateID is the id of the session cookies of a state, the prefix keeps it apart from the session ids.
+func stateID(state string) string {
+	return "state:" + state
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/gorilla/securecookie"
+)
+
+func newTestSessionStore() *SessionStore {
+	config := DefaultSessionStoreConfig
+	config.KeyPairs = [][]byte{securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32)}
+	return NewMemorySessionStore(config)
+}
+
+// withCookies returns a request with the cookies set by the response.
+func withCookies(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSessionStore(t *testing.T) {
+	s := newTestSessionStore()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := s.New(r, "_login_session")
+	if err != nil || !session.IsNew {
+		t.Fatalf("New() = %+v, %v", session, err)
+	}
+	session.Values["action"] = "signin"
+	w := httptest.NewRecorder()
+	if err := s.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+
+	loaded, err := s.New(withCookies(w), "_login_session")
+	if err != nil || loaded.IsNew || loaded.ID != session.ID || loaded.Values["action"] != "signin" {
+		t.Fatalf("New() = %+v, %v", loaded, err)
+	}
+
+	// the cookie of another session name can't be decoded.
+	other, err := s.New(withCookies(w), "other")
+	if err != nil || !other.IsNew {
+		t.Errorf("New() of another name = %+v, %v", other, err)
+	}
+
+	// a negative MaxAge deletes the session.
+	loaded.Options.MaxAge = -1
+	w2 := httptest.NewRecorder()
+	if err := s.Save(withCookies(w), w2, loaded); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.New(withCookies(w), "_login_session")
+	if err != nil || !deleted.IsNew {
+		t.Errorf("New() of a deleted session = %+v, %v", deleted, err)
+	}
+}
+
+func TestSessionStoreTamperedCookie(t *testing.T) {
+	s := newTestSessionStore()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "_login_session", Value: "tampered"})
+	session, err := s.New(r, "_login_session")
+	if err != nil || !session.IsNew {
+		t.Errorf("New() = %+v, %v", session, err)
+	}
+}
+
+func TestMemorySessionBackend(t *testing.T) {
+	ctx := context.Background()
+	b := &memorySessionBackend{sessions: map[string]memorySession{}}
+	now := time.Now()
+
+	b.save(ctx, "live", "data", now.Add(time.Minute))
+	b.save(ctx, "expired", "data", now.Add(-time.Minute))
+
+	if data, err := b.load(ctx, "live"); err != nil || data != "data" {
+		t.Errorf("load(live) = %q, %v", data, err)
+	}
+	if _, err := b.load(ctx, "expired"); auth.ErrorCode(err) != auth.ENOTFOUND {
+		t.Errorf("load(expired) error = %v", err)
+	}
+	if _, err := b.load(ctx, "missing"); auth.ErrorCode(err) != auth.ENOTFOUND {
+		t.Errorf("load(missing) error = %v", err)
+	}
+
+	n, err := b.deleteExpired(ctx)
+	if err != nil || n != 1 {
+		t.Errorf("deleteExpired() = %d, %v", n, err)
+	}
+	if _, ok := b.sessions["live"]; !ok {
+		t.Errorf("deleteExpired() deleted a live session")
+	}
+
+	b.delete(ctx, "live")
+	if _, err := b.load(ctx, "live"); auth.ErrorCode(err) != auth.ENOTFOUND {
+		t.Errorf("load() of a deleted session error = %v", err)
+	}
+}
+
+func TestSessionStoreState(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSessionStore()
+
+	err := s.SaveState(ctx, "abc", "_login_session=1; _gothic_session=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies, err := s.TakeState(ctx, "abc")
+	if err != nil || cookies != "_login_session=1; _gothic_session=2" {
+		t.Fatalf("TakeState() = %q, %v", cookies, err)
+	}
+	// the state is used once.
+	if _, err := s.TakeState(ctx, "abc"); auth.ErrorCode(err) != auth.ENOTFOUND {
+		t.Errorf("second TakeState() error = %v", err)
+	}
+	// the states don't collide with the sessions.
+	if _, err := s.backend.load(ctx, "abc"); auth.ErrorCode(err) != auth.ENOTFOUND {
+		t.Errorf("state is stored under its own id")
+	}
+}
@@ -53,6 +53,37 @@ func getToken(ctx context.Context, dbx DBTX, hash []byte, scope string) (*dbToke
 	return &t, nil
 }
 
+// consumeToken deletes the token and returns it,
+// so that a single use token can't be used twice by concurrent requests.
+func consumeToken(ctx context.Context, dbx DBTX, hash []byte, scope string) (*dbToken, error) {
+	query := `
+	DELETE FROM token
+	WHERE hash = $1 AND scope = $2
+	RETURNING
+		user_id,
+		hash,
+		scope,
+		revoked,
+		expiry,
+		payload,
+		created,
+		updated
+	`
+
+	t := dbToken{}
+
+	err := dbx.GetContext(ctx, &t, query, hash, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching token found"}
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
 type dbTokenInsert struct {
 	UserID  int
 	Hash    []byte
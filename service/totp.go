@@ -0,0 +1,336 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aemdemir/auth"
+)
+
+const (
+	// maxTOTPFailures is the number of the wrong codes in a row which locks the authenticator for totpLockout,
+	// a 6 digit code can't be guessed in the few periods it's valid then.
+	maxTOTPFailures = 5
+	totpLockout     = 15 * time.Minute
+)
+
+// SetupTOTP generates a new authenticator secret for the user, it's enabled once EnableTOTP verifies a code of it.
+// The token is a confirmation token, since the authenticator can confirm the user afterwards.
+func (s *authService) SetupTOTP(ctx context.Context, setup auth.TOTPInput) (*auth.TOTPSetup, error) {
+	meta := auth.TokenConfirmation
+
+	v := s.config.Policy.NewValidator()
+	if setup.Validate(v, meta); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+	if len(s.config.TOTPKeys) == 0 {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "authenticator is not available"}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	du, err := getUserByConfirmation(ctx, tx, setup.Token, setup.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	dt, err := getTOTP(ctx, tx, du.ID)
+	if err != nil && auth.ErrorCode(err) != auth.ENOTFOUND {
+		return nil, err
+	}
+	if dt != nil && dt.Enabled {
+		return nil, &auth.Error{Code: auth.ECONFLICT, Message: "authenticator has already been enabled"}
+	}
+
+	ts, err := auth.NewTOTPSetup(s.config.TOTPIssuer, du.Username)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := seal(s.config.TOTPKeys, []byte(ts.Secret), totpData(du.ID))
+	if err != nil {
+		return nil, err
+	}
+	err = upsertTOTP(ctx, tx, du.ID, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return ts, tx.Commit()
+}
+
+// EnableTOTP enables the authenticator of the user with a code of it.
+func (s *authService) EnableTOTP(ctx context.Context, uid int, code string) error {
+	v := s.config.Policy.NewValidator()
+	if auth.ValidateTOTPCode(v, code); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dt, err := getTOTPForUpdate(ctx, tx, uid)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return err
+		}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "authenticator has not been set up yet"}
+	}
+	if dt.Enabled {
+		return &auth.Error{Code: auth.ECONFLICT, Message: "authenticator has already been enabled"}
+	}
+
+	err = s.verifyTOTP(ctx, tx, dt, code)
+	if err != nil {
+		return s.commitTOTPFailure(tx, err)
+	}
+
+	err = enqueueUserEvent(ctx, tx, auth.EventUserTOTPEnabled, uid, auth.WebhookEventData{})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP deletes the authenticator of the user.
+func (s *authService) DisableTOTP(ctx context.Context, disable auth.TOTPInput) error {
+	meta := auth.TokenConfirmation
+
+	v := s.config.Policy.NewValidator()
+	if disable.Validate(v, meta); !v.Valid() {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	du, err := getUserByConfirmation(ctx, tx, disable.Token, disable.UserID)
+	if err != nil {
+		return err
+	}
+
+	dt, err := getTOTP(ctx, tx, du.ID)
+	if err != nil {
+		return err
+	}
+	err = deleteTOTP(ctx, tx, du.ID)
+	if err != nil {
+		return err
+	}
+
+	if dt.Enabled {
+		err = enqueueUserEvent(ctx, tx, auth.EventUserTOTPDisabled, du.ID, auth.WebhookEventData{})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// matchTOTP checks the code against the enabled authenticator of the user,
+// and records its period so that the code can't be used again.
+// It runs in its own transaction, so that the wrong codes are counted even if the caller's transaction rolls back.
+func (s *authService) matchTOTP(ctx context.Context, uid int, code string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dt, err := getTOTPForUpdate(ctx, tx, uid)
+	if err != nil {
+		if auth.ErrorCode(err) != auth.ENOTFOUND {
+			return err
+		}
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "authenticator has not been enabled"}
+	}
+	if !dt.Enabled {
+		return &auth.Error{Code: auth.EUNPROCESSABLE, Message: "authenticator has not been enabled"}
+	}
+
+	err = s.verifyTOTP(ctx, tx, dt, code)
+	if err != nil {
+		return s.commitTOTPFailure(tx, err)
+	}
+	return tx.Commit()
+}
+
+// verifyTOTP checks the code against the locked authenticator and enables it.
+// A wrong code is counted, and maxTOTPFailures of them in a row lock the authenticator for totpLockout.
+// The count is written to tx, it's committed by commitTOTPFailure.
+func (s *authService) verifyTOTP(ctx context.Context, tx *Tx, dt *dbTOTP, code string) error {
+	now := time.Now()
+	if dt.LockedUntil.After(now) {
+		return &auth.Error{Code: auth.EFORBIDDEN, Message: "too many invalid codes, try again later"}
+	}
+
+	secret, err := s.totpSecret(dt)
+	if err != nil {
+		return err
+	}
+
+	counter, ok := auth.MatchTOTP(secret, code, now, dt.LastCounter)
+	if !ok {
+		failures, lockedUntil := dt.FailedAttempts+1, dt.LockedUntil
+		if failures >= maxTOTPFailures {
+			failures, lockedUntil = 0, now.Add(totpLockout)
+		}
+		err = updateTOTPFailures(ctx, tx, dt.UserID, failures, lockedUntil)
+		if err != nil {
+			return err
+		}
+		return errInvalidTOTP
+	}
+
+	return updateTOTP(ctx, tx, dt.UserID, true, counter)
+}
+
+//
+// db
+//
+
+type dbTOTP struct {
+	UserID int `db:"user_id"`
+	// EncryptedSecret is the secret, encrypted with the Config.TOTPKeys.
+	EncryptedSecret []byte    `db:"encrypted_secret"`
+	Enabled         bool      `db:"enabled"`
+	LastCounter     int64     `db:"last_counter"`
+	FailedAttempts  int       `db:"failed_attempts"`
+	LockedUntil     time.Time `db:"locked_until"`
+	Created         time.Time `db:"created"`
+	Updated         time.Time `db:"updated"`
+}
+
+func getTOTP(ctx context.Context, dbx DBTX, uid int) (*dbTOTP, error) {
+	query := `
+	SELECT
+		user_id,
+		encrypted_secret,
+		enabled,
+		last_counter,
+		failed_attempts,
+		locked_until,
+		created,
+		updated
+	FROM  user_totp
+	WHERE user_id = $1
+	`
+
+	return queryTOTP(ctx, dbx, query, uid)
+}
+
+// getTOTPForUpdate locks the authenticator, so that concurrent requests can't use the same code.
+func getTOTPForUpdate(ctx context.Context, dbx DBTX, uid int) (*dbTOTP, error) {
+	query := `
+	SELECT
+		user_id,
+		encrypted_secret,
+		enabled,
+		last_counter,
+		failed_attempts,
+		locked_until,
+		created,
+		updated
+	FROM  user_totp
+	WHERE user_id = $1
+	FOR UPDATE
+	`
+
+	return queryTOTP(ctx, dbx, query, uid)
+}
+
+func queryTOTP(ctx context.Context, dbx DBTX, query string, uid int) (*dbTOTP, error) {
+	t := dbTOTP{}
+
+	err := dbx.GetContext(ctx, &t, query, uid)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching authenticator found"}
+		default:
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// upsertTOTP stores a new secret, the lockout of the old one is kept so that it can't be reset by a new setup.
+func upsertTOTP(ctx context.Context, dbx DBTX, uid int, encryptedSecret []byte) error {
+	query := `
+	INSERT INTO user_totp
+	(
+		user_id,
+		encrypted_secret
+	)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET encrypted_secret = EXCLUDED.encrypted_secret, enabled = false, last_counter = 0
+	`
+
+	_, err := dbx.ExecContext(ctx, query, uid, encryptedSecret)
+	return err
+}
+
+// updateTOTP records the period of a matching code, and resets the failure count.
+func updateTOTP(ctx context.Context, dbx DBTX, uid int, enabled bool, lastCounter int64) error {
+	query := `UPDATE user_totp SET enabled = $1, last_counter = $2, failed_attempts = 0 WHERE user_id = $3`
+
+	_, err := dbx.ExecContext(ctx, query, enabled, lastCounter, uid)
+	return err
+}
+
+func updateTOTPFailures(ctx context.Context, dbx DBTX, uid int, failedAttempts int, lockedUntil time.Time) error {
+	query := `UPDATE user_totp SET failed_attempts = $1, locked_until = $2 WHERE user_id = $3`
+
+	_, err := dbx.ExecContext(ctx, query, failedAttempts, lockedUntil, uid)
+	return err
+}
+
+func deleteTOTP(ctx context.Context, dbx DBTX, uid int) error {
+	query := `DELETE FROM user_totp WHERE user_id = $1`
+
+	_, err := dbx.ExecContext(ctx, query, uid)
+	return err
+}
+
+//
+// Helpers
+//
+
+var errInvalidTOTP = &auth.Error{Code: auth.EUNAUTHORIZED, Message: "invalid code"}
+
+// commitTOTPFailure commits the failure count of a wrong code, and returns the error of verifyTOTP.
+func (s *authService) commitTOTPFailure(tx *Tx, err error) error {
+	if err != errInvalidTOTP {
+		return err
+	}
+	if cerr := tx.Commit(); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// totpSecret decrypts the secret of the authenticator.
+func (s *authService) totpSecret(dt *dbTOTP) (string, error) {
+	plain, err := open(s.config.TOTPKeys, dt.EncryptedSecret, totpData(dt.UserID))
+	if err != nil {
+		return "", fmt.Errorf("authenticator secret of user %d: %w", dt.UserID, err)
+	}
+	return string(plain), nil
+}
+
+// totpData binds the encrypted secret to the user, so that it can't be copied to another user's row.
+func totpData(uid int) []byte {
+	return []byte(fmt.Sprintf("user_totp:%d", uid))
+}
@@ -23,6 +23,7 @@ type dbUser struct {
 	Created      time.Time       `db:"created"`
 	Updated      time.Time       `db:"updated"`
 	PasswordHash []byte          `db:"password_hash"`
+	Locale       auth.NullString `db:"locale"`
 }
 
 func getUser(ctx context.Context, dbx DBTX, id int) (*dbUser, error) {
@@ -35,7 +36,8 @@ func getUser(ctx context.Context, dbx DBTX, id int) (*dbUser, error) {
 		version,
 		created,
 		updated,
-		password_hash
+		password_hash,
+		locale
 	FROM  users
 	WHERE id = $1
 	`
@@ -54,6 +56,36 @@ func getUser(ctx context.Context, dbx DBTX, id int) (*dbUser, error) {
 	return &u, nil
 }
 
+func getUserByUsername(ctx context.Context, dbx DBTX, username string) (*dbUser, error) {
+	query := `
+	SELECT
+		id,
+		username,
+		name,
+		active,
+		version,
+		created,
+		updated,
+		password_hash,
+		locale
+	FROM  users
+	WHERE lower(username) = lower($1)
+	`
+
+	u := dbUser{}
+
+	err := dbx.GetContext(ctx, &u, query, username)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching user found"}
+		default:
+			return nil, err
+		}
+	}
+	return &u, nil
+}
+
 func getUserByEmail(ctx context.Context, dbx DBTX, address string) (*dbUser, error) {
 	query := `
 	SELECT 
@@ -64,10 +96,11 @@ func getUserByEmail(ctx context.Context, dbx DBTX, address string) (*dbUser, err
 		u.version,
 		u.created,
 		u.updated,
-		u.password_hash
+		u.password_hash,
+		u.locale
 	FROM  users      AS u
 	JOIN  user_email AS e ON u.id = e.user_id
-	WHERE e.address = $1
+	WHERE lower(e.address) = lower($1)
 	`
 
 	u := dbUser{}
@@ -94,10 +127,11 @@ func getUserByPrimaryEmail(ctx context.Context, dbx DBTX, address string) (*dbUs
 		u.version,
 		u.created,
 		u.updated,
-		u.password_hash
+		u.password_hash,
+		u.locale
 	FROM  users      AS u
 	JOIN  user_email AS e ON u.id = e.user_id
-	WHERE e.address = $1 AND e.is_primary = true
+	WHERE lower(e.address) = lower($1) AND e.is_primary = true
 	`
 
 	u := dbUser{}
@@ -124,7 +158,8 @@ func getUserByAccount(ctx context.Context, dbx DBTX, providerName, providerUID s
 		u.version,
 		u.created,
 		u.updated,
-		u.password_hash
+		u.password_hash,
+		u.locale
 	FROM  users        AS u
 	JOIN  user_account AS a ON u.id = a.user_id
 	WHERE a.provider_name = $1 AND a.provider_user_id = $2
@@ -154,7 +189,8 @@ func getUserByValidToken(ctx context.Context, dbx DBTX, hash []byte, scope strin
 		u.version,
 		u.created,
 		u.updated,
-		u.password_hash
+		u.password_hash,
+		u.locale
 	FROM  users AS u
 	JOIN  token AS t ON  u.id = t.user_id
 	WHERE t.hash = $1 AND t.scope = $2 AND t.revoked = false AND t.expiry > $3
@@ -178,6 +214,7 @@ type dbUserInsert struct {
 	Username     string
 	Name         auth.NullString
 	PasswordHash []byte
+	Locale       auth.NullString
 }
 
 func insertUser(ctx context.Context, dbx DBTX, in dbUserInsert) (int, error) {
@@ -186,9 +223,10 @@ func insertUser(ctx context.Context, dbx DBTX, in dbUserInsert) (int, error) {
 	(
 		username,
 		name,
-		password_hash
+		password_hash,
+		locale
 	)
-	VALUES    (:username, :name, :password_hash)
+	VALUES    (:username, :name, :password_hash, :locale)
 	RETURNING id
 	`
 
@@ -196,6 +234,7 @@ func insertUser(ctx context.Context, dbx DBTX, in dbUserInsert) (int, error) {
 		Username:     in.Username,
 		Name:         in.Name,
 		PasswordHash: in.PasswordHash,
+		Locale:       in.Locale,
 	}
 
 	query, args, err := dbx.BindNamed(query, u)
@@ -221,6 +260,7 @@ type dbUserUpdate struct {
 	Username     string
 	Version      int
 	PasswordHash []byte
+	Locale       auth.NullString
 }
 
 func updateUser(ctx context.Context, dbx DBTX, up dbUserUpdate) error {
@@ -229,6 +269,7 @@ func updateUser(ctx context.Context, dbx DBTX, up dbUserUpdate) error {
 	SET
 		username      = :username,
 		password_hash = :password_hash,
+		locale        = :locale,
 		version       = version + 1
 	WHERE     id = :id AND version = :version
 	RETURNING version
@@ -239,6 +280,7 @@ func updateUser(ctx context.Context, dbx DBTX, up dbUserUpdate) error {
 		Username:     up.Username,
 		Version:      up.Version,
 		PasswordHash: up.PasswordHash,
+		Locale:       up.Locale,
 	}
 
 	query, args, err := dbx.BindNamed(query, u)
@@ -261,6 +303,21 @@ func updateUser(ctx context.Context, dbx DBTX, up dbUserUpdate) error {
 	return nil
 }
 
+func deleteUser(ctx context.Context, dbx DBTX, id int) error {
+	query := `DELETE FROM users WHERE id = $1`
+
+	res, err := dbx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &auth.Error{Code: auth.ENOTFOUND, Message: "no matching user found"}
+	}
+	return nil
+}
+
 //
 // conversion
 //
@@ -275,5 +332,6 @@ func toAuthUser(e *dbUser) *auth.User {
 		Created:      e.Created,
 		Updated:      e.Updated,
 		PasswordHash: e.PasswordHash,
+		Locale:       e.Locale,
 	}
 }
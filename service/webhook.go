@@ -0,0 +1,548 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aemdemir/auth"
+	"github.com/jackc/pgtype"
+)
+
+func (s *authService) CreateWebhook(ctx context.Context, webhook auth.WebhookInput) (*auth.Webhook, error) {
+	v := s.config.Policy.NewValidator()
+	if webhook.Validate(v); !v.Valid() {
+		return nil, &auth.Error{Code: auth.EUNPROCESSABLE, Message: "invalid input", Detail: v.Errors, Codes: v.Codes}
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	id, err := insertWebhook(ctx, s.db, dbWebhookInsert{
+		URL:    webhook.URL,
+		Events: webhook.Events,
+		Secret: secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dw, err := getWebhook(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	w := toAuthWebhook(dw)
+	w.Secret = dw.Secret
+	return w, nil
+}
+
+func (s *authService) ListWebhooks(ctx context.Context) ([]auth.Webhook, error) {
+	dww, err := getWebhooks(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	return toAuthWebhooks(dww), nil
+}
+
+func (s *authService) DeleteWebhook(ctx context.Context, id int) error {
+	return deleteWebhook(ctx, s.db, id)
+}
+
+func (s *authService) ListWebhookDeliveries(ctx context.Context, webhookID int) ([]auth.WebhookDelivery, error) {
+	if _, err := getWebhook(ctx, s.db, webhookID); err != nil {
+		return nil, err
+	}
+
+	ddd, err := getWebhookDeliveriesByWebhook(ctx, s.db, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	return toAuthWebhookDeliveries(ddd), nil
+}
+
+func (s *authService) GetWebhookDelivery(ctx context.Context, id int) (*auth.WebhookDeliveryDetail, error) {
+	dd, err := getWebhookDelivery(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	daa, err := getWebhookAttemptsByDelivery(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.WebhookDeliveryDetail{
+		WebhookDelivery: *toAuthWebhookDelivery(dd),
+		Log:             toAuthWebhookAttempts(daa),
+	}, nil
+}
+
+func (s *authService) RedeliverWebhook(ctx context.Context, deliveryID int) error {
+	return resetWebhookDelivery(ctx, s.db, deliveryID)
+}
+
+// enqueueWebhookEvent queues the event for every active webhook subscribed to it.
+// Pass a transaction to make sure the event is only queued if the change is committed.
+func enqueueWebhookEvent(ctx context.Context, dbx DBTX, event string, data auth.WebhookEventData) error {
+	id, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(auth.WebhookEvent{
+		ID:      id,
+		Type:    event,
+		Created: time.Now().UTC(),
+		Data:    data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return insertWebhookDeliveries(ctx, dbx, dbWebhookDeliveryInsert{
+		EventID: id,
+		Event:   event,
+		Payload: payload,
+	})
+}
+
+// enqueueUserEvent queues the event with the latest state of the user.
+func enqueueUserEvent(ctx context.Context, dbx DBTX, event string, uid int, data auth.WebhookEventData) error {
+	du, err := getUser(ctx, dbx, uid)
+	if err != nil {
+		return err
+	}
+	data.User = *toAuthUser(du)
+	return enqueueWebhookEvent(ctx, dbx, event, data)
+}
+
+//
+// db
+//
+
+type dbWebhook struct {
+	ID      int              `db:"id"`
+	URL     string           `db:"url"`
+	Events  pgtype.TextArray `db:"events"`
+	Secret  string           `db:"secret"`
+	Active  bool             `db:"active"`
+	Created time.Time        `db:"created"`
+	Updated time.Time        `db:"updated"`
+}
+
+func getWebhook(ctx context.Context, dbx DBTX, id int) (*dbWebhook, error) {
+	query := `
+	SELECT
+		id,
+		url,
+		events,
+		secret,
+		active,
+		created,
+		updated
+	FROM  webhook
+	WHERE id = $1
+	`
+
+	w := dbWebhook{}
+
+	err := dbx.GetContext(ctx, &w, query, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching webhook found"}
+		default:
+			return nil, err
+		}
+	}
+	return &w, nil
+}
+
+func getWebhooks(ctx context.Context, dbx DBTX) ([]dbWebhook, error) {
+	query := `
+	SELECT
+		id,
+		url,
+		events,
+		secret,
+		active,
+		created,
+		updated
+	FROM     webhook
+	ORDER BY id
+	`
+
+	w := []dbWebhook{}
+
+	err := dbx.SelectContext(ctx, &w, query)
+	return w, err
+}
+
+type dbWebhookInsert struct {
+	URL    string
+	Events []string
+	Secret string
+}
+
+func insertWebhook(ctx context.Context, dbx DBTX, in dbWebhookInsert) (int, error) {
+	query := `
+	INSERT INTO webhook
+	(
+		url,
+		events,
+		secret
+	)
+	VALUES    ($1, $2, $3)
+	RETURNING id
+	`
+
+	events := pgtype.TextArray{}
+	if err := events.Set(in.Events); err != nil {
+		return -1, err
+	}
+
+	var id int
+	err := dbx.QueryRowContext(ctx, query, in.URL, events, in.Secret).Scan(&id)
+	return id, err
+}
+
+func deleteWebhook(ctx context.Context, dbx DBTX, id int) error {
+	query := `DELETE FROM webhook WHERE id = $1`
+
+	res, err := dbx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &auth.Error{Code: auth.ENOTFOUND, Message: "no matching webhook found"}
+	}
+	return nil
+}
+
+type dbWebhookDelivery struct {
+	ID          int       `db:"id"`
+	WebhookID   int       `db:"webhook_id"`
+	EventID     string    `db:"event_id"`
+	Event       string    `db:"event"`
+	Payload     []byte    `db:"payload"`
+	Status      string    `db:"status"`
+	Attempts    int       `db:"attempts"`
+	NextAttempt time.Time `db:"next_attempt"`
+	Created     time.Time `db:"created"`
+	Updated     time.Time `db:"updated"`
+}
+
+func getWebhookDelivery(ctx context.Context, dbx DBTX, id int) (*dbWebhookDelivery, error) {
+	query := `
+	SELECT
+		id,
+		webhook_id,
+		event_id,
+		event,
+		payload,
+		status,
+		attempts,
+		next_attempt,
+		created,
+		updated
+	FROM  webhook_delivery
+	WHERE id = $1
+	`
+
+	d := dbWebhookDelivery{}
+
+	err := dbx.GetContext(ctx, &d, query, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &auth.Error{Code: auth.ENOTFOUND, Message: "no matching delivery found"}
+		default:
+			return nil, err
+		}
+	}
+	return &d, nil
+}
+
+func getWebhookDeliveriesByWebhook(ctx context.Context, dbx DBTX, webhookID int) ([]dbWebhookDelivery, error) {
+	query := `
+	SELECT
+		id,
+		webhook_id,
+		event_id,
+		event,
+		payload,
+		status,
+		attempts,
+		next_attempt,
+		created,
+		updated
+	FROM     webhook_delivery
+	WHERE    webhook_id = $1
+	ORDER BY id DESC
+	LIMIT    100
+	`
+
+	d := []dbWebhookDelivery{}
+
+	err := dbx.SelectContext(ctx, &d, query, webhookID)
+	return d, err
+}
+
+// claimWebhookDeliveries leases the due deliveries until the given time, so that other dispatchers skip them.
+func claimWebhookDeliveries(ctx context.Context, tx *Tx, limit int, leaseUntil time.Time) ([]dbWebhookDelivery, error) {
+	query := `
+	UPDATE webhook_delivery
+	SET    next_attempt = $3
+	WHERE  id IN (
+		SELECT   id
+		FROM     webhook_delivery
+		WHERE    status = 'pending' AND next_attempt <= $1
+		ORDER BY next_attempt
+		LIMIT    $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING
+		id,
+		webhook_id,
+		event_id,
+		event,
+		payload,
+		status,
+		attempts,
+		next_attempt,
+		created,
+		updated
+	`
+
+	d := []dbWebhookDelivery{}
+
+	err := tx.SelectContext(ctx, &d, query, time.Now(), limit, leaseUntil)
+	return d, err
+}
+
+type dbWebhookDeliveryInsert struct {
+	EventID string
+	Event   string
+	Payload []byte
+}
+
+func insertWebhookDeliveries(ctx context.Context, dbx DBTX, in dbWebhookDeliveryInsert) error {
+	query := `
+	INSERT INTO webhook_delivery
+	(
+		webhook_id,
+		event_id,
+		event,
+		payload
+	)
+	SELECT id, $1, $2, $3
+	FROM   webhook
+	WHERE  active = true AND $2 = ANY(events)
+	`
+
+	_, err := dbx.ExecContext(ctx, query, in.EventID, in.Event, in.Payload)
+	return err
+}
+
+type dbWebhookDeliveryUpdate struct {
+	ID          int
+	Status      string
+	Attempts    int
+	NextAttempt time.Time
+}
+
+func updateWebhookDelivery(ctx context.Context, dbx DBTX, up dbWebhookDeliveryUpdate) error {
+	query := `
+	UPDATE webhook_delivery
+	SET
+		status       = :status,
+		attempts     = :attempts,
+		next_attempt = :next_attempt
+	WHERE
+		id = :id
+	`
+
+	d := dbWebhookDelivery{
+		ID:          up.ID,
+		Status:      up.Status,
+		Attempts:    up.Attempts,
+		NextAttempt: up.NextAttempt,
+	}
+
+	_, err := dbx.NamedExecContext(ctx, query, d)
+	return err
+}
+
+// resetWebhookDelivery queues the delivery again, regardless of its status.
+func resetWebhookDelivery(ctx context.Context, dbx DBTX, id int) error {
+	query := `
+	UPDATE webhook_delivery
+	SET
+		status       = 'pending',
+		attempts     = 0,
+		next_attempt = $2
+	WHERE
+		id = $1
+	`
+
+	res, err := dbx.ExecContext(ctx, query, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &auth.Error{Code: auth.ENOTFOUND, Message: "no matching delivery found"}
+	}
+	return nil
+}
+
+type dbWebhookAttempt struct {
+	DeliveryID int             `db:"delivery_id"`
+	StatusCode int             `db:"status_code"`
+	Error      auth.NullString `db:"error"`
+	Duration   int             `db:"duration"`
+	Created    time.Time       `db:"created"`
+}
+
+func getWebhookAttemptsByDelivery(ctx context.Context, dbx DBTX, deliveryID int) ([]dbWebhookAttempt, error) {
+	query := `
+	SELECT
+		delivery_id,
+		status_code,
+		error,
+		duration,
+		created
+	FROM     webhook_attempt
+	WHERE    delivery_id = $1
+	ORDER BY created
+	`
+
+	a := []dbWebhookAttempt{}
+
+	err := dbx.SelectContext(ctx, &a, query, deliveryID)
+	return a, err
+}
+
+type dbWebhookAttemptInsert struct {
+	DeliveryID int
+	StatusCode int
+	Error      auth.NullString
+	Duration   int
+}
+
+func insertWebhookAttempt(ctx context.Context, dbx DBTX, in dbWebhookAttemptInsert) error {
+	query := `
+	INSERT INTO webhook_attempt
+	(
+		delivery_id,
+		status_code,
+		error,
+		duration
+	)
+	VALUES (:delivery_id, :status_code, :error, :duration)
+	`
+
+	a := dbWebhookAttempt{
+		DeliveryID: in.DeliveryID,
+		StatusCode: in.StatusCode,
+		Error:      in.Error,
+		Duration:   in.Duration,
+	}
+
+	_, err := dbx.NamedExecContext(ctx, query, a)
+	return err
+}
+
+//
+// conversion
+//
+
+func toAuthWebhook(e *dbWebhook) *auth.Webhook {
+	events := []string{}
+	_ = e.Events.AssignTo(&events)
+
+	return &auth.Webhook{
+		ID:      e.ID,
+		URL:     e.URL,
+		Events:  events,
+		Active:  e.Active,
+		Created: e.Created,
+		Updated: e.Updated,
+	}
+}
+
+func toAuthWebhooks(ss []dbWebhook) []auth.Webhook {
+	rr := make([]auth.Webhook, len(ss))
+	for i, e := range ss {
+		rr[i] = *toAuthWebhook(&e)
+	}
+	return rr
+}
+
+func toAuthWebhookDelivery(e *dbWebhookDelivery) *auth.WebhookDelivery {
+	return &auth.WebhookDelivery{
+		ID:          e.ID,
+		WebhookID:   e.WebhookID,
+		EventID:     e.EventID,
+		Event:       e.Event,
+		Payload:     e.Payload,
+		Status:      e.Status,
+		Attempts:    e.Attempts,
+		NextAttempt: e.NextAttempt,
+		Created:     e.Created,
+		Updated:     e.Updated,
+	}
+}
+
+func toAuthWebhookDeliveries(ss []dbWebhookDelivery) []auth.WebhookDelivery {
+	rr := make([]auth.WebhookDelivery, len(ss))
+	for i, e := range ss {
+		rr[i] = *toAuthWebhookDelivery(&e)
+	}
+	return rr
+}
+
+func toAuthWebhookAttempt(e *dbWebhookAttempt) *auth.WebhookAttempt {
+	return &auth.WebhookAttempt{
+		DeliveryID: e.DeliveryID,
+		StatusCode: e.StatusCode,
+		Error:      e.Error,
+		Duration:   e.Duration,
+		Created:    e.Created,
+	}
+}
+
+func toAuthWebhookAttempts(ss []dbWebhookAttempt) []auth.WebhookAttempt {
+	rr := make([]auth.WebhookAttempt, len(ss))
+	for i, e := range ss {
+		rr[i] = *toAuthWebhookAttempt(&e)
+	}
+	return rr
+}
+
+//
+// Helpers
+//
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func nullStringPtr(s auth.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/ccojocar/zxcvbn-go"
+	"github.com/ccojocar/zxcvbn-go/match"
+)
+
+// Password scores, from a password guessed in seconds to one which would take centuries.
+const (
+	MinPasswordScore = 0
+	MaxPasswordScore = 4
+	// DefaultMinPasswordScore is the minimum score of the new passwords, unless it's configured.
+	DefaultMinPasswordScore = 2
+)
+
+// PasswordStrength is the zxcvbn style estimate of a password,
+// by the dictionary words, keyboard patterns, repeats, sequences and dates it's made of.
+type PasswordStrength struct {
+	Score int `json:"score"`
+	// MinScore is the minimum score of the new passwords, it's set by the service.
+	MinScore int `json:"min_score"`
+	// Breached is true if the password appeared in a data breach, it's set by the service.
+	Breached bool `json:"breached"`
+	// CrackTime is the time an offline attack would take to guess the password, e.g. "3.0 hours".
+	CrackTime   string    `json:"crack_time"`
+	Warning     *Message  `json:"-"`
+	Suggestions []Message `json:"-"`
+}
+
+// PasswordFeedback is the localized feedback of a password strength.
+type PasswordFeedback struct {
+	Warning     string   `json:"warning,omitempty"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// EstimatePasswordStrength scores the password, the user inputs like the username, name and email
+// are guessed first, so the passwords containing them are weak.
+func EstimatePasswordStrength(password string, userInputs ...string) PasswordStrength {
+	r := zxcvbn.PasswordStrength(password, passwordUserInputs(userInputs))
+
+	// the feedback is given below the max score, since the minimum score is configured by the service.
+	s := PasswordStrength{Score: r.Score, CrackTime: r.CrackTimeDisplay}
+	if s.Score >= MaxPasswordScore {
+		return s
+	}
+
+	// the longest match is the weakest part of the password.
+	var m *match.Match
+	for i := range r.MatchSequence {
+		if m == nil || len(r.MatchSequence[i].Token) > len(m.Token) {
+			m = &r.MatchSequence[i]
+		}
+	}
+	if m != nil {
+		s.Warning = passwordWarning(m)
+	}
+	if s.Warning != nil && s.Warning.Code == MsgPasswordPersonal {
+		s.Suggestions = append(s.Suggestions, NewMessage(MsgPasswordAvoidPersonal))
+	}
+	s.Suggestions = append(s.Suggestions, NewMessage(MsgPasswordAddWords))
+	return s
+}
+
+// Feedback returns the warning and the suggestions in the given locale.
+func (p PasswordStrength) Feedback(locale string) PasswordFeedback {
+	f := PasswordFeedback{Suggestions: make([]string, len(p.Suggestions))}
+	if p.Warning != nil {
+		f.Warning = p.Warning.Localize(locale)
+	}
+	for i, m := range p.Suggestions {
+		f.Suggestions[i] = m.Localize(locale)
+	}
+	return f
+}
+
+func ValidatePasswordStrength(v *validator, strength PasswordStrength, minScore int) {
+	v.Check(strength.Score >= minScore, "password", NewMessage(MsgWeakPassword))
+}
+
+func ValidatePasswordBreach(v *validator, breached bool) {
+	v.Check(!breached, "password", NewMessage(MsgBreachedPassword))
+}
+
+func ValidatePasswordReuse(v *validator, reused bool, depth int) {
+	v.Check(!reused, "password", NewMessage(MsgReusedPassword, depth))
+}
+
+//
+// Inputs
+//
+
+// PasswordStrengthInput is a password with the user inputs it's checked against.
+type PasswordStrengthInput struct {
+	Password string
+	Username string
+	Name     string
+	Email    string
+}
+
+func (p PasswordStrengthInput) Validate(v *validator) {
+	v.Check(notEmpty(p.Password), "password", NewMessage(MsgRequired))
+	v.Check(len(p.Password) <= v.policy.MaxPasswordBytes, "password", NewMessage(MsgTooLongBytes, v.policy.MaxPasswordBytes))
+}
+
+//
+// Helpers
+//
+
+func passwordWarning(m *match.Match) *Message {
+	var code string
+	switch m.Pattern {
+	case "dictionary":
+		switch {
+		case strings.HasPrefix(m.DictionaryName, "user_inputs"):
+			code = MsgPasswordPersonal
+		case strings.HasPrefix(m.DictionaryName, "Passwords"):
+			code = MsgPasswordCommon
+		default:
+			code = MsgPasswordWord
+		}
+	case "spatial":
+		code = MsgPasswordSpatial
+	case "repeat":
+		code = MsgPasswordRepeat
+	case "sequence":
+		code = MsgPasswordSequence
+	case "date":
+		code = MsgPasswordDate
+	default:
+		return nil
+	}
+	msg := NewMessage(code)
+	return &msg
+}
+
+// passwordUserInputs returns the lowercased inputs with their words, e.g. "jane" and "doe" for "jane.doe@example.com".
+func passwordUserInputs(inputs []string) []string {
+	var ss []string
+	for _, in := range inputs {
+		in = strings.ToLower(strings.TrimSpace(in))
+		if in == "" {
+			continue
+		}
+		ss = append(ss, in)
+		for _, w := range strings.FieldsFunc(in, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			if len(w) >= 3 && w != in {
+				ss = append(ss, w)
+			}
+		}
+	}
+	return ss
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "testing"
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	inputs := []string{"jane", "jane@example.com"}
+
+	tests := []struct {
+		password string
+		score    int
+		warning  string
+	}{
+		{"password", 0, MsgPasswordCommon},
+		{"correcthorse", 0, MsgPasswordWord},
+		{"jane1990", 0, MsgPasswordPersonal},
+		// the feedback is given above the default min score too, for the services which require more.
+		{"jane.doe.1990!", 3, MsgPasswordPersonal},
+		{"correct horse battery staple", 4, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			s := EstimatePasswordStrength(tt.password, inputs...)
+			if s.Score != tt.score {
+				t.Errorf("score = %d, want %d", s.Score, tt.score)
+			}
+			var warning string
+			if s.Warning != nil {
+				warning = s.Warning.Code
+			}
+			if warning != tt.warning {
+				t.Errorf("warning = %q, want %q", warning, tt.warning)
+			}
+			if got := len(s.Suggestions) > 0; got != (tt.score < MaxPasswordScore) {
+				t.Errorf("suggestions = %v", s.Suggestions)
+			}
+		})
+	}
+}
@@ -9,10 +9,18 @@ import (
 )
 
 var (
-	TokenAuth              = TokenMeta{Scope: "auth", TTL: 30 * 24 * time.Hour, ByteSize: 16}
-	TokenConfirmation      = TokenMeta{Scope: "confirmation", TTL: 5 * time.Minute, ByteSize: 5}
-	TokenEmailVerification = TokenMeta{Scope: "email_verification", TTL: 3 * 24 * time.Hour, ByteSize: 5}
-	TokenPasswordReset     = TokenMeta{Scope: "password_reset", TTL: 1 * time.Hour, ByteSize: 5}
+	TokenAuth                    = TokenMeta{Scope: "auth", TTL: 30 * 24 * time.Hour, ByteSize: 16}
+	TokenConfirmation            = TokenMeta{Scope: "confirmation", TTL: 5 * time.Minute, ByteSize: 5}
+	TokenConfirmationCode        = TokenMeta{Scope: "confirmation_code", TTL: 10 * time.Minute, ByteSize: 5}
+	TokenEmailVerification       = TokenMeta{Scope: "email_verification", TTL: 3 * 24 * time.Hour, ByteSize: 5}
+	TokenPasswordReset           = TokenMeta{Scope: "password_reset", TTL: 1 * time.Hour, ByteSize: 5}
+	TokenPasswordSetup           = TokenMeta{Scope: "password_setup", TTL: 1 * time.Hour, ByteSize: 5}
+	TokenSigninCode              = TokenMeta{Scope: "signin_code", TTL: 60 * time.Second, ByteSize: 16}
+	TokenAccountLink             = TokenMeta{Scope: "account_link", TTL: 1 * time.Hour, ByteSize: 16}
+	TokenAccountLinkVerification = TokenMeta{Scope: "account_link_verification", TTL: 1 * time.Hour, ByteSize: 5}
+	TokenOIDCCode                = TokenMeta{Scope: "oidc_code", TTL: 5 * time.Minute, ByteSize: 16}
+	TokenOIDCAccess              = TokenMeta{Scope: "oidc_access", TTL: 1 * time.Hour, ByteSize: 16}
+	TokenMachineAccess           = TokenMeta{Scope: "machine_access", TTL: 1 * time.Hour, ByteSize: 16}
 )
 
 // TokenMeta represents the meta data for a token.
@@ -69,8 +77,8 @@ func (t TokenInput) HashToken() []byte {
 	return hashToken(t.Text)
 }
 func (t TokenInput) Validate(v *validator, meta TokenMeta) {
-	v.Check(notEmpty(t.Text), "token", "must be provided")
-	v.Check(len(t.Text) == meta.Length(), "token", "must be in a valid format")
+	v.Check(notEmpty(t.Text), "token", NewMessage(MsgRequired))
+	v.Check(len(t.Text) == meta.Length(), "token", NewMessage(MsgInvalidFormat))
 }
 
 //
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238, the defaults of the authenticator apps.
+const (
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSecretSize = 20
+	// totpSkew is the number of periods accepted before and after the current one.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPSetup is the secret of a new authenticator, the user enters the secret
+// or scans the url as a qr code, and then verifies a code to enable it.
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+// NewTOTPSetup generates a secret for the account, the issuer is shown by the authenticator apps.
+func NewTOTPSetup(issuer, account string) (*TOTPSetup, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(b)
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("period", fmt.Sprint(totpPeriod))
+	q.Set("digits", fmt.Sprint(totpDigits))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return &TOTPSetup{Secret: secret, URL: u.String()}, nil
+}
+
+// MatchTOTP checks the code against the periods around t, and returns the matching period.
+// The periods up to last are rejected, so that a code can't be used twice.
+func MatchTOTP(secret, code string, t time.Time, last int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	now := t.Unix() / totpPeriod
+	for i := now - totpSkew; i <= now+totpSkew; i++ {
+		if i <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, i)), []byte(code)) == 1 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// hotp is the HMAC-SHA1 one-time password of RFC 4226 for the counter.
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000)
+}
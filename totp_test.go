@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the test vectors of RFC 6238, appendix B.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	// the 8 digit codes of RFC 6238 truncated to the 6 digits of the authenticator apps.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := hotp(rfc6238Secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("hotp(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111111, 0)
+	counter := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		last   int64
+		want   int64
+		ok     bool
+	}{
+		{"current", secret, "050471", 0, counter, true},
+		{"lower case secret", strings.ToLower(secret), "050471", 0, counter, true},
+		{"previous period", secret, hotp(rfc6238Secret, counter-1), 0, counter - 1, true},
+		{"next period", secret, hotp(rfc6238Secret, counter+1), 0, counter + 1, true},
+		{"outside skew", secret, hotp(rfc6238Secret, counter-2), 0, 0, false},
+		{"used", secret, "050471", counter, 0, false},
+		{"used previous", secret, hotp(rfc6238Secret, counter-1), counter - 1, 0, false},
+		{"wrong", secret, "000000", 0, 0, false},
+		{"invalid secret", "!", "050471", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := MatchTOTP(tt.secret, tt.code, now, tt.last)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("MatchTOTP() = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewTOTPSetup(t *testing.T) {
+	ts, err := NewTOTPSetup("auth", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(ts.Secret)
+	if err != nil || len(key) != totpSecretSize {
+		t.Fatalf("secret = %q, %v", ts.Secret, err)
+	}
+	if _, ok := MatchTOTP(ts.Secret, hotp(key, time.Now().Unix()/totpPeriod), time.Now(), 0); !ok {
+		t.Errorf("code of the new secret is not matched")
+	}
+	if !strings.HasPrefix(ts.URL, "otpauth://totp/auth:user?") || !strings.Contains(ts.URL, "secret="+ts.Secret) {
+		t.Errorf("url = %s", ts.URL)
+	}
+}
@@ -1,8 +1,11 @@
 package auth
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"math/rand"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -17,10 +20,16 @@ type User struct {
 	Created      time.Time  `json:"created"`
 	Updated      time.Time  `json:"updated"`
 	PasswordHash []byte     `json:"-"`
+	Locale       NullString `json:"locale"`
 }
 
 func (u User) MatchPassword(password string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password))
+	return MatchPasswordHash(u.PasswordHash, password)
+}
+
+// MatchPasswordHash returns true if the password matches the hash, e.g. a previous password of a user.
+func MatchPasswordHash(hash []byte, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
 	if err != nil {
 		switch {
 		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
@@ -42,11 +51,30 @@ type Email struct {
 	Updated  time.Time `json:"updated"`
 }
 
+// Recipient is the receiver of an email.
+type Recipient struct {
+	Address string
+	Name    string
+	Locale  string
+}
+
 type Account struct {
-	UserID         int       `json:"user_id"`
-	ProviderName   string    `json:"provider_name"`
-	ProviderUserID string    `json:"provider_user_id"`
-	Created        time.Time `json:"created"`
+	UserID         int            `json:"user_id"`
+	ProviderName   string         `json:"provider_name"`
+	ProviderUserID string         `json:"provider_user_id"`
+	Profile        AccountProfile `json:"profile"`
+	Created        time.Time      `json:"created"`
+	Updated        time.Time      `json:"updated"`
+}
+
+// AccountProfile is the profile of the user at the provider, it's refreshed on each social sign in.
+type AccountProfile struct {
+	Email     NullString `json:"email"`
+	Name      NullString `json:"name"`
+	AvatarURL NullString `json:"avatar_url"`
+	Locale    NullString `json:"locale"`
+	// RawClaims are the claims or the user info returned by the provider, as is.
+	RawClaims json.RawMessage `json:"raw_claims"`
 }
 
 //
@@ -59,8 +87,15 @@ type SignupInput struct {
 	Username string
 	Name     string
 	Password string
+	Locale   string
 }
 
+// Normalize returns the input with the normalized email and username.
+func (s SignupInput) Normalize() SignupInput {
+	s.Email = NormalizeEmail(s.Email)
+	s.Username = NormalizeUsername(s.Username)
+	return s
+}
 func (s SignupInput) IsPrimaryEmail() bool {
 	return true
 }
@@ -72,25 +107,74 @@ func (s SignupInput) Validate(v *validator) {
 	ValidateUsername(v, s.Username)
 	validateName(v, s.Name)
 	ValidatePassword(v, s.Password)
+	if s.Locale != "" {
+		ValidateLocale(v, s.Locale)
+	}
 }
 
+// SigninInput signs in the user with the password, the identifier is either the username
+// or one of the user's verified emails. Email is the identifier of the old clients, it's used if Identifier is empty.
 type SigninInput struct {
-	Email    string
-	Password string
+	Identifier string
+	Email      string
+	Password   string
+}
+
+// ID returns the normalized identifier of the user.
+func (s SigninInput) ID() string {
+	id := s.Identifier
+	if id == "" {
+		id = s.Email
+	}
+	if strings.Contains(id, "@") {
+		return NormalizeEmail(id)
+	}
+	return NormalizeUsername(id)
+}
+
+// IsEmail returns true if the identifier is an email, the usernames can't contain an @.
+func (s SigninInput) IsEmail() bool {
+	return strings.Contains(s.ID(), "@")
 }
 
 func (s SigninInput) Validate(v *validator) {
-	ValidateEmail(v, s.Email)
-	ValidatePassword(v, s.Password)
+	key := "identifier"
+	if s.Identifier == "" {
+		key = "email"
+	}
+
+	id := s.ID()
+	v.Check(notEmpty(id), key, NewMessage(MsgRequired))
+	if s.IsEmail() {
+		v.Check(len(id) <= maxEmailBytes, key, NewMessage(MsgTooLongBytes, maxEmailBytes))
+		v.Check(matches(id, emailRX), key, NewMessage(MsgInvalidEmail))
+	} else {
+		// the usernames may be taken before the policy is changed, so only the hard limit is checked.
+		v.Check(len(id) <= maxUsernameBytes, key, NewMessage(MsgTooLongBytes, maxUsernameBytes))
+	}
+	validateCurrentPassword(v, s.Password)
 }
 
 type SigninSocialInput struct {
+	// Username is the username of a new user, it's generated if it's empty.
+	// The generated usernames aren't checked by the policy, so they work with any policy.
 	Username string
 	Email    NullString
-	Name     NullString
-	Account  AccountInput
+	// EmailVerified is true if the provider asserts that the email is verified.
+	EmailVerified bool
+	Name          NullString
+	Locale        string
+	Account       AccountInput
 }
 
+// Normalize returns the input with the normalized email and username.
+func (s SigninSocialInput) Normalize() SigninSocialInput {
+	s.Username = NormalizeUsername(s.Username)
+	if s.Email.Valid {
+		s.Email.String = NormalizeEmail(s.Email.String)
+	}
+	return s
+}
 func (s SigninSocialInput) PasswordHash() []byte {
 	return nil
 }
@@ -98,27 +182,106 @@ func (s SigninSocialInput) IsPrimaryEmail(isNewUser bool) bool {
 	return isNewUser
 }
 func (s SigninSocialInput) Validate(v *validator) {
-	ValidateUsername(v, s.Username)
+	if s.Username != "" {
+		ValidateUsername(v, s.Username)
+	}
 	if s.Email.Valid {
 		ValidateEmail(v, s.Email.String)
 	}
 	if s.Name.Valid {
 		validateName(v, s.Name.String)
 	}
+	if s.Locale != "" {
+		ValidateLocale(v, s.Locale)
+	}
 	s.Account.Validate(v)
 }
 
 type AccountInput struct {
 	ProviderName   string
 	ProviderUserID string
+	Profile        AccountProfile
+	// Tokens are stored if the service is configured to keep the provider tokens.
+	Tokens ProviderTokens
 }
 
 func (a AccountInput) Validate(v *validator) {
-	v.Check(notEmpty(a.ProviderName), "provider_name", "must be provided")
-	v.Check(notEmpty(a.ProviderUserID), "provider_user_id", "must be provided")
+	v.Check(notEmpty(a.ProviderName), "provider_name", NewMessage(MsgRequired))
+	v.Check(notEmpty(a.ProviderUserID), "provider_user_id", NewMessage(MsgRequired))
+	v.Check(len(a.Profile.RawClaims) == 0 || json.Valid(a.Profile.RawClaims), "raw_claims", NewMessage(MsgInvalidFormat))
+}
+
+type DeleteUserInput struct {
+	UserID int
+	Token  TokenInput
+}
+
+func (d DeleteUserInput) Validate(v *validator, meta TokenMeta) {
+	d.Token.Validate(v, meta)
+}
+
+// ProviderTokens are the OAuth tokens of a linked account, to call the provider's API on behalf of the user.
+type ProviderTokens struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"-"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Valid returns true if the access token isn't expired, with a small margin for the request.
+// A token without an expiry never expires.
+func (t ProviderTokens) Valid() bool {
+	return t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Add(time.Minute).Before(t.Expiry))
+}
+
+// OAuthStateStore keeps the session cookies of the social sign ins started with mode=json by their state,
+// since the browser which completes them doesn't have the cookies of the app which started them.
+type OAuthStateStore interface {
+	SaveState(ctx context.Context, state, cookies string) error
+	// TakeState returns and deletes the cookies of the state, an ENOTFOUND error is returned if there are none.
+	TakeState(ctx context.Context, state string) (string, error)
+}
+
+// ProviderTokenSource refreshes and revokes the OAuth tokens of the providers.
+type ProviderTokenSource interface {
+	RefreshToken(ctx context.Context, provider, refreshToken string) (*ProviderTokens, error)
+	RevokeToken(ctx context.Context, provider string, tokens ProviderTokens) error
+}
+
+// IDTokenVerifier verifies the ID tokens which native apps get from the provider SDKs.
+type IDTokenVerifier interface {
+	VerifyIDToken(ctx context.Context, provider, idToken, nonce string) (*ProviderIdentity, error)
+}
+
+// ProviderIdentity is the user asserted by a verified ID token.
+type ProviderIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	AvatarURL     string
+	Locale        string
+	// RawClaims are the claims of the token.
+	RawClaims json.RawMessage
 }
 
+// ProviderRegistry knows the social sign in providers configured at runtime.
+type ProviderRegistry interface {
+	HasProvider(name string) bool
+}
+
+// ValidateProvider checks that the provider is in the registry.
+// Any provider is accepted if the registry is nil.
+func ValidateProvider(v *validator, providers ProviderRegistry, name string) {
+	if providers == nil || name == "" {
+		return
+	}
+	v.Check(providers.HasProvider(name), "provider_name", NewMessage(MsgUnknownProvider))
+}
+
+// LinkUserAccountInput links the account to the user, the token is a confirmation token of the user.
 type LinkUserAccountInput struct {
+	UserID  int
 	Token   TokenInput
 	Account AccountInput
 }
@@ -128,6 +291,17 @@ func (l LinkUserAccountInput) Validate(v *validator, meta TokenMeta) {
 	l.Account.Validate(v)
 }
 
+// ConfirmAccountLinkInput proves the ownership of the user of a pending account link with its password.
+type ConfirmAccountLinkInput struct {
+	Token    TokenInput
+	Password string
+}
+
+func (c ConfirmAccountLinkInput) Validate(v *validator, meta TokenMeta) {
+	c.Token.Validate(v, meta)
+	v.Check(notEmpty(c.Password), "password", NewMessage(MsgRequired))
+}
+
 type ResetPasswordInput struct {
 	Token    TokenInput
 	Password string
@@ -154,6 +328,64 @@ func (u UpdatePasswordInput) Validate(v *validator) {
 	ValidatePassword(v, u.NewPassword)
 }
 
+// SetPasswordInput sets the initial password of a user without one, e.g. a user who signed up with a provider.
+// The token is a password setup code, either emailed to the user or issued by a provider re-authentication.
+type SetPasswordInput struct {
+	UserID   int
+	Token    TokenInput
+	Password string
+}
+
+func (s SetPasswordInput) HashPassword() ([]byte, error) {
+	return hashPassword(s.Password)
+}
+func (s SetPasswordInput) Validate(v *validator, meta TokenMeta) {
+	s.Token.Validate(v, meta)
+	ValidatePassword(v, s.Password)
+}
+
+// confirmation methods.
+const (
+	ConfirmPassword = "password"
+	ConfirmEmail    = "email"
+	ConfirmTOTP     = "totp"
+)
+
+var confirmMethods = []string{ConfirmPassword, ConfirmEmail, ConfirmTOTP}
+
+// ConfirmationInput confirms the user with the password, with the code emailed by SendConfirmationEmail,
+// or with the code of the user's authenticator app, so that the users without a password can confirm too.
+type ConfirmationInput struct {
+	UserID   int
+	Method   string
+	Password string
+	Code     string
+}
+
+func (c ConfirmationInput) Validate(v *validator) {
+	switch c.Method {
+	case ConfirmPassword:
+		validateCurrentPassword(v, c.Password)
+	case ConfirmEmail:
+		v.Check(notEmpty(c.Code), "code", NewMessage(MsgRequired))
+		v.Check(len(c.Code) == TokenConfirmationCode.Length(), "code", NewMessage(MsgInvalidFormat))
+	case ConfirmTOTP:
+		ValidateTOTPCode(v, c.Code)
+	default:
+		v.Check(false, "method", NewMessage(MsgUnknownMethod, strings.Join(confirmMethods, ", ")))
+	}
+}
+
+// TOTPInput sets up or disables the authenticator of the user, the token is a confirmation token.
+type TOTPInput struct {
+	UserID int
+	Token  TokenInput
+}
+
+func (t TOTPInput) Validate(v *validator, meta TokenMeta) {
+	t.Token.Validate(v, meta)
+}
+
 //
 // Combining
 //
@@ -163,6 +395,36 @@ type UserEmail struct {
 	Email Email `json:"email"`
 }
 
+// The actions of the social sign in codes, a code is exchanged for the token of its action.
+const (
+	// CodeActionSignin is exchanged for an auth token.
+	CodeActionSignin = "signin"
+	// CodeActionPassword is exchanged for a password setup code.
+	CodeActionPassword = "password"
+	// CodeActionConfirm is exchanged for a confirmation token.
+	CodeActionConfirm = "confirm"
+)
+
+// SigninCodeInput exchanges a social sign in code for the token of its action.
+// CodeVerifier is required if the code is bound to a code challenge.
+type SigninCodeInput struct {
+	Code         TokenInput
+	CodeVerifier string
+}
+
+func (s SigninCodeInput) Validate(v *validator, meta TokenMeta) {
+	s.Code.Validate(v, meta)
+}
+
+// SigninCodeExchange is the token of an exchanged code.
+type SigninCodeExchange struct {
+	Action string
+	User   User
+	// Token is an auth token for CodeActionSignin, a password setup code for CodeActionPassword,
+	// and a confirmation token for CodeActionConfirm.
+	Token string
+}
+
 type UserSignin struct {
 	UserEmail
 	Token string `json:"token"`
@@ -175,8 +437,10 @@ type UserSigninSocial struct {
 
 type UserSettings struct {
 	User
-	Emails   []Email   `json:"emails"`
-	Accounts []Account `json:"accounts"`
+	// HasPassword is false for the users who only sign in with a provider, they can set one with SetPassword.
+	HasPassword bool      `json:"has_password"`
+	Emails      []Email   `json:"emails"`
+	Accounts    []Account `json:"accounts"`
 }
 
 //
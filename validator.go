@@ -1,51 +1,56 @@
 package auth
 
 import (
-	"fmt"
 	"regexp"
 	"strings"
 	"unicode/utf8"
 )
 
+// validator collects the validation errors.
+//
+// Errors holds the messages in the default locale,
+// and Codes holds the same messages with their codes to localize them later.
 type validator struct {
 	Errors map[string]string
+	Codes  map[string]Message
+	policy Policy
 }
 
+// NewValidator returns a validator of the DefaultPolicy.
 func NewValidator() *validator {
-	return &validator{Errors: make(map[string]string)}
+	return &validator{Errors: make(map[string]string), Codes: make(map[string]Message), policy: DefaultPolicy.WithDefaults()}
 }
 
 func (v *validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-func (v *validator) AddError(key, message string) {
+func (v *validator) AddError(key string, message Message) {
 	_, exists := v.Errors[key]
 	if !exists {
-		v.Errors[key] = message
+		v.Errors[key] = message.String()
+		v.Codes[key] = message
 	}
 }
 
-func (v *validator) Check(ok bool, key, message string) {
+func (v *validator) Check(ok bool, key string, message Message) {
 	if !ok {
 		v.AddError(key, message)
 	}
 }
 
 const (
-	maxEmailBytes     = 255
-	minUsernameLength = 4
-	maxUsernameLength = 15
-	minNameLength     = 2
-	maxNameLength     = 32
-	minPasswordLength = 6
-	maxPasswordBytes  = 72
+	maxEmailBytes    = 255
+	minNameLength    = 2
+	maxNameLength    = 32
+	maxPasswordBytes = 72
+	maxUsernameBytes = 255
 )
 
 var (
-	usernameRX        = regexp.MustCompile("^[_]*[a-zA-Z0-9]+[a-zA-Z0-9_]*$")
-	emailRX           = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
-	reservedUsernames = []string{"register", "login", "test", "admin", "root"}
+	usernameRX = regexp.MustCompile("^[_]*[a-zA-Z0-9]+[a-zA-Z0-9_]*$")
+	emailRX    = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	digitsRX   = regexp.MustCompile("^[0-9]+$")
 )
 
 // in returns true if a specific value is in the list.
@@ -80,26 +85,42 @@ func notEmpty(s string) bool {
 }
 
 func ValidateEmail(v *validator, address string) {
-	v.Check(notEmpty(address), "email", "must be provided")
-	v.Check(len(address) <= maxEmailBytes, "email", fmt.Sprintf("cannot be longer than %d bytes", maxEmailBytes))
-	v.Check(matches(address, emailRX), "email", "must be a valid email address")
+	v.Check(notEmpty(address), "email", NewMessage(MsgRequired))
+	v.Check(len(address) <= maxEmailBytes, "email", NewMessage(MsgTooLongBytes, maxEmailBytes))
+	v.Check(matches(address, emailRX), "email", NewMessage(MsgInvalidEmail))
 }
 
 func ValidateUsername(v *validator, username string) {
-	v.Check(notEmpty(username), "username", "must be provided")
-	v.Check(utf8.RuneCountInString(username) >= minUsernameLength, "username", fmt.Sprintf("cannot be shorter than %d characters", minUsernameLength))
-	v.Check(utf8.RuneCountInString(username) <= maxUsernameLength, "username", fmt.Sprintf("cannot be longer than %d characters", maxUsernameLength))
-	v.Check(matches(username, usernameRX), "username", "can only contain alphanumeric characters and underscores")
-	v.Check(!in(username, reservedUsernames...), "username", "cannot be a reserved name, for example, login, register etc.")
+	p := v.policy
+	v.Check(notEmpty(username), "username", NewMessage(MsgRequired))
+	v.Check(utf8.RuneCountInString(username) >= p.MinUsernameLength, "username", NewMessage(MsgTooShort, p.MinUsernameLength))
+	v.Check(utf8.RuneCountInString(username) <= p.MaxUsernameLength, "username", NewMessage(MsgTooLong, p.MaxUsernameLength))
+	v.Check(matches(username, p.UsernamePattern), "username", p.UsernameMessage)
+	v.Check(!p.ReservedUsernames.Contains(username), "username", NewMessage(MsgReservedUsername))
 }
 
 func validateName(v *validator, name string) {
-	v.Check(notEmpty(name), "name", "must be provided")
-	v.Check(utf8.RuneCountInString(name) <= maxNameLength, "name", fmt.Sprintf("cannot be longer than %d characters", maxNameLength))
+	v.Check(notEmpty(name), "name", NewMessage(MsgRequired))
+	v.Check(utf8.RuneCountInString(name) <= maxNameLength, "name", NewMessage(MsgTooLong, maxNameLength))
 }
 
+// ValidatePassword checks a new password by the policy.
 func ValidatePassword(v *validator, password string) {
-	v.Check(notEmpty(password), "password", "must be provided")
-	v.Check(utf8.RuneCountInString(password) >= minPasswordLength, "password", fmt.Sprintf("cannot be shorter than %d characters", minPasswordLength))
-	v.Check(len(password) <= maxPasswordBytes, "password", fmt.Sprintf("cannot be longer than %d bytes", maxPasswordBytes))
+	p := v.policy
+	v.Check(notEmpty(password), "password", NewMessage(MsgRequired))
+	v.Check(utf8.RuneCountInString(password) >= p.MinPasswordLength, "password", NewMessage(MsgTooShort, p.MinPasswordLength))
+	v.Check(len(password) <= p.MaxPasswordBytes, "password", NewMessage(MsgTooLongBytes, p.MaxPasswordBytes))
+	v.Check(passwordCharClasses(password) >= p.PasswordCharClasses, "password", NewMessage(MsgPasswordClasses, p.PasswordCharClasses))
+}
+
+// validateCurrentPassword checks a password which is matched against the current one,
+// the current passwords may be set before the policy is changed.
+func validateCurrentPassword(v *validator, password string) {
+	v.Check(notEmpty(password), "password", NewMessage(MsgRequired))
+	v.Check(len(password) <= maxPasswordBytes, "password", NewMessage(MsgTooLongBytes, maxPasswordBytes))
+}
+
+func ValidateTOTPCode(v *validator, code string) {
+	v.Check(notEmpty(code), "code", NewMessage(MsgRequired))
+	v.Check(len(code) == totpDigits && matches(code, digitsRX), "code", NewMessage(MsgInvalidFormat))
 }
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webhook events.
+const (
+	EventUserCreated             = "user.created"
+	EventUserEmailAdded          = "user.email_added"
+	EventUserEmailVerified       = "user.email_verified"
+	EventUserPrimaryEmailChanged = "user.primary_email_changed"
+	EventUserUsernameChanged     = "user.username_changed"
+	EventUserPasswordChanged     = "user.password_changed"
+	EventUserAccountLinked       = "user.account_linked"
+	EventUserAccountUnlinked     = "user.account_unlinked"
+	EventUserTOTPEnabled         = "user.totp_enabled"
+	EventUserTOTPDisabled        = "user.totp_disabled"
+	EventUserDeleted             = "user.deleted"
+)
+
+// WebhookEvents lists all the events a webhook can subscribe to.
+var WebhookEvents = []string{
+	EventUserCreated,
+	EventUserEmailAdded,
+	EventUserEmailVerified,
+	EventUserPrimaryEmailChanged,
+	EventUserUsernameChanged,
+	EventUserPasswordChanged,
+	EventUserAccountLinked,
+	EventUserAccountUnlinked,
+	EventUserTOTPEnabled,
+	EventUserTOTPDisabled,
+	EventUserDeleted,
+}
+
+// webhook request headers.
+const (
+	WebhookHeaderEvent     = "X-Auth-Event"
+	WebhookHeaderDelivery  = "X-Auth-Delivery"
+	WebhookHeaderSignature = "X-Auth-Signature"
+)
+
+// webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookService interface {
+	CreateWebhook(ctx context.Context, webhook WebhookInput) (*Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	ListWebhookDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, id int) (*WebhookDeliveryDetail, error)
+	RedeliverWebhook(ctx context.Context, deliveryID int) error
+}
+
+// Webhook represents an endpoint which is notified about user events.
+//
+// Secret is used to sign the payloads, and it's only returned once
+// when the webhook is created.
+type Webhook struct {
+	ID      int       `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	Active  bool      `json:"active"`
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// WebhookDelivery represents a single event to be delivered to a webhook.
+type WebhookDelivery struct {
+	ID          int             `json:"id"`
+	WebhookID   int             `json:"webhook_id"`
+	EventID     string          `json:"event_id"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	Created     time.Time       `json:"created"`
+	Updated     time.Time       `json:"updated"`
+}
+
+// WebhookAttempt represents a single try of delivering an event.
+type WebhookAttempt struct {
+	DeliveryID int        `json:"delivery_id"`
+	StatusCode int        `json:"status_code"`
+	Error      NullString `json:"error"`
+	Duration   int        `json:"duration_ms"`
+	Created    time.Time  `json:"created"`
+}
+
+// WebhookEvent is the body sent to webhook endpoints.
+type WebhookEvent struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Created time.Time `json:"created"`
+	Data    any       `json:"data"`
+}
+
+// WebhookEventData is the data of the user events.
+type WebhookEventData struct {
+	User    User     `json:"user"`
+	Email   *string  `json:"email,omitempty"`
+	Account *Account `json:"account,omitempty"`
+}
+
+//
+// Inputs
+//
+
+type WebhookInput struct {
+	URL    string
+	Events []string
+}
+
+func (w WebhookInput) Validate(v *validator) {
+	v.Check(notEmpty(w.URL), "url", NewMessage(MsgRequired))
+	v.Check(validWebhookURL(w.URL), "url", NewMessage(MsgInvalidURL))
+	v.Check(len(w.Events) > 0, "events", NewMessage(MsgNoItems))
+	v.Check(unique(w.Events), "events", NewMessage(MsgDuplicateItems))
+	for _, e := range w.Events {
+		v.Check(in(e, WebhookEvents...), "events", NewMessage(MsgUnknownItems))
+	}
+}
+
+//
+// Combining
+//
+
+type WebhookDeliveryDetail struct {
+	WebhookDelivery
+	Log []WebhookAttempt `json:"log"`
+}
+
+//
+// Helpers
+//
+
+func validWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// SignWebhook returns the signature header value of the body.
+//
+// The signature is the hex encoded HMAC-SHA256 of "timestamp.body",
+// formatted as "t=<timestamp>,v1=<signature>".
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", t, webhookMAC(secret, t, body))
+}
+
+// VerifyWebhook checks the signature header value of the body.
+// Signatures older than tolerance are rejected to prevent replay attacks.
+func VerifyWebhook(secret, header string, body []byte, tolerance time.Duration) bool {
+	var t, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			sig = v
+		}
+	}
+
+	ts, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || sig == "" {
+		return false
+	}
+	if time.Since(time.Unix(ts, 0)) > tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(webhookMAC(secret, t, body)))
+}
+
+func webhookMAC(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"event":"user.created"}`)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		want   bool
+	}{
+		{"valid", "secret", SignWebhook("secret", now, body), body, true},
+		{"wrong secret", "other", SignWebhook("secret", now, body), body, false},
+		{"modified body", "secret", SignWebhook("secret", now, body), []byte(`{}`), false},
+		{"expired", "secret", SignWebhook("secret", now.Add(-10*time.Minute), body), body, false},
+		{"no signature", "secret", "t=1", body, false},
+		{"no timestamp", "secret", "v1=abc", body, false},
+		{"empty", "secret", "", body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyWebhook(tt.secret, tt.header, tt.body, 5*time.Minute); got != tt.want {
+				t.Errorf("VerifyWebhook() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}